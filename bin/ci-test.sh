@@ -8,4 +8,4 @@ export PATH=$GOPATH/bin:$PATH
 
 cd $GOPATH/src/github.com/anynines/cf_service_jumper_cli_plugin
 
-go test
+go test . ./jumperapi/... ./plugin/...
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/anynines/cf_service_jumper_cli_plugin/jumperapi"
+)
+
+// CfAPIClient requests the cf v3 api with the access token of the cf cli
+type CfAPIClient struct {
+	Endpoint    string
+	HTTPClient  *http.Client
+	TokenSource jumperapi.TokenSource
+}
+
+// CfAPIError is returned for cf api responses with an unexpected status code
+type CfAPIError struct {
+	StatusCode int
+	Detail     string
+}
+
+func (e *CfAPIError) Error() string {
+	return fmt.Sprintf("[ERR] cf api request failed. %d %s. %s", e.StatusCode, http.StatusText(e.StatusCode), e.Detail)
+}
+
+// Get requests path with query and unmarshals the json response into result
+func (c CfAPIClient) Get(path string, query url.Values, result interface{}) error {
+	requestURL := strings.TrimSuffix(c.Endpoint, "/") + path
+	if len(query) > 0 {
+		requestURL += "?" + query.Encode()
+	}
+
+	token, err := c.TokenSource.Token()
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest("GET", requestURL, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", token)
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("[ERR] cf api request GET %s failed. %s", requestURL, err)
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("[ERR] cf api request GET %s failed. %s", requestURL, err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return newCfAPIError(resp.StatusCode, body)
+	}
+
+	err = json.Unmarshal(body, result)
+	if err != nil {
+		return fmt.Errorf("[ERR] cf api response of GET %s unmarshal failed. %s", requestURL, err)
+	}
+	return nil
+}
+
+// newCfAPIError parses v3 error bodies like {"errors": [{"detail": "..."}]}
+func newCfAPIError(statusCode int, body []byte) *CfAPIError {
+	var errorBody struct {
+		Errors []struct {
+			Detail string `json:"detail"`
+		} `json:"errors"`
+	}
+	cfAPIError := &CfAPIError{StatusCode: statusCode, Detail: strings.TrimSpace(string(body))}
+	if json.Unmarshal(body, &errorBody) == nil && len(errorBody.Errors) > 0 {
+		details := make([]string, len(errorBody.Errors))
+		for i, e := range errorBody.Errors {
+			details[i] = e.Detail
+		}
+		cfAPIError.Detail = strings.Join(details, ", ")
+	}
+	return cfAPIError
+}
@@ -1,29 +1,225 @@
 package main
 
 import (
+	"encoding/json"
 	"fmt"
+	"net"
+	"sort"
 	"strconv"
+	"strings"
+
+	"github.com/anynines/cf_service_jumper_cli_plugin/jumperapi"
+	"github.com/anynines/cf_service_jumper_cli_plugin/plugin/redact"
 )
 
+// ForwardSbCredentials holds the service binding credentials including nested
+// objects and arrays as returned by the service broker.
 type ForwardSbCredentials map[string]interface{}
 
+// CredentialsMap returns the flattened credentials.
 func (self ForwardSbCredentials) CredentialsMap() map[string]string {
-	credentials := make(map[string]string)
+	return self.Flatten()
+}
 
+// Flatten returns all credentials as strings. Keys of nested objects are joined
+// with dots (e.g. ssl.cacrt), arrays of scalars are joined with commas and
+// arrays containing objects are indexed (e.g. nodes.0.host).
+func (self ForwardSbCredentials) Flatten() map[string]string {
+	flattened := make(map[string]string)
 	for k, v := range self {
-		switch value := v.(type) {
-		case string:
-			credentials[k] = value
-		case int:
-			credentials[k] = strconv.Itoa(value)
-		case float64:
-			credentials[k] = fmt.Sprint(value)
-		default:
+		flattenCredential(flattened, k, v)
+	}
+	return flattened
+}
+
+// EnvVars returns the flattened credentials as sorted KEY=value lines in dotenv
+// notation, e.g. SSL_CACRT="-----BEGIN CERTIFICATE-----\n...".
+func (self ForwardSbCredentials) EnvVars() []string {
+	flattened := self.Flatten()
+
+	keys := make([]string, 0, len(flattened))
+	for k := range flattened {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	envVars := make([]string, len(keys))
+	for i, k := range keys {
+		envVars[i] = envVarName(k) + "=" + envVarValue(flattened[k])
+	}
+	return envVars
+}
+
+func flattenCredential(flattened map[string]string, key string, value interface{}) {
+	switch value := value.(type) {
+	case map[string]interface{}:
+		for k, v := range value {
+			flattenCredential(flattened, key+"."+k, v)
+		}
+	case []interface{}:
+		if !isScalarSlice(value) {
+			for i, v := range value {
+				flattenCredential(flattened, key+"."+strconv.Itoa(i), v)
+			}
+			return
 		}
+
+		values := make([]string, len(value))
+		for i, v := range value {
+			values[i] = formatCredentialValue(v)
+		}
+		flattened[key] = strings.Join(values, ",")
+	default:
+		flattened[key] = formatCredentialValue(value)
+	}
+}
+
+func isScalarSlice(values []interface{}) bool {
+	for _, v := range values {
+		switch v.(type) {
+		case map[string]interface{}, []interface{}:
+			return false
+		}
+	}
+	return true
+}
+
+// formatCredentialValue formats scalars. Numbers are never printed in
+// exponent notation, e.g. port 5432 instead of 5.432e+03.
+func formatCredentialValue(value interface{}) string {
+	switch value := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return value
+	case int:
+		return strconv.Itoa(value)
+	case float64:
+		return strconv.FormatFloat(value, 'f', -1, 64)
+	case bool:
+		return strconv.FormatBool(value)
+	case json.Number:
+		return value.String()
+	}
+	return fmt.Sprint(value)
+}
+
+func envVarName(key string) string {
+	return strings.ToUpper(strings.Map(func(r rune) rune {
+		if (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') {
+			return r
+		}
+		return '_'
+	}, key))
+}
+
+func envVarValue(value string) string {
+	if !strings.ContainsAny(value, " \t\n\"'#$\\") {
+		return value
+	}
+	return strconv.Quote(value)
+}
+
+// WithLocalAddresses returns a copy of the credentials whose host, port, hosts
+// and uri point to the local tunnel addresses. Elements of hosts keep the
+// format of the broker: host only or host:port. Since every tunnel has its own
+// local port, host only elements get the port as well if there are several tunnels.
+func (self ForwardSbCredentials) WithLocalAddresses(localAddresses []string) ForwardSbCredentials {
+	credentials := make(ForwardSbCredentials)
+	for k, v := range self {
+		credentials[k] = v
 	}
+	if len(localAddresses) < 1 {
+		return credentials
+	}
+
+	localHost, localPort, err := net.SplitHostPort(localAddresses[0])
+	if err != nil {
+		return credentials
+	}
+
+	if _, ok := self["host"]; ok {
+		credentials["host"] = localHost
+	}
+	if _, ok := self["port"]; ok {
+		port, _ := strconv.Atoi(localPort)
+		credentials["port"] = port
+	}
+	if hosts, ok := self["hosts"].([]interface{}); ok {
+		credentials["hosts"] = localHosts(hosts, localAddresses)
+	}
+	if uri, ok := self["uri"].(string); ok {
+		serviceURI, err := ParseServiceURI(uri)
+		if err == nil {
+			if len(serviceURI.Hosts) > 1 {
+				serviceURI.Hosts = localAddresses
+			} else {
+				serviceURI.Hosts = localAddresses[:1]
+			}
+			credentials["uri"] = serviceURI.String()
+		}
+	}
+
 	return credentials
 }
 
+// Masked returns a copy of the credentials whose secrets and uri passwords are
+// replaced by placeholder, e.g. to display connection strings built from them
+func (self ForwardSbCredentials) Masked(placeholder string) ForwardSbCredentials {
+	return ForwardSbCredentials(maskCredential("", map[string]interface{}(self), placeholder).(map[string]interface{}))
+}
+
+func maskCredential(key string, value interface{}, placeholder string) interface{} {
+	switch value := value.(type) {
+	case map[string]interface{}:
+		masked := make(map[string]interface{}, len(value))
+		for k, v := range value {
+			masked[k] = maskCredential(k, v, placeholder)
+		}
+		return masked
+	case []interface{}:
+		masked := make([]interface{}, len(value))
+		for i, v := range value {
+			masked[i] = maskCredential(key, v, placeholder)
+		}
+		return masked
+	case nil:
+		return nil
+	}
+	if redact.IsSecretKey(key) {
+		return placeholder
+	}
+	if uri, ok := value.(string); ok && strings.Contains(uri, "://") {
+		serviceURI, err := ParseServiceURI(uri)
+		if err == nil && len(serviceURI.Password) > 0 {
+			serviceURI.Password = placeholder
+			return serviceURI.String()
+		}
+	}
+	return value
+}
+
+// localHosts returns the hosts credential pointing to the local addresses
+func localHosts(hosts []interface{}, localAddresses []string) []interface{} {
+	hostOnly := len(localAddresses) == 1
+	for _, host := range hosts {
+		if host, ok := host.(string); ok {
+			if _, _, err := net.SplitHostPort(host); err == nil {
+				hostOnly = false
+			}
+		}
+	}
+
+	result := make([]interface{}, len(localAddresses))
+	for i, localAddress := range localAddresses {
+		result[i] = localAddress
+		if localHost, _, err := net.SplitHostPort(localAddress); err == nil && hostOnly {
+			result[i] = localHost
+		}
+	}
+	return result
+}
+
 type ForwardCredentials struct {
 	Credentials ForwardSbCredentials `json:"credentials"`
 }
@@ -35,6 +231,18 @@ type ForwardDataSet struct {
 	Credentials  ForwardCredentials `json:"credentials"`
 }
 
+// NewForwardDataSet converts a forward of the service jumper api
+func NewForwardDataSet(forward jumperapi.Forward) ForwardDataSet {
+	return ForwardDataSet{
+		ID:           forward.ID,
+		Hosts:        forward.Hosts,
+		SharedSecret: forward.SharedSecret,
+		Credentials: ForwardCredentials{
+			Credentials: ForwardSbCredentials(forward.Credentials.Credentials),
+		},
+	}
+}
+
 // Returns map with credential information
 func (self ForwardDataSet) CredentialsMap() map[string]string {
 	return self.Credentials.Credentials.CredentialsMap()
@@ -0,0 +1,491 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/cloudfoundry/cli/plugin"
+)
+
+var (
+	OutputFormats = []string{"text", "json"}
+
+	ErrUnknownCommand = errors.New("[ERR] unknown command")
+)
+
+// Option is a command line option. Options without Values are boolean flags.
+type Option struct {
+	Name   string
+	Short  string
+	Values []string
+	Usage  string
+}
+
+// usageName returns the option as shown in usages, e.g. --export-profile FORMAT FILE
+func (o Option) usageName() string {
+	return strings.Join(append([]string{o.Name}, o.Values...), " ")
+}
+
+// CommandSpec describes a plugin command with its arguments and options
+type CommandSpec struct {
+	Name     string
+	HelpText string
+	Usage    string
+	// Args names the positional arguments, optional ones are enclosed in brackets
+	Args    []string
+	Options []Option
+	// ServiceInstance is set if the first argument is a service instance which
+	// may be given by --guid instead
+	ServiceInstance bool
+	// Passthrough is set if a command follows "--"
+	Passthrough bool
+	// JSONOutput is set if the command supports --output json
+	JSONOutput bool
+	// Validate checks the arguments beyond their count
+	Validate func(commandLine *CommandLine) error
+}
+
+// GlobalOptions are accepted by all commands
+var GlobalOptions = []Option{
+	{Name: "--verbose", Short: "-v", Usage: "Show retries, endpoint discovery and tunnel events on stderr"},
+	{Name: "--debug", Short: "-vv", Usage: "Show debug logs on stderr, e.g. api calls and client connections"},
+	{Name: "--output", Values: []string{"FORMAT"}, Usage: "Output format: text (default) or json"},
+	{Name: "--debug-bundle", Values: []string{"FILE"}, Usage: "Write versions, settings, endpoint discovery, api calls and logs without secrets to a tar.gz for support tickets"},
+}
+
+// ServiceInstanceOptions select the service instance of service commands
+var ServiceInstanceOptions = []Option{
+	{Name: "--org", Values: []string{"ORG"}, Usage: "Org of the service instance, defaults to the targeted org"},
+	{Name: "--space", Values: []string{"SPACE"}, Usage: "Space of the service instance, all spaces of the org are searched if only --org is given"},
+	{Name: "--guid", Values: []string{"GUID"}, Usage: "GUID of the service instance instead of SERVICE_INSTANCE, skips the lookup"},
+}
+
+// MetricsOption serves prometheus metrics of the tunnels of long-running commands
+var MetricsOption = Option{Name: "--metrics-addr", Values: []string{"ADDRESS"}, Usage: "Serve prometheus metrics of the tunnels on ADDRESS, e.g. 127.0.0.1:9099"}
+
+// ControlOption serves the control API of long-running commands
+var ControlOption = Option{Name: "--control", Values: []string{"ADDRESS"}, Usage: "Serve the control API on unix:PATH or on a loopback ADDRESS with a token, e.g. unix:/tmp/forward.sock"}
+
+// ControlCredentialsOption adds the credentials to the control API
+var ControlCredentialsOption = Option{Name: "--control-credentials", Usage: "Serve the credentials on the control API"}
+
+// ReadyOptions verify and signal the readiness of long-running commands
+var ReadyOptions = []Option{
+	{Name: "--health-check", Usage: "Verify every host with a handshake of the service protocol through its tunnel"},
+	{Name: "--ready-file", Values: []string{"PATH"}, Usage: "Write the ports, hosts and forward id as JSON to PATH once the tunnels listen"},
+	{Name: "--ready-fd", Values: []string{"N"}, Usage: "Write the ready JSON to the inherited file descriptor N and close it"},
+	{Name: "--ready-handshake", Usage: "Verify a handshake with a host before signalling readiness"},
+}
+
+// validateLongRunning checks the control and readiness options of commands
+// keeping a forward open
+func validateLongRunning(commandLine *CommandLine) error {
+	err := validateControl(commandLine)
+	if err != nil {
+		return err
+	}
+	return validateReady(commandLine)
+}
+
+func validateControl(commandLine *CommandLine) error {
+	if commandLine.Bool("--control-credentials") && len(commandLine.String("--control")) < 1 {
+		return errors.New("[ERR] --control-credentials requires --control")
+	}
+	return nil
+}
+
+// CommandSpecs of all plugin commands
+var CommandSpecs = []CommandSpec{
+	{
+		Name:            "create-forward",
+		HelpText:        "Creates/Recycles forward to service instance.",
+		Usage:           "cf create-forward (SERVICE_INSTANCE | --guid GUID) [OPTIONS]",
+		Args:            []string{"SERVICE_INSTANCE"},
+		ServiceInstance: true,
+		Options: append([]Option{
+			{Name: "--export-profile", Values: []string{"FORMAT", "FILE"}, Usage: "Export a connection profile: " + strings.Join(ProfileFormats, ", ")},
+			{Name: "--save-password", Usage: "Store the password in the exported profile"},
+			{Name: "--plain", Usage: "Keep the plain output instead of showing the dashboard in a terminal"},
+			MetricsOption,
+			ControlOption,
+			ControlCredentialsOption,
+		}, ReadyOptions...),
+		Validate: func(commandLine *CommandLine) error {
+			exportProfile := commandLine.Values("--export-profile")
+			if len(exportProfile) > 0 && !stringInStrSlice(exportProfile[0], ProfileFormats) {
+				return fmt.Errorf("[ERR] unknown profile format %s. Supported formats: %s", exportProfile[0], strings.Join(ProfileFormats, ", "))
+			}
+			if commandLine.Bool("--save-password") && len(exportProfile) < 1 {
+				return errors.New("[ERR] --save-password requires --export-profile")
+			}
+			return validateLongRunning(commandLine)
+		},
+	},
+	{
+		Name:            "forward-env",
+		HelpText:        "Creates forward to service instance and prints connection strings for local apps.",
+		Usage:           "cf forward-env (SERVICE_INSTANCE | --guid GUID) [OPTIONS]",
+		Args:            []string{"SERVICE_INSTANCE"},
+		ServiceInstance: true,
+		JSONOutput:      true,
+		Options: append([]Option{
+			{Name: "--format", Values: []string{"FORMAT"}, Usage: "Connection string format: " + strings.Join(EnvFormats, ", ") + ". Defaults to the connection_format setting"},
+			MetricsOption,
+			ControlOption,
+			ControlCredentialsOption,
+		}, ReadyOptions...),
+		Validate: func(commandLine *CommandLine) error {
+			// the connection_format setting is validated when it is set
+			if format := commandLine.String("--format"); len(format) > 0 && !stringInStrSlice(format, EnvFormats) {
+				return fmt.Errorf("[ERR] unknown format %s. Supported formats: %s", format, strings.Join(EnvFormats, ", "))
+			}
+			return validateLongRunning(commandLine)
+		},
+	},
+	{
+		Name:        "forward-app",
+		HelpText:    "Creates forwards to all services bound to an app and runs a local command with VCAP_SERVICES pointing to them.",
+		Usage:       "cf forward-app APP_NAME [OPTIONS] -- COMMAND [ARGS...]",
+		Args:        []string{"APP_NAME"},
+		Passthrough: true,
+	},
+	{
+		Name:            "delete-forward",
+		HelpText:        "Deletes forward to service instance.",
+		Usage:           "cf delete-forward (SERVICE_INSTANCE | --guid GUID) CONNECTION_ID [OPTIONS]",
+		Args:            []string{"SERVICE_INSTANCE", "CONNECTION_ID"},
+		ServiceInstance: true,
+		Validate: func(commandLine *CommandLine) error {
+			if _, err := strconv.Atoi(commandLine.Arg(1)); err != nil {
+				return ErrInvalidConnectionID
+			}
+			return nil
+		},
+	},
+	{
+		Name:            "list-forwards",
+		HelpText:        "List open forwards to service instance.",
+		Usage:           "cf list-forwards (SERVICE_INSTANCE | --guid GUID) [OPTIONS]",
+		Args:            []string{"SERVICE_INSTANCE"},
+		ServiceInstance: true,
+		JSONOutput:      true,
+	},
+	{
+		Name:            "forward-doctor",
+		HelpText:        "Checks login, endpoint discovery and the service jumper api. With a service instance it also opens a test forward and connects to every host.",
+		Usage:           "cf forward-doctor [SERVICE_INSTANCE | --guid GUID] [OPTIONS]",
+		Args:            []string{"[SERVICE_INSTANCE]"},
+		ServiceInstance: true,
+		JSONOutput:      true,
+	},
+	{
+		Name:     "forward-api",
+		HelpText: "Show/Set/Delete the service jumper api url of the targeted cf api.",
+		Usage:    "cf forward-api [SERVICE_JUMPER_API_URL] [OPTIONS]",
+		Args:     []string{"[SERVICE_JUMPER_API_URL]"},
+		Options: []Option{
+			{Name: "--delete", Short: "-d", Usage: "Delete the service jumper api url of the targeted cf api"},
+			{Name: "--explain", Usage: "Show which source provided the url and why the others failed"},
+		},
+		Validate: func(commandLine *CommandLine) error {
+			if len(commandLine.Args) > 0 && (commandLine.Bool("--delete") || commandLine.Bool("--explain")) {
+				return errors.New("[ERR] SERVICE_JUMPER_API_URL can't be combined with --delete or --explain")
+			}
+			return nil
+		},
+	},
+	{
+		Name:       "forward-config",
+		HelpText:   "Get/Set/Unset/List the settings of the forward commands.",
+		Usage:      "cf forward-config list\n   cf forward-config get KEY\n   cf forward-config set KEY VALUE\n   cf forward-config unset KEY",
+		Args:       []string{"[SUBCOMMAND]", "[KEY]", "[VALUE]"},
+		JSONOutput: true,
+		Validate:   ValidateForwardConfigArgs,
+	},
+	{
+		Name:     "forward-completion",
+		HelpText: "Prints the completion script of the forward commands for bash, zsh or fish.",
+		Usage:    "cf forward-completion SHELL\n\nEXAMPLES:\n   source <(cf forward-completion bash)\n   cf forward-completion fish | source",
+		Args:     []string{"SHELL"},
+		Validate: func(commandLine *CommandLine) error {
+			if !stringInStrSlice(commandLine.Arg(0), CompletionShells) {
+				return ErrUnknownShell
+			}
+			return nil
+		},
+	},
+	{
+		Name:        "forward-complete",
+		HelpText:    "Internal, used by the scripts of forward-completion. Prints the completion candidates of a command line.",
+		Usage:       "cf forward-complete -- COMMAND [ARGS...] WORD",
+		Passthrough: true,
+	},
+}
+
+// LookupCommandSpec returns the spec of the command name
+func LookupCommandSpec(name string) (CommandSpec, error) {
+	for _, spec := range CommandSpecs {
+		if spec.Name == name {
+			return spec, nil
+		}
+	}
+	return CommandSpec{}, ErrUnknownCommand
+}
+
+// options returns the command and global options
+func (s CommandSpec) options() []Option {
+	options := append([]Option{}, s.Options...)
+	if s.ServiceInstance {
+		options = append(options, ServiceInstanceOptions...)
+	}
+	return append(options, GlobalOptions...)
+}
+
+func (s CommandSpec) lookupOption(name string) (Option, bool) {
+	for _, option := range s.options() {
+		if option.Name == name || (len(option.Short) > 0 && option.Short == name) {
+			return option, true
+		}
+	}
+	return Option{}, false
+}
+
+// UsageOptions returns the option descriptions for plugin.Usage
+func (s CommandSpec) UsageOptions() map[string]string {
+	usageOptions := make(map[string]string)
+	for _, option := range s.options() {
+		name := strings.TrimPrefix(option.usageName(), "--")
+		if len(option.Short) > 0 {
+			name = strings.TrimPrefix(option.Short, "-") + ", " + name
+		}
+		usageOptions[name] = option.Usage
+	}
+	return usageOptions
+}
+
+// UsageText returns the usage with all options, shown on validation errors
+func (s CommandSpec) UsageText() string {
+	lines := []string{"Usage:", "   " + s.Usage, "", "Options:"}
+
+	options := s.options()
+	names := make([]string, len(options))
+	width := 0
+	for i, option := range options {
+		names[i] = option.usageName()
+		if len(option.Short) > 0 {
+			names[i] = option.Short + ", " + names[i]
+		}
+		if len(names[i]) > width {
+			width = len(names[i])
+		}
+	}
+	for i, option := range options {
+		lines = append(lines, fmt.Sprintf("   %-*s   %s", width, names[i], option.Usage))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// PluginCommand returns the command metadata of the cf cli
+func (s CommandSpec) PluginCommand() plugin.Command {
+	return plugin.Command{
+		Name:     s.Name,
+		HelpText: s.HelpText,
+		UsageDetails: plugin.Usage{
+			Usage:   s.Usage,
+			Options: s.UsageOptions(),
+		},
+	}
+}
+
+// UsageError is returned if the command line is invalid. The hint shows the usage.
+type UsageError struct {
+	Spec CommandSpec
+	Err  error
+}
+
+func (e *UsageError) Error() string {
+	return e.Err.Error()
+}
+
+// Hint returns the usage of the command
+func (e *UsageError) Hint() string {
+	return "\n" + e.Spec.UsageText()
+}
+
+// CommandLine is a parsed command line
+type CommandLine struct {
+	Spec CommandSpec
+	// Args are the positional arguments without the command name
+	Args []string
+	// Passthrough is the command following "--"
+	Passthrough []string
+
+	options map[string][]string
+}
+
+// ParseCommandLine parses the args passed by the cf cli. Options and positional
+// arguments may be interspersed, everything after "--" is left untouched.
+func ParseCommandLine(args []string) (*CommandLine, error) {
+	if len(args) < 1 {
+		return nil, ErrUnknownCommand
+	}
+	spec, err := LookupCommandSpec(args[0])
+	if err != nil {
+		return nil, err
+	}
+
+	commandLine := &CommandLine{Spec: spec, Args: []string{}, options: make(map[string][]string)}
+	err = commandLine.parse(args[1:])
+	if err != nil {
+		return nil, &UsageError{Spec: spec, Err: err}
+	}
+	return commandLine, nil
+}
+
+func (l *CommandLine) parse(args []string) error {
+	for index := 0; index < len(args); index++ {
+		arg := args[index]
+		if arg == "--" {
+			if !l.Spec.Passthrough {
+				return fmt.Errorf("[ERR] cf %s doesn't run commands, unexpected %s", l.Spec.Name, strings.Join(args[index:], " "))
+			}
+			l.Passthrough = args[index+1:]
+			break
+		}
+		if len(arg) < 2 || !strings.HasPrefix(arg, "-") {
+			l.Args = append(l.Args, arg)
+			continue
+		}
+
+		name, inlineValue, hasInlineValue := arg, "", false
+		if equals := strings.Index(arg, "="); equals > 0 {
+			name, inlineValue, hasInlineValue = arg[:equals], arg[equals+1:], true
+		}
+		option, ok := l.Spec.lookupOption(name)
+		if !ok {
+			return fmt.Errorf("[ERR] unknown option %s", name)
+		}
+
+		var values []string
+		switch {
+		case hasInlineValue && len(option.Values) != 1:
+			return fmt.Errorf("[ERR] option %s doesn't take a value", option.usageName())
+		case hasInlineValue:
+			values = []string{inlineValue}
+		default:
+			if index+len(option.Values) >= len(args) {
+				return fmt.Errorf("[ERR] option %s requires %d value(s)", option.usageName(), len(option.Values))
+			}
+			values = args[index+1 : index+1+len(option.Values)]
+			index += len(option.Values)
+		}
+		l.options[option.Name] = values
+	}
+
+	return l.validate()
+}
+
+func (l *CommandLine) validate() error {
+	if output := l.Output(); !stringInStrSlice(output, OutputFormats) {
+		return fmt.Errorf("[ERR] unknown output format %s. Supported formats: %s", output, strings.Join(OutputFormats, ", "))
+	}
+	if l.Output() == "json" && !l.Spec.JSONOutput {
+		return fmt.Errorf("[ERR] cf %s doesn't support --output json", l.Spec.Name)
+	}
+
+	if l.Spec.ServiceInstance && len(l.String("--guid")) > 0 {
+		if len(l.Args) >= len(l.Spec.Args) {
+			return errors.New("[ERR] use either SERVICE_INSTANCE or --guid")
+		}
+		// the guid takes the place of SERVICE_INSTANCE
+		l.Args = append([]string{l.String("--guid")}, l.Args...)
+	}
+
+	required := 0
+	for _, arg := range l.Spec.Args {
+		if !strings.HasPrefix(arg, "[") {
+			required++
+		}
+	}
+	if len(l.Args) < required {
+		return fmt.Errorf("[ERR] missing %s", strings.Join(l.Spec.Args[len(l.Args):required], " "))
+	}
+	if len(l.Args) > len(l.Spec.Args) {
+		return fmt.Errorf("[ERR] unexpected argument(s) %s", strings.Join(l.Args[len(l.Spec.Args):], " "))
+	}
+	if l.Spec.Passthrough && len(l.Passthrough) < 1 {
+		return ErrMissingCommand
+	}
+
+	if l.Spec.Validate != nil {
+		return l.Spec.Validate(l)
+	}
+	return nil
+}
+
+// Arg returns the positional argument at index or a blank string
+func (l *CommandLine) Arg(index int) string {
+	if index >= len(l.Args) {
+		return ""
+	}
+	return l.Args[index]
+}
+
+// Values returns the values of the option or nil if it isn't set
+func (l *CommandLine) Values(name string) []string {
+	return l.options[name]
+}
+
+// String returns the value of the option or a blank string
+func (l *CommandLine) String(name string) string {
+	values := l.options[name]
+	if len(values) < 1 {
+		return ""
+	}
+	return values[0]
+}
+
+// StringOr returns the value of the option or defaultValue if it isn't set
+func (l *CommandLine) StringOr(name string, defaultValue string) string {
+	if _, ok := l.options[name]; !ok {
+		return defaultValue
+	}
+	return l.String(name)
+}
+
+// Bool returns whether the flag is set
+func (l *CommandLine) Bool(name string) bool {
+	_, ok := l.options[name]
+	return ok
+}
+
+// Verbose returns whether -v/--verbose is set
+func (l *CommandLine) Verbose() bool {
+	return l.Bool("--verbose")
+}
+
+// Output returns the --output format, text by default
+func (l *CommandLine) Output() string {
+	return l.StringOr("--output", "text")
+}
+
+// ServiceQuery returns the service instance selection of service commands
+func (l *CommandLine) ServiceQuery() ServiceQuery {
+	return ServiceQuery{
+		Name:  l.Arg(0),
+		GUID:  l.String("--guid"),
+		Org:   l.String("--org"),
+		Space: l.String("--space"),
+	}
+}
+
+// PluginCommands returns the metadata of all commands
+func PluginCommands() []plugin.Command {
+	commands := make([]plugin.Command, len(CommandSpecs))
+	for i, spec := range CommandSpecs {
+		commands[i] = spec.PluginCommand()
+	}
+	return commands
+}
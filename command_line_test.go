@@ -0,0 +1,118 @@
+package main_test
+
+import (
+	. "github.com/anynines/cf_service_jumper_cli_plugin"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("ParseCommandLine", func() {
+	It("parses arguments and options in any order", func() {
+		commandLine, err := ParseCommandLine([]string{"create-forward", "--export-profile", "dbeaver", "profile.json", "db", "-v", "--save-password"})
+		Expect(err).To(BeNil())
+		Expect(commandLine.Spec.Name).To(Equal("create-forward"))
+		Expect(commandLine.Args).To(Equal([]string{"db"}))
+		Expect(commandLine.Values("--export-profile")).To(Equal([]string{"dbeaver", "profile.json"}))
+		Expect(commandLine.Bool("--save-password")).To(BeTrue())
+		Expect(commandLine.Verbose()).To(BeTrue())
+		Expect(commandLine.Output()).To(Equal("text"))
+	})
+
+	It("parses --name=value", func() {
+		commandLine, err := ParseCommandLine([]string{"forward-env", "db", "--format=jdbc", "--output=json"})
+		Expect(err).To(BeNil())
+		Expect(commandLine.StringOr("--format", "uri")).To(Equal("jdbc"))
+		Expect(commandLine.Output()).To(Equal("json"))
+	})
+
+	It("returns the default of unset options", func() {
+		commandLine, err := ParseCommandLine([]string{"forward-env", "db"})
+		Expect(err).To(BeNil())
+		Expect(commandLine.StringOr("--format", "uri")).To(Equal("uri"))
+	})
+
+	It("extracts the service query", func() {
+		commandLine, err := ParseCommandLine([]string{"delete-forward", "--org", "the-org", "db", "--space", "the-space", "5"})
+		Expect(err).To(BeNil())
+		Expect(commandLine.ServiceQuery()).To(Equal(ServiceQuery{Name: "db", Org: "the-org", Space: "the-space"}))
+		Expect(commandLine.Arg(1)).To(Equal("5"))
+	})
+
+	It("uses the guid as SERVICE_INSTANCE", func() {
+		commandLine, err := ParseCommandLine([]string{"delete-forward", "--guid", "the-guid", "5"})
+		Expect(err).To(BeNil())
+		Expect(commandLine.ServiceQuery()).To(Equal(ServiceQuery{Name: "the-guid", GUID: "the-guid"}))
+		Expect(commandLine.Args).To(Equal([]string{"the-guid", "5"}))
+	})
+
+	It("leaves args after -- untouched", func() {
+		commandLine, err := ParseCommandLine([]string{"forward-app", "app", "--", "./run.sh", "-v"})
+		Expect(err).To(BeNil())
+		Expect(commandLine.Arg(0)).To(Equal("app"))
+		Expect(commandLine.Passthrough).To(Equal([]string{"./run.sh", "-v"}))
+		Expect(commandLine.Verbose()).To(BeFalse())
+	})
+
+	It("parses short flags", func() {
+		commandLine, err := ParseCommandLine([]string{"forward-api", "-d"})
+		Expect(err).To(BeNil())
+		Expect(commandLine.Bool("--delete")).To(BeTrue())
+	})
+
+	Describe("usage errors", func() {
+		usageErrors := []struct {
+			description string
+			args        []string
+			message     string
+		}{
+			{"missing argument", []string{"delete-forward", "db"}, "missing CONNECTION_ID"},
+			{"extra argument", []string{"list-forwards", "db", "extra"}, "unexpected argument(s) extra"},
+			{"unknown option", []string{"list-forwards", "db", "--foo"}, "unknown option --foo"},
+			{"missing option value", []string{"create-forward", "db", "--export-profile", "dbeaver"}, "requires 2 value(s)"},
+			{"value of flag", []string{"create-forward", "db", "--save-password=yes"}, "doesn't take a value"},
+			{"name and guid", []string{"list-forwards", "db", "--guid", "the-guid"}, "either SERVICE_INSTANCE or --guid"},
+			{"non numeric id", []string{"delete-forward", "db", "abc"}, ErrInvalidConnectionID.Error()},
+			{"unknown profile format", []string{"create-forward", "db", "--export-profile", "xml", "db.xml"}, "unknown profile format xml"},
+			{"unknown connection string format", []string{"forward-env", "db", "--format", "xml"}, "unknown format xml"},
+			{"save password without profile", []string{"create-forward", "db", "--save-password"}, "requires --export-profile"},
+			{"unknown output", []string{"list-forwards", "db", "--output", "xml"}, "unknown output format xml"},
+			{"unsupported json", []string{"create-forward", "db", "--output", "json"}, "doesn't support --output json"},
+			{"missing command", []string{"forward-app", "app"}, ErrMissingCommand.Error()},
+			{"command of other commands", []string{"list-forwards", "db", "--", "ls"}, "doesn't run commands"},
+			{"url and delete", []string{"forward-api", "https://jumper.example.com", "-d"}, "can't be combined"},
+			{"forward-config set without value", []string{"forward-config", "set", "timeout"}, ErrMissingSettingValue.Error()},
+			{"unknown forward-config command", []string{"forward-config", "foo"}, ErrUnknownForwardConfigCommand.Error()},
+		}
+
+		for _, usageError := range usageErrors {
+			usageError := usageError
+			It("errors on "+usageError.description, func() {
+				_, err := ParseCommandLine(usageError.args)
+				Expect(err).To(BeAssignableToTypeOf(&UsageError{}))
+				Expect(err.Error()).To(ContainSubstring(usageError.message))
+				Expect(ExitCode(err)).To(Equal(ExitCodeUsage))
+			})
+		}
+	})
+
+	It("shows the usage as hint", func() {
+		_, err := ParseCommandLine([]string{"create-forward"})
+		Expect(err.(*UsageError).Hint()).To(ContainSubstring("cf create-forward (SERVICE_INSTANCE | --guid GUID) [OPTIONS]"))
+		Expect(err.(*UsageError).Hint()).To(ContainSubstring("--export-profile FORMAT FILE"))
+	})
+})
+
+var _ = Describe("PluginCommands", func() {
+	It("documents every option", func() {
+		for _, command := range PluginCommands() {
+			if command.Name == "create-forward" {
+				Expect(command.UsageDetails.Options).To(HaveKey("export-profile FORMAT FILE"))
+				Expect(command.UsageDetails.Options).To(HaveKey("guid GUID"))
+				Expect(command.UsageDetails.Options).To(HaveKey("v, verbose"))
+				return
+			}
+		}
+		Fail("create-forward not found")
+	})
+})
@@ -0,0 +1,328 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/anynines/cf_service_jumper_cli_plugin/plugin/config"
+	"github.com/cloudfoundry/cli/plugin"
+)
+
+var (
+	CompletionShells = []string{"bash", "zsh", "fish"}
+
+	ErrUnknownShell = fmt.Errorf("[ERR] unknown shell. Supported shells: %s", strings.Join(CompletionShells, ", "))
+)
+
+// CompletionSources list the candidates which are looked up at completion time
+type CompletionSources struct {
+	// Services returns the service instance names of the targeted space
+	Services func() ([]string, error)
+	// ForwardIDs returns the ids of the open forwards of the service instance
+	ForwardIDs func(query ServiceQuery) ([]string, error)
+}
+
+// CompleteWords returns the candidates of the last word of a command line. words
+// starts with the command name, the last word is the one being completed.
+func CompleteWords(words []string, sources CompletionSources) []string {
+	if len(words) < 1 {
+		return nil
+	}
+	current := words[len(words)-1]
+	if len(words) == 1 {
+		return filterPrefix(completionCommandNames(), current)
+	}
+
+	spec, err := LookupCommandSpec(words[0])
+	if err != nil {
+		return nil
+	}
+
+	// walk the words before the current one like ParseCommandLine does
+	commandLine := &CommandLine{Spec: spec, Args: []string{}, options: make(map[string][]string)}
+	var pendingOption Option
+	pendingValues := 0
+	for _, word := range words[1 : len(words)-1] {
+		switch {
+		case pendingValues > 0:
+			commandLine.options[pendingOption.Name] = append(commandLine.options[pendingOption.Name], word)
+			pendingValues--
+		case word == "--":
+			// commands following "--" are completed by the shell
+			return nil
+		case len(word) > 1 && strings.HasPrefix(word, "-"):
+			option, ok := spec.lookupOption(strings.SplitN(word, "=", 2)[0])
+			if !ok {
+				continue
+			}
+			commandLine.options[option.Name] = []string{}
+			if !strings.Contains(word, "=") {
+				pendingOption, pendingValues = option, len(option.Values)
+			}
+		default:
+			commandLine.Args = append(commandLine.Args, word)
+		}
+	}
+
+	if pendingValues > 0 {
+		valueIndex := len(pendingOption.Values) - pendingValues
+		return filterPrefix(completeOptionValue(pendingOption, valueIndex), current)
+	}
+	if strings.HasPrefix(current, "-") {
+		return filterPrefix(completionOptionNames(spec), current)
+	}
+
+	argIndex := len(commandLine.Args)
+	if spec.ServiceInstance && len(commandLine.String("--guid")) > 0 {
+		// the guid takes the place of SERVICE_INSTANCE
+		argIndex++
+		commandLine.Args = append([]string{commandLine.String("--guid")}, commandLine.Args...)
+	}
+	if argIndex >= len(spec.Args) {
+		return nil
+	}
+	return filterPrefix(completeArg(spec.Args[argIndex], commandLine, sources), current)
+}
+
+func completeOptionValue(option Option, valueIndex int) []string {
+	switch {
+	case option.Name == "--output":
+		return OutputFormats
+	case option.Name == "--format":
+		return EnvFormats
+	case option.Name == "--export-profile" && valueIndex == 0:
+		return ProfileFormats
+	}
+	return nil
+}
+
+func completeArg(arg string, commandLine *CommandLine, sources CompletionSources) []string {
+	var candidates []string
+	var err error
+
+	switch arg {
+	case "SERVICE_INSTANCE", "[SERVICE_INSTANCE]":
+		if sources.Services != nil {
+			candidates, err = sources.Services()
+		}
+	case "CONNECTION_ID":
+		if sources.ForwardIDs != nil {
+			candidates, err = sources.ForwardIDs(commandLine.ServiceQuery())
+		}
+	case "SHELL":
+		candidates = CompletionShells
+	case "[SUBCOMMAND]":
+		candidates = []string{"list", "get", "set", "unset"}
+	case "[KEY]":
+		if commandLine.Arg(0) != "list" {
+			for _, setting := range config.Settings {
+				candidates = append(candidates, setting.Key)
+			}
+		}
+	case "[VALUE]":
+		if setting, lookupErr := config.LookupSetting(commandLine.Arg(1)); lookupErr == nil && commandLine.Arg(0) == "set" {
+			candidates = setting.Values
+			if setting.Type == config.SettingTypeBool {
+				candidates = []string{"true", "false"}
+			}
+		}
+	}
+
+	// completion stays silent, e.g. if the user isn't logged in
+	if err != nil {
+		return nil
+	}
+	return candidates
+}
+
+// completionCommandNames returns the commands offered by the completion
+func completionCommandNames() []string {
+	names := make([]string, 0, len(CommandSpecs))
+	for _, spec := range CommandSpecs {
+		if spec.Name != "forward-complete" {
+			names = append(names, spec.Name)
+		}
+	}
+	return names
+}
+
+func completionOptionNames(spec CommandSpec) []string {
+	var names []string
+	for _, option := range spec.options() {
+		names = append(names, option.Name)
+		if len(option.Short) > 0 {
+			names = append(names, option.Short)
+		}
+	}
+	return names
+}
+
+func filterPrefix(candidates []string, prefix string) []string {
+	var filtered []string
+	for _, candidate := range candidates {
+		if strings.HasPrefix(candidate, prefix) {
+			filtered = append(filtered, candidate)
+		}
+	}
+	return filtered
+}
+
+// completionSources looks up services of the targeted space and forwards with
+// the service jumper api
+func (c *CfServiceJumperPlugin) completionSources(cliConnection plugin.CliConnection) CompletionSources {
+	return CompletionSources{
+		Services: func() ([]string, error) {
+			services, err := cliConnection.GetServices()
+			if err != nil {
+				return nil, err
+			}
+			names := make([]string, len(services))
+			for i, service := range services {
+				names[i] = service.Name
+			}
+			sort.Strings(names)
+			return names, nil
+		},
+		ForwardIDs: func(query ServiceQuery) ([]string, error) {
+			err := c.forwardConfig.Validate()
+			if err != nil {
+				return nil, err
+			}
+			c.isSSLDisabled, err = cliConnection.IsSSLDisabled()
+			if err != nil {
+				return nil, err
+			}
+			serviceInstance, err := c.ResolveServiceInstance(cliConnection, query)
+			if err != nil {
+				return nil, err
+			}
+			err = c.InitJumperAPI(cliConnection)
+			if err != nil {
+				return nil, err
+			}
+
+			forwards, err := c.JumperClient.ListForwards(context.Background(), serviceInstance.GUID)
+			if err != nil {
+				return nil, err
+			}
+			ids := make([]string, len(forwards))
+			for i, forward := range forwards {
+				ids[i] = strconv.Itoa(forward.ID)
+			}
+			return ids, nil
+		},
+	}
+}
+
+// ForwardComplete prints the completion candidates of the command line following "--"
+func (c *CfServiceJumperPlugin) ForwardComplete(cliConnection plugin.CliConnection, commandLine *CommandLine) error {
+	for _, candidate := range CompleteWords(commandLine.Passthrough, c.completionSources(cliConnection)) {
+		fmt.Fprintln(c.stdout(), candidate)
+	}
+	return nil
+}
+
+// ForwardCompletion prints the completion script of the shell
+func (c *CfServiceJumperPlugin) ForwardCompletion(commandLine *CommandLine) error {
+	return WriteCompletionScript(c.stdout(), commandLine.Arg(0))
+}
+
+// WriteCompletionScript writes the completion script of the shell to out
+func WriteCompletionScript(out io.Writer, shell string) error {
+	commands := completionCommandNames()
+
+	switch shell {
+	case "bash":
+		_, err := fmt.Fprintf(out, bashCompletionScript, strings.Join(commands, " "))
+		return err
+	case "zsh":
+		_, err := fmt.Fprintf(out, zshCompletionScript, strings.Join(commands, " "), strings.Join(commands, "|"))
+		return err
+	case "fish":
+		lines := make([]string, 0, len(commands))
+		for _, name := range commands {
+			spec, _ := LookupCommandSpec(name)
+			lines = append(lines, fmt.Sprintf("complete -c cf -f -n '__fish_use_subcommand' -a %s -d '%s'", name, strings.Replace(spec.HelpText, "'", "\\'", -1)))
+		}
+		_, err := fmt.Fprintf(out, fishCompletionScript, strings.Join(lines, "\n"), strings.Join(commands, " "))
+		return err
+	}
+	return ErrUnknownShell
+}
+
+const bashCompletionScript = `# bash completion of the CfServiceJumperPlugin commands, load it with
+#   source <(cf forward-completion bash)
+_cf_forward_commands="%s"
+_cf_forward_previous=$(complete -p cf 2>/dev/null | sed -n 's/.*-F \([^ ]*\) .*/\1/p')
+[ "$_cf_forward_previous" = "_cf_forward" ] && _cf_forward_previous=""
+
+_cf_forward() {
+    local cur="${COMP_WORDS[COMP_CWORD]}"
+    if [ "$COMP_CWORD" -eq 1 ]; then
+        [ -n "$_cf_forward_previous" ] && "$_cf_forward_previous" "$@"
+        COMPREPLY+=($(compgen -W "$_cf_forward_commands" -- "$cur"))
+        return
+    fi
+    case " $_cf_forward_commands " in
+        *" ${COMP_WORDS[1]} "*) ;;
+        *)
+            [ -n "$_cf_forward_previous" ] && "$_cf_forward_previous" "$@"
+            return
+            ;;
+    esac
+    local IFS=$'\n'
+    COMPREPLY=($(cf forward-complete -- "${COMP_WORDS[@]:1:$COMP_CWORD}" 2>/dev/null))
+}
+complete -o default -F _cf_forward cf
+`
+
+const zshCompletionScript = `#compdef cf
+# zsh completion of the CfServiceJumperPlugin commands, load it with
+#   source <(cf forward-completion zsh)
+_cf_forward_previous=${_comps[cf]}
+[[ $_cf_forward_previous == _cf_forward ]] && _cf_forward_previous=""
+
+_cf_forward() {
+    local -a candidates
+    if (( CURRENT == 2 )); then
+        [[ -n $_cf_forward_previous ]] && $_cf_forward_previous "$@"
+        candidates=(%s)
+        compadd -a candidates
+        return
+    fi
+    case ${words[2]} in
+        %s) ;;
+        *)
+            if [[ -n $_cf_forward_previous ]]; then
+                $_cf_forward_previous "$@"
+            else
+                _files
+            fi
+            return
+            ;;
+    esac
+    candidates=("${(@f)$(cf forward-complete -- "${(@)words[2,CURRENT]}" 2>/dev/null)}")
+    candidates=(${candidates:#})
+    if (( ${#candidates} )); then
+        compadd -a candidates
+    else
+        _files
+    fi
+}
+compdef _cf_forward cf
+`
+
+const fishCompletionScript = `# fish completion of the CfServiceJumperPlugin commands, load it with
+#   cf forward-completion fish | source
+function __cf_forward_complete
+    set -l words (commandline -opc)
+    set -l current (commandline -ct)
+    cf forward-complete -- $words[2..-1] "$current" 2>/dev/null
+end
+%s
+complete -c cf -f -n '__fish_seen_subcommand_from %s' -a '(__cf_forward_complete)'
+`
@@ -0,0 +1,96 @@
+package main_test
+
+import (
+	"bytes"
+	"errors"
+
+	. "github.com/anynines/cf_service_jumper_cli_plugin"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("CompleteWords", func() {
+	var (
+		sources      CompletionSources
+		forwardQuery ServiceQuery
+	)
+
+	BeforeEach(func() {
+		forwardQuery = ServiceQuery{}
+		sources = CompletionSources{
+			Services: func() ([]string, error) { return []string{"db", "cache", "db-backup"}, nil },
+			ForwardIDs: func(query ServiceQuery) ([]string, error) {
+				forwardQuery = query
+				return []string{"41", "42"}, nil
+			},
+		}
+	})
+
+	It("completes command names", func() {
+		Expect(CompleteWords([]string{"forward-"}, sources)).To(ConsistOf("forward-env", "forward-app", "forward-doctor", "forward-api", "forward-config", "forward-completion"))
+	})
+
+	It("completes service instances", func() {
+		Expect(CompleteWords([]string{"create-forward", "d"}, sources)).To(Equal([]string{"db", "db-backup"}))
+		Expect(CompleteWords([]string{"create-forward", "db", ""}, sources)).To(BeEmpty())
+	})
+
+	It("completes forward ids of the service instance", func() {
+		Expect(CompleteWords([]string{"delete-forward", "--space", "dev", "db", ""}, sources)).To(Equal([]string{"41", "42"}))
+		Expect(forwardQuery).To(Equal(ServiceQuery{Name: "db", Space: "dev"}))
+	})
+
+	It("completes forward ids if the guid is given", func() {
+		Expect(CompleteWords([]string{"delete-forward", "--guid", "db-guid", "4"}, sources)).To(Equal([]string{"41", "42"}))
+		Expect(forwardQuery.GUID).To(Equal("db-guid"))
+	})
+
+	It("completes options and their values", func() {
+		Expect(CompleteWords([]string{"create-forward", "db", "--ex"}, sources)).To(Equal([]string{"--export-profile"}))
+		Expect(CompleteWords([]string{"create-forward", "db", "--export-profile", ""}, sources)).To(Equal(ProfileFormats))
+		Expect(CompleteWords([]string{"create-forward", "db", "--export-profile", "dbeaver", ""}, sources)).To(BeEmpty())
+		Expect(CompleteWords([]string{"list-forwards", "--output", "j"}, sources)).To(Equal([]string{"json"}))
+		Expect(CompleteWords([]string{"list-forwards", "--org", "o", ""}, sources)).To(Equal([]string{"db", "cache", "db-backup"}))
+	})
+
+	It("completes forward-config keys and values", func() {
+		Expect(CompleteWords([]string{"forward-config", "s"}, sources)).To(Equal([]string{"set"}))
+		Expect(CompleteWords([]string{"forward-config", "set", "auto_"}, sources)).To(Equal([]string{"auto_delete"}))
+		Expect(CompleteWords([]string{"forward-config", "set", "auto_delete", ""}, sources)).To(Equal([]string{"true", "false"}))
+		Expect(CompleteWords([]string{"forward-config", "set", "connection_format", "j"}, sources)).To(Equal([]string{"jdbc"}))
+	})
+
+	It("leaves commands after -- to the shell", func() {
+		Expect(CompleteWords([]string{"forward-app", "app", "--", "./r"}, sources)).To(BeEmpty())
+	})
+
+	It("stays silent on lookup errors", func() {
+		sources.Services = func() ([]string, error) { return nil, errors.New("not logged in") }
+		Expect(CompleteWords([]string{"create-forward", ""}, sources)).To(BeEmpty())
+	})
+})
+
+var _ = Describe("WriteCompletionScript", func() {
+	for _, shell := range CompletionShells {
+		shell := shell
+		It("writes the "+shell+" script", func() {
+			script := &bytes.Buffer{}
+			Expect(WriteCompletionScript(script, shell)).To(Succeed())
+			Expect(script.String()).To(ContainSubstring("cf forward-complete -- "))
+			Expect(script.String()).To(ContainSubstring("delete-forward"))
+			Expect(script.String()).ToNot(ContainSubstring("%!"))
+		})
+	}
+
+	It("chains to the previous cf completion of zsh", func() {
+		script := &bytes.Buffer{}
+		Expect(WriteCompletionScript(script, "zsh")).To(Succeed())
+		Expect(script.String()).To(ContainSubstring("_cf_forward_previous=${_comps[cf]}"))
+		Expect(script.String()).To(ContainSubstring(`$_cf_forward_previous "$@"`))
+	})
+
+	It("errors on unknown shells", func() {
+		Expect(WriteCompletionScript(&bytes.Buffer{}, "tcsh")).To(Equal(ErrUnknownShell))
+	})
+})
@@ -0,0 +1,341 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/anynines/cf_service_jumper_cli_plugin/xtunnel"
+)
+
+const (
+	// ControlTokenEnv sets the token of a loopback control API instead of a
+	// random one
+	ControlTokenEnv = "CF_FORWARD_CONTROL_TOKEN"
+	// ControlProbeTimeout limits the handshake verifying a reconnected host
+	ControlProbeTimeout = 5 * time.Second
+
+	controlUnixPrefix = "unix:"
+)
+
+// ControlAPI serves the state of a running forward as JSON and actions on it
+// to local scripts. Requests need the bearer Token unless it is blank, which
+// is the case for unix sockets protected by their permissions.
+type ControlAPI struct {
+	ForwardID   int
+	ServiceName string
+	ServiceGUID string
+	Created     time.Time
+	Tunnels     []Tunnel
+	// Credentials are served only if set, see --control-credentials
+	Credentials map[string]string
+	Token       string
+	// Probe verifies a host after a reconnect, e.g. with a TLS-PSK handshake
+	Probe func(host string) error
+
+	shutdownOnce sync.Once
+	closeOnce    sync.Once
+	shutdown     chan struct{}
+}
+
+type controlTunnel struct {
+	LocalAddress  string `json:"local_address"`
+	RemoteAddress string `json:"remote_address"`
+	Node          int    `json:"node"`
+}
+
+type controlStats struct {
+	LocalAddress  string `json:"local_address"`
+	RemoteAddress string `json:"remote_address"`
+	Accepted      int    `json:"accepted"`
+	Active        int    `json:"active"`
+	Failed        int    `json:"failed"`
+	BytesSent     uint64 `json:"bytes_sent"`
+	BytesReceived uint64 `json:"bytes_received"`
+	LastError     string `json:"last_error,omitempty"`
+	LastErrorAt   string `json:"last_error_at,omitempty"`
+}
+
+// Shutdown returns a channel closed when a shutdown was requested
+func (a *ControlAPI) Shutdown() <-chan struct{} {
+	a.shutdownOnce.Do(func() { a.shutdown = make(chan struct{}) })
+	return a.shutdown
+}
+
+func (a *ControlAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if len(a.Token) > 0 {
+		token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if subtle.ConstantTimeCompare([]byte(token), []byte(a.Token)) != 1 {
+			controlError(w, http.StatusUnauthorized, "missing or wrong bearer token")
+			return
+		}
+	}
+
+	routes := map[string]func(w http.ResponseWriter, r *http.Request){
+		"GET /v1/forward":            a.getForward,
+		"GET /v1/tunnels":            a.getTunnels,
+		"GET /v1/stats":              a.getStats,
+		"GET /v1/credentials":        a.getCredentials,
+		"POST /v1/connections/close": a.closeConnections,
+		"POST /v1/tunnels/reconnect": a.reconnect,
+		"POST /v1/shutdown":          a.requestShutdown,
+	}
+	if route, ok := routes[r.Method+" "+r.URL.Path]; ok {
+		route(w, r)
+		return
+	}
+	for key := range routes {
+		if strings.HasSuffix(key, " "+r.URL.Path) {
+			controlError(w, http.StatusMethodNotAllowed, fmt.Sprintf("%s isn't allowed on %s", r.Method, r.URL.Path))
+			return
+		}
+	}
+	controlError(w, http.StatusNotFound, fmt.Sprintf("%s not found", r.URL.Path))
+}
+
+func (a *ControlAPI) getForward(w http.ResponseWriter, r *http.Request) {
+	controlJSON(w, http.StatusOK, map[string]interface{}{
+		"forward_id":       a.ForwardID,
+		"service_instance": a.ServiceName,
+		"service_guid":     a.ServiceGUID,
+		"created":          a.Created.Format(time.RFC3339),
+		"local_addresses":  a.localAddresses(),
+	})
+}
+
+func (a *ControlAPI) getTunnels(w http.ResponseWriter, r *http.Request) {
+	tunnels := make([]controlTunnel, len(a.Tunnels))
+	for i, tunnel := range a.Tunnels {
+		tunnels[i] = controlTunnel{LocalAddress: tunnel.LocalAddress(), RemoteAddress: tunnel.RemoteAddress(), Node: i + 1}
+	}
+	controlJSON(w, http.StatusOK, tunnels)
+}
+
+func (a *ControlAPI) getStats(w http.ResponseWriter, r *http.Request) {
+	stats := make([]controlStats, len(a.Tunnels))
+	for i, tunnel := range a.Tunnels {
+		stats[i] = newControlStats(tunnel)
+	}
+	controlJSON(w, http.StatusOK, stats)
+}
+
+func newControlStats(tunnel Tunnel) controlStats {
+	stats := tunnel.Stats()
+	result := controlStats{
+		LocalAddress:  tunnel.LocalAddress(),
+		RemoteAddress: tunnel.RemoteAddress(),
+		Accepted:      stats.Accepted,
+		Active:        stats.Active,
+		Failed:        stats.Failed,
+		BytesSent:     stats.BytesSent,
+		BytesReceived: stats.BytesReceived,
+	}
+	if stats.LastError != nil {
+		result.LastError = stats.LastError.Error()
+		result.LastErrorAt = stats.LastErrorAt.Format(time.RFC3339)
+	}
+	return result
+}
+
+func (a *ControlAPI) getCredentials(w http.ResponseWriter, r *http.Request) {
+	if a.Credentials == nil {
+		controlError(w, http.StatusForbidden, "credentials aren't served, start the forward with --control-credentials")
+		return
+	}
+	controlJSON(w, http.StatusOK, a.Credentials)
+}
+
+// closeConnections closes all client connections, the tunnels keep listening
+func (a *ControlAPI) closeConnections(w http.ResponseWriter, r *http.Request) {
+	closed := 0
+	for _, tunnel := range a.Tunnels {
+		closed += tunnel.CloseConnections()
+	}
+	controlJSON(w, http.StatusOK, map[string]interface{}{"closed": closed})
+}
+
+// reconnect closes the connections to the host of ?host= so that clients
+// reconnect and verifies the host with a handshake
+func (a *ControlAPI) reconnect(w http.ResponseWriter, r *http.Request) {
+	host := r.URL.Query().Get("host")
+	for _, tunnel := range a.Tunnels {
+		if tunnel.RemoteAddress() != host {
+			continue
+		}
+		result := map[string]interface{}{"host": host, "closed": tunnel.CloseConnections(), "handshake": "skipped"}
+		status := http.StatusOK
+		if a.Probe != nil {
+			result["handshake"] = "ok"
+			if err := a.Probe(host); err != nil {
+				result["handshake"] = err.Error()
+				status = http.StatusBadGateway
+			}
+		}
+		controlJSON(w, status, result)
+		return
+	}
+	controlError(w, http.StatusNotFound, fmt.Sprintf("host %q isn't a host of the forward", host))
+}
+
+// requestShutdown ends the command, which deletes the forward
+func (a *ControlAPI) requestShutdown(w http.ResponseWriter, r *http.Request) {
+	a.Shutdown()
+	a.closeOnce.Do(func() { close(a.shutdown) })
+	controlJSON(w, http.StatusAccepted, map[string]interface{}{"forward_id": a.ForwardID, "delete": true})
+}
+
+func (a *ControlAPI) localAddresses() []string {
+	addresses := make([]string, len(a.Tunnels))
+	for i, tunnel := range a.Tunnels {
+		addresses[i] = tunnel.LocalAddress()
+	}
+	return addresses
+}
+
+func controlJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func controlError(w http.ResponseWriter, status int, message string) {
+	controlJSON(w, status, map[string]string{"error": message})
+}
+
+// ListenControl opens the listen socket of the control API before the forward
+// is created, either a unix socket with unix:PATH or a loopback address.
+// Returns nil if address is blank.
+func ListenControl(address string) (net.Listener, error) {
+	if len(address) < 1 {
+		return nil, nil
+	}
+	if strings.HasPrefix(address, controlUnixPrefix) {
+		return listenControlSocket(strings.TrimPrefix(address, controlUnixPrefix))
+	}
+
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, fmt.Errorf("[ERR] Invalid --control %s, use unix:PATH or a loopback address. %s", address, err)
+	}
+	if ip := net.ParseIP(host); host != "localhost" && (ip == nil || !ip.IsLoopback()) {
+		return nil, fmt.Errorf("[ERR] Invalid --control %s, the control API listens on unix sockets or loopback addresses only", address)
+	}
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return nil, fmt.Errorf("[ERR] Failed to listen on --control %s. %s", address, err)
+	}
+	return listener, nil
+}
+
+// listenControlSocket listens on a unix socket only the user can connect to. A
+// stale socket of a previous forward is replaced. The socket is created in a
+// private directory and moved to path once it is restricted to the user, so
+// it is never accessible with the permissions of the umask.
+func listenControlSocket(path string) (net.Listener, error) {
+	if info, err := os.Lstat(path); err == nil && info.Mode()&os.ModeSocket != 0 {
+		if conn, err := net.Dial("unix", path); err == nil {
+			conn.Close()
+			return nil, fmt.Errorf("[ERR] Failed to listen on --control unix:%s, another forward is using it", path)
+		}
+		os.Remove(path)
+	}
+
+	dir, err := ioutil.TempDir(filepath.Dir(path), ".control")
+	if err != nil {
+		return nil, fmt.Errorf("[ERR] Failed to listen on --control unix:%s. %s", path, err)
+	}
+	defer os.RemoveAll(dir)
+	privatePath := filepath.Join(dir, "s")
+	listener, err := net.ListenUnix("unix", &net.UnixAddr{Name: privatePath, Net: "unix"})
+	if err != nil {
+		return nil, fmt.Errorf("[ERR] Failed to listen on --control unix:%s. %s", path, err)
+	}
+	// the listener would remove the private path, controlSocket removes path
+	listener.SetUnlinkOnClose(false)
+	err = os.Chmod(privatePath, 0600)
+	if err != nil {
+		listener.Close()
+		return nil, fmt.Errorf("[ERR] Failed to restrict --control unix:%s to the user. %s", path, err)
+	}
+	err = os.Rename(privatePath, path)
+	if err != nil {
+		listener.Close()
+		return nil, fmt.Errorf("[ERR] Failed to listen on --control unix:%s. %s", path, err)
+	}
+	return &controlSocket{UnixListener: listener, path: path}, nil
+}
+
+// controlSocket is a unix listener whose socket file was moved to path, which
+// is its address and removed on Close
+type controlSocket struct {
+	*net.UnixListener
+	path string
+}
+
+func (s *controlSocket) Addr() net.Addr {
+	return &net.UnixAddr{Name: s.path, Net: "unix"}
+}
+
+func (s *controlSocket) Close() error {
+	err := s.UnixListener.Close()
+	os.Remove(s.path)
+	return err
+}
+
+// controlToken returns the token of a loopback control API
+func controlToken() (string, error) {
+	if token := os.Getenv(ControlTokenEnv); len(token) > 0 {
+		return token, nil
+	}
+	b := make([]byte, 24)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// serveControl serves the control API of the forward on listener in the
+// background until the listener is closed. Returns the channel closed on a
+// shutdown request.
+func (c *CfServiceJumperPlugin) serveControl(listener net.Listener, commandLine *CommandLine, serviceInstance ServiceInstance, forwardInfo ForwardDataSet, tunnels []*xtunnel.XTunnel) (<-chan struct{}, error) {
+	controlAPI := &ControlAPI{
+		ForwardID:   forwardInfo.ID,
+		ServiceName: serviceInstance.Name,
+		ServiceGUID: serviceInstance.GUID,
+		Created:     time.Now(),
+		Tunnels:     tunnelsOf(tunnels),
+	}
+	controlAPI.Probe, _ = PSKProbe(forwardInfo.SharedSecret, ControlProbeTimeout)
+	if commandLine.Bool("--control-credentials") {
+		controlAPI.Credentials = forwardInfo.Credentials.Credentials.WithLocalAddresses(LocalAddresses(tunnels)).Flatten()
+	}
+
+	if listener.Addr().Network() == "unix" {
+		fmt.Fprintf(c.messageWriter(), "Serving the control API on unix:%s\n", listener.Addr())
+	} else {
+		token, err := controlToken()
+		if err != nil {
+			return nil, err
+		}
+		controlAPI.Token = token
+		if os.Getenv(ControlTokenEnv) == token {
+			fmt.Fprintf(c.messageWriter(), "Serving the control API on http://%s with the token of %s\n", listener.Addr(), ControlTokenEnv)
+		} else {
+			fmt.Fprintf(c.messageWriter(), "Serving the control API on http://%s with token %s\n", listener.Addr(), token)
+		}
+	}
+
+	go http.Serve(listener, controlAPI)
+	return controlAPI.Shutdown(), nil
+}
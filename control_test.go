@@ -0,0 +1,176 @@
+package main_test
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+
+	. "github.com/anynines/cf_service_jumper_cli_plugin"
+	"github.com/anynines/cf_service_jumper_cli_plugin/xtunnel"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("ControlAPI", func() {
+	var (
+		tunnel     *fakeTunnel
+		controlAPI *ControlAPI
+		probeErr   error
+	)
+
+	BeforeEach(func() {
+		tunnel = &fakeTunnel{stats: xtunnel.Stats{Accepted: 3, Active: 2, BytesSent: 10}}
+		probeErr = nil
+		controlAPI = &ControlAPI{
+			ForwardID:   42,
+			ServiceName: "db",
+			Tunnels:     []Tunnel{tunnel},
+			Token:       "the_token",
+			Probe:       func(host string) error { return probeErr },
+		}
+	})
+
+	request := func(method, path string) (*httptest.ResponseRecorder, map[string]interface{}) {
+		r := httptest.NewRequest(method, path, nil)
+		r.Header.Set("Authorization", "Bearer the_token")
+		w := httptest.NewRecorder()
+		controlAPI.ServeHTTP(w, r)
+
+		var body map[string]interface{}
+		json.Unmarshal(w.Body.Bytes(), &body)
+		return w, body
+	}
+
+	It("requires the token", func() {
+		w := httptest.NewRecorder()
+		controlAPI.ServeHTTP(w, httptest.NewRequest("GET", "/v1/forward", nil))
+		Expect(w.Code).To(Equal(http.StatusUnauthorized))
+	})
+
+	It("serves the forward id and local addresses", func() {
+		w, body := request("GET", "/v1/forward")
+		Expect(w.Code).To(Equal(http.StatusOK))
+		Expect(body["forward_id"]).To(BeNumerically("==", 42))
+		Expect(body["local_addresses"]).To(Equal([]interface{}{"127.0.0.1:5000"}))
+	})
+
+	It("serves the stats of the tunnels", func() {
+		w, _ := request("GET", "/v1/stats")
+		Expect(w.Code).To(Equal(http.StatusOK))
+		Expect(w.Body.String()).To(ContainSubstring(`"remote_address":"10.0.0.1:5432","accepted":3,"active":2`))
+	})
+
+	It("serves credentials only if enabled", func() {
+		w, _ := request("GET", "/v1/credentials")
+		Expect(w.Code).To(Equal(http.StatusForbidden))
+
+		controlAPI.Credentials = map[string]string{"password": "the_password"}
+		w, body := request("GET", "/v1/credentials")
+		Expect(w.Code).To(Equal(http.StatusOK))
+		Expect(body["password"]).To(Equal("the_password"))
+	})
+
+	It("closes client connections", func() {
+		w, body := request("POST", "/v1/connections/close")
+		Expect(w.Code).To(Equal(http.StatusOK))
+		Expect(body["closed"]).To(BeNumerically("==", 2))
+		Expect(tunnel.stats.Active).To(Equal(0))
+	})
+
+	It("reconnects a host and reports a failed handshake", func() {
+		w, body := request("POST", "/v1/tunnels/reconnect?host=10.0.0.1:5432")
+		Expect(w.Code).To(Equal(http.StatusOK))
+		Expect(body["handshake"]).To(Equal("ok"))
+
+		probeErr = errors.New("connection refused")
+		w, body = request("POST", "/v1/tunnels/reconnect?host=10.0.0.1:5432")
+		Expect(w.Code).To(Equal(http.StatusBadGateway))
+		Expect(body["handshake"]).To(Equal("connection refused"))
+
+		w, _ = request("POST", "/v1/tunnels/reconnect?host=10.0.0.2:5432")
+		Expect(w.Code).To(Equal(http.StatusNotFound))
+	})
+
+	It("requests a shutdown", func() {
+		Expect(controlAPI.Shutdown()).ToNot(BeClosed())
+		w, _ := request("POST", "/v1/shutdown")
+		Expect(w.Code).To(Equal(http.StatusAccepted))
+		Expect(controlAPI.Shutdown()).To(BeClosed())
+
+		// repeated requests don't close the channel twice
+		w, _ = request("POST", "/v1/shutdown")
+		Expect(w.Code).To(Equal(http.StatusAccepted))
+	})
+
+	It("rejects wrong methods", func() {
+		w, _ := request("GET", "/v1/shutdown")
+		Expect(w.Code).To(Equal(http.StatusMethodNotAllowed))
+	})
+})
+
+var _ = Describe("ListenControl", func() {
+	It("rejects addresses other than loopback", func() {
+		_, err := ListenControl("0.0.0.0:0")
+		Expect(err).To(MatchError(ContainSubstring("loopback addresses only")))
+	})
+
+	It("listens on a unix socket of the user only", func() {
+		dir, err := ioutil.TempDir("", "control")
+		Expect(err).To(BeNil())
+		defer os.RemoveAll(dir)
+		path := filepath.Join(dir, "forward.sock")
+
+		listener, err := ListenControl("unix:" + path)
+		Expect(err).To(BeNil())
+		info, err := os.Stat(path)
+		Expect(err).To(BeNil())
+		Expect(info.Mode().Perm()).To(Equal(os.FileMode(0600)))
+
+		go listener.Accept()
+		_, err = ListenControl("unix:" + path)
+		Expect(err).To(MatchError(ContainSubstring("another forward is using it")))
+		listener.Close()
+
+		// the private directory of the socket is gone and Close removes the socket
+		files, err := ioutil.ReadDir(dir)
+		Expect(err).To(BeNil())
+		Expect(files).To(BeEmpty())
+	})
+})
+
+var _ = Describe("XTunnel CloseConnections", func() {
+	It("closes open client connections", func() {
+		echo, err := net.Listen("tcp", "127.0.0.1:0")
+		Expect(err).To(BeNil())
+		defer echo.Close()
+		go func() {
+			conn, err := echo.Accept()
+			if err == nil {
+				io.Copy(conn, conn)
+			}
+		}()
+
+		tunnel := xtunnel.NewUnencryptedXTunnel(echo.Addr().String())
+		localAddress, err := tunnel.Listen()
+		Expect(err).To(BeNil())
+		defer tunnel.Shutdown()
+		go tunnel.Serve()
+
+		conn, err := net.Dial("tcp", localAddress)
+		Expect(err).To(BeNil())
+		defer conn.Close()
+		Eventually(func() int { return tunnel.Stats().Active }).Should(Equal(1))
+
+		Expect(tunnel.CloseConnections()).To(Equal(1))
+		_, err = conn.Read(make([]byte, 1))
+		Expect(err).ToNot(BeNil())
+		Eventually(func() int { return tunnel.Stats().Active }).Should(Equal(0))
+	})
+})
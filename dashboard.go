@@ -0,0 +1,386 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/anynines/cf_service_jumper_cli_plugin/plugin/config"
+	"github.com/anynines/cf_service_jumper_cli_plugin/plugin/healthcheck"
+	"github.com/anynines/cf_service_jumper_cli_plugin/plugin/redact"
+	"github.com/anynines/cf_service_jumper_cli_plugin/xtunnel"
+	"github.com/olekukonko/tablewriter"
+)
+
+const (
+	// DashboardRefreshInterval is the refresh rate of the dashboard
+	DashboardRefreshInterval = time.Second
+	// DashboardEvents is the number of recent tunnel events shown
+	DashboardEvents = 5
+	// DashboardRoleInterval is the rate the node roles are probed at
+	DashboardRoleInterval = 10 * time.Second
+
+	hiddenSecret = "********"
+	// maskedSecret replaces secrets in masked connection strings, it is never
+	// escaped unlike hiddenSecret and the actual password
+	maskedSecret = "MASKEDSECRET"
+	keyCtrlD     = 4
+)
+
+// Dashboard shows the tunnels of a running forward with their connections,
+// throughput and errors. Secrets are hidden until shown with the s key.
+type Dashboard struct {
+	ForwardID         int
+	ServiceName       string
+	Tunnels           []Tunnel
+	Credentials       map[string]string
+	ConnectionStrings []string
+	// MaskedConnectionStrings are built with a placeholder password, shown
+	// while the credentials are hidden
+	MaskedConnectionStrings []string
+	// Stop ends Run like the q key when closed, e.g. by the control API
+	Stop <-chan struct{}
+	// ProbeRoles returns the replication role of the node behind each tunnel,
+	// blank if unknown. Called by Run every DashboardRoleInterval.
+	ProbeRoles func() []string
+
+	mutex           sync.Mutex
+	started         time.Time
+	events          []xtunnel.Event
+	eventTimes      []time.Time
+	showCredentials bool
+	message         string
+	roles           []string
+	// samples are the stats of the previous frame for the throughput
+	samples     []xtunnel.Stats
+	sampledAt   time.Time
+	throughputs [][2]float64
+}
+
+// Event records an event of the tunnels without blocking
+func (d *Dashboard) Event(event xtunnel.Event) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	d.events = append(d.events, event)
+	d.eventTimes = append(d.eventTimes, time.Now())
+	if len(d.events) > DashboardEvents {
+		d.events = d.events[len(d.events)-DashboardEvents:]
+		d.eventTimes = d.eventTimes[len(d.eventTimes)-DashboardEvents:]
+	}
+}
+
+// HandleKey processes a key press: c copies the connection strings, s shows or
+// hides credentials and q quits. Returns true on quit.
+func (d *Dashboard) HandleKey(key byte, out io.Writer) bool {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	switch key {
+	case 'q', 'Q', keyCtrlD:
+		return true
+	case 's', 'S':
+		d.showCredentials = !d.showCredentials
+		d.message = "Credentials hidden."
+		if d.showCredentials {
+			d.message = "Credentials shown, press s to hide them."
+		}
+	case 'c', 'C':
+		text := strings.Join(d.ConnectionStrings, "\n")
+		if len(text) < 1 {
+			text = strings.Join(d.localAddresses(), "\n")
+		}
+		err := writeClipboard(out, text)
+		d.message = "Copied the connection string(s) to the clipboard."
+		if err != nil {
+			d.message = fmt.Sprintf("[ERR] Failed to copy. %s", err)
+		}
+	}
+	return false
+}
+
+func (d *Dashboard) localAddresses() []string {
+	addresses := make([]string, len(d.Tunnels))
+	for i, tunnel := range d.Tunnels {
+		addresses[i] = tunnel.LocalAddress()
+	}
+	return addresses
+}
+
+// Run renders the dashboard every interval until q is pressed on in, Stop is
+// closed or shutdown receives. Returns true unless shutdown received.
+func (d *Dashboard) Run(in io.Reader, out io.Writer, interval time.Duration, shutdown <-chan os.Signal) bool {
+	keys := make(chan byte)
+	go func() {
+		key := make([]byte, 1)
+		for {
+			n, err := in.Read(key)
+			if err != nil {
+				close(keys)
+				return
+			}
+			if n > 0 {
+				keys <- key[0]
+			}
+		}
+	}()
+
+	done := make(chan struct{})
+	defer close(done)
+	if d.ProbeRoles != nil {
+		go d.probeRoles(done)
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		d.Render(out, time.Now())
+		select {
+		case key, ok := <-keys:
+			if !ok {
+				// without input the dashboard is left with a signal only
+				keys = nil
+				continue
+			}
+			if d.HandleKey(key, out) {
+				return true
+			}
+		case <-ticker.C:
+		case <-d.Stop:
+			return true
+		case <-shutdown:
+			return false
+		}
+	}
+}
+
+// probeRoles updates the roles until done is closed
+func (d *Dashboard) probeRoles(done <-chan struct{}) {
+	ticker := time.NewTicker(DashboardRoleInterval)
+	defer ticker.Stop()
+	for {
+		d.SetRoles(d.ProbeRoles())
+		select {
+		case <-ticker.C:
+		case <-done:
+			return
+		}
+	}
+}
+
+// SetRoles sets the replication roles of the nodes shown per tunnel
+func (d *Dashboard) SetRoles(roles []string) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	d.roles = roles
+}
+
+// Render writes a frame of the dashboard to out
+func (d *Dashboard) Render(out io.Writer, now time.Time) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	if d.started.IsZero() {
+		d.started = now
+	}
+	d.sample(now)
+
+	frame := &bytes.Buffer{}
+	frame.WriteString(terminalClear)
+	fmt.Fprintf(frame, "Forward %d to %s, open for %s\n\n", d.ForwardID, d.ServiceName, now.Sub(d.started).Round(time.Second))
+
+	table := tablewriter.NewWriter(frame)
+	table.SetHeader([]string{"Local", "Remote", "Role", "Active", "Total", "Failed", "Sent", "Received", "Throughput", "Last error"})
+	for i, tunnel := range d.Tunnels {
+		stats := d.samples[i]
+		role := "-"
+		if i < len(d.roles) && len(d.roles[i]) > 0 {
+			role = d.roles[i]
+		}
+		lastError := ""
+		if stats.LastError != nil {
+			lastError = fmt.Sprintf("%s %s", stats.LastErrorAt.Format("15:04:05"), stats.LastError)
+		}
+		table.Append([]string{
+			tunnel.LocalAddress(),
+			tunnel.RemoteAddress(),
+			role,
+			strconv.Itoa(stats.Active),
+			strconv.Itoa(stats.Accepted),
+			strconv.Itoa(stats.Failed),
+			formatBytes(stats.BytesSent),
+			formatBytes(stats.BytesReceived),
+			fmt.Sprintf("%s/s up, %s/s down", formatBytes(uint64(d.throughputs[i][0])), formatBytes(uint64(d.throughputs[i][1]))),
+			lastError,
+		})
+	}
+	table.Render()
+
+	fmt.Fprintln(frame, "\nCredentials:")
+	for _, key := range d.credentialKeys() {
+		value := d.Credentials[key]
+		if !d.showCredentials && redact.IsSecretKey(key) {
+			value = hiddenSecret
+		}
+		fmt.Fprintf(frame, "%s: %s\n", key, value)
+	}
+
+	if len(d.ConnectionStrings) > 0 {
+		fmt.Fprintln(frame, "\nConnection string(s):")
+		connectionStrings := d.MaskedConnectionStrings
+		if d.showCredentials {
+			connectionStrings = d.ConnectionStrings
+		}
+		for _, connectionString := range connectionStrings {
+			fmt.Fprintln(frame, connectionString)
+		}
+	}
+
+	fmt.Fprintln(frame, "\nEvents:")
+	for i, event := range d.events {
+		fmt.Fprintln(frame, formatEvent(d.eventTimes[i], event))
+	}
+
+	fmt.Fprintln(frame, "\n[c] copy connection string  [s] show/hide credentials  [q] quit and delete the forward")
+	if len(d.message) > 0 {
+		fmt.Fprintln(frame, d.message)
+	}
+	out.Write(frame.Bytes())
+}
+
+// sample takes the stats of the tunnels and computes the throughput since the
+// previous frame
+func (d *Dashboard) sample(now time.Time) {
+	samples := make([]xtunnel.Stats, len(d.Tunnels))
+	throughputs := make([][2]float64, len(d.Tunnels))
+	elapsed := now.Sub(d.sampledAt).Seconds()
+	for i, tunnel := range d.Tunnels {
+		samples[i] = tunnel.Stats()
+		if i < len(d.samples) && elapsed > 0 {
+			throughputs[i][0] = float64(samples[i].BytesSent-d.samples[i].BytesSent) / elapsed
+			throughputs[i][1] = float64(samples[i].BytesReceived-d.samples[i].BytesReceived) / elapsed
+		}
+	}
+	d.samples, d.throughputs, d.sampledAt = samples, throughputs, now
+}
+
+// credentialKeys returns the sorted credential keys without the remote addresses
+func (d *Dashboard) credentialKeys() []string {
+	keys := make([]string, 0, len(d.Credentials))
+	for key := range d.Credentials {
+		if !stringInStrSlice(key, []string{"uri", "host", "hosts", "port"}) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func formatEvent(at time.Time, event xtunnel.Event) string {
+	line := fmt.Sprintf("%s %s -> %s: %s %s", at.Format("15:04:05"), event.LocalAddress, event.RemoteAddress, event.Type, event.ClientAddress)
+	if event.Err != nil {
+		line += fmt.Sprintf(" failed. %s", event.Err)
+	}
+	return strings.TrimSpace(line)
+}
+
+// formatBytes formats n with binary units, e.g. 1.5 KiB
+func formatBytes(n uint64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	value, exponent := float64(n)/unit, 0
+	for value >= unit && exponent < 3 {
+		value /= unit
+		exponent++
+	}
+	return fmt.Sprintf("%.1f %ciB", value, "KMGT"[exponent])
+}
+
+// dashboardEnabled reports whether create-forward shows the dashboard. It
+// requires a terminal and stty and is left out with --plain or logs on stderr.
+func (c *CfServiceJumperPlugin) dashboardEnabled(commandLine *CommandLine) bool {
+	if commandLine.Bool("--plain") || commandLine.Verbose() || commandLine.Bool("--debug") || c.output != "text" {
+		return false
+	}
+	if _, err := exec.LookPath("stty"); err != nil {
+		return false
+	}
+	return isTerminal(c.stdout()) && isTerminal(os.Stdin)
+}
+
+// runDashboard shows the dashboard until it is quit or the process is
+// interrupted. Falls back to waiting for shutdown if the terminal mode can't be
+// set. Returns true if the user quit or Stop was closed.
+func (c *CfServiceJumperPlugin) runDashboard(dashboard *Dashboard) bool {
+	restore, err := setCbreakMode()
+	if err != nil {
+		c.logger.Debugf("dashboard unavailable, failed to set the terminal mode. %s", err)
+		OutputCredentials(c.stdout(), dashboard.Credentials)
+		OutputConnectionStrings(c.stdout(), dashboard.ConnectionStrings)
+		return c.waitForShutdownOr(dashboard.Stop)
+	}
+	defer restore()
+
+	out := c.stdout()
+	fmt.Fprint(out, terminalAlternateScreen)
+	defer fmt.Fprint(out, terminalMainScreen)
+	return dashboard.Run(os.Stdin, out, DashboardRefreshInterval, ShutdownSignals())
+}
+
+// dashboardRoleProbe returns a probe of the replication roles of the nodes
+// behind the tunnels, nil if the service type has no role probe
+func (c *CfServiceJumperPlugin) dashboardRoleProbe(serviceType ServiceType, credentials map[string]string, tunnels []Tunnel) func() []string {
+	protocol, ok := healthCheckProtocols[serviceType]
+	if !ok || !healthcheck.HasRoleProbe(protocol) {
+		return nil
+	}
+	return func() []string {
+		roles := make([]string, len(tunnels))
+		for i, tunnel := range tunnels {
+			role, err := healthcheck.Role(protocol, dialAddress(tunnel.LocalAddress()), healthCheckCredentials(credentials), HealthCheckTimeout)
+			if err != nil {
+				c.logger.Debugf("role probe of %s failed. %s", tunnel.RemoteAddress(), err)
+			}
+			roles[i] = role
+		}
+		return roles
+	}
+}
+
+// dashboardConnectionStrings returns the connection strings of the
+// connection_format setting and the same built with a placeholder password. Both are
+// blank if the service type is unknown.
+func (c *CfServiceJumperPlugin) dashboardConnectionStrings(serviceType ServiceType, forwardInfo ForwardDataSet, localAddresses []string) ([]string, []string) {
+	connectionStrings := c.formatConnectionStrings(serviceType, forwardInfo.Credentials.Credentials, localAddresses)
+	masked := c.formatConnectionStrings(serviceType, forwardInfo.Credentials.Credentials.Masked(maskedSecret), localAddresses)
+	for i := range masked {
+		masked[i] = strings.Replace(masked[i], maskedSecret, hiddenSecret, -1)
+	}
+	return connectionStrings, masked
+}
+
+func (c *CfServiceJumperPlugin) formatConnectionStrings(serviceType ServiceType, credentials ForwardSbCredentials, localAddresses []string) []string {
+	format := c.forwardConfig.Value(config.SettingConnectionFormat)
+	if format == "env" {
+		return credentials.WithLocalAddresses(localAddresses).EnvVars()
+	}
+	connectionStringBuilder := ConnectionStringBuilder{
+		ServiceType:    serviceType,
+		Credentials:    credentials.CredentialsMap(),
+		LocalAddresses: localAddresses,
+	}
+	connectionStrings, err := connectionStringBuilder.Build(format)
+	if err != nil {
+		return nil
+	}
+	return connectionStrings
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/anynines/cf_service_jumper_cli_plugin/plugin/config"
+	"github.com/cloudfoundry/cli/plugin"
+)
+
+// writeDebugBundle adds versions, settings, the endpoint discovery and the
+// result of the command to the debug bundle and writes it to the file of
+// --debug-bundle. http.log and forward.log were collected while the command ran.
+func (c *CfServiceJumperPlugin) writeDebugBundle(cliConnection plugin.CliConnection, commandLine *CommandLine, args []string, commandErr error) error {
+	b := c.debugBundle
+	filePath := commandLine.String("--debug-bundle")
+
+	version := c.GetMetadata().Version
+	cliVersion, err := cliConnection.CliCommandWithoutTerminalOutput("version")
+	versions := map[string]string{
+		"plugin": fmt.Sprintf("%d.%d.%d", version.Major, version.Minor, version.Build),
+		"cf_cli": strings.TrimSpace(strings.Join(cliVersion, "\n")),
+	}
+	if err != nil {
+		versions["cf_cli"] = err.Error()
+	}
+
+	var memStats runtime.MemStats
+	runtime.ReadMemStats(&memStats)
+	goRuntime := map[string]interface{}{
+		"go_version":    runtime.Version(),
+		"os":            runtime.GOOS,
+		"arch":          runtime.GOARCH,
+		"num_cpu":       runtime.NumCPU(),
+		"num_goroutine": runtime.NumGoroutine(),
+		"heap_alloc":    memStats.HeapAlloc,
+	}
+
+	result := map[string]interface{}{
+		"args":      args,
+		"time":      time.Now().Format(time.RFC3339),
+		"exit_code": ExitCode(commandErr),
+	}
+	if commandErr != nil {
+		result["error"] = commandErr.Error()
+	}
+
+	discovery := map[string]interface{}{"endpoint": c.CfServiceJumperAPIEndpoint}
+	if c.discovery != nil {
+		attempts := make([]map[string]interface{}, len(c.discovery.Attempts))
+		for i, attempt := range c.discovery.Attempts {
+			attempts[i] = map[string]interface{}{
+				"source":      attempt.Source,
+				"endpoint":    attempt.Endpoint,
+				"duration_ms": attempt.Duration.Nanoseconds() / int64(time.Millisecond),
+			}
+			if attempt.Err != nil {
+				attempts[i]["error"] = attempt.Err.Error()
+			}
+		}
+		discovery["cf_api"] = c.discovery.CfAPIEndpoint
+		discovery["attempts"] = attempts
+	}
+
+	files := []struct {
+		name string
+		v    interface{}
+	}{
+		{"versions.json", versions},
+		{"runtime.json", goRuntime},
+		{"command.json", result},
+		{"discovery.json", discovery},
+	}
+	for _, file := range files {
+		err = b.AddJSON(file.name, file.v)
+		if err != nil {
+			return err
+		}
+	}
+
+	// the effective settings include environment variables
+	settings := &bytes.Buffer{}
+	err = OutputSettingsJSON(settings, c.forwardConfig)
+	if err != nil {
+		return err
+	}
+	b.Add("settings.json", settings.Bytes())
+
+	configFilePath, err := config.DefaultFilePath()
+	if err != nil {
+		return err
+	}
+	forwardJSON, err := ioutil.ReadFile(configFilePath)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	b.Add("forward.json", forwardJSON)
+
+	err = b.WriteFile(filePath)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(c.messageWriter(), "Debug bundle written to %s. Secrets are removed, review it before sharing.\n", filePath)
+	return nil
+}
@@ -0,0 +1,335 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/anynines/cf_service_jumper_cli_plugin/jumperapi"
+	"github.com/anynines/cf_service_jumper_cli_plugin/xtunnel"
+	"github.com/cloudfoundry/cli/plugin"
+	"github.com/olekukonko/tablewriter"
+)
+
+// DoctorDialTimeout limits the tcp connect and the TLS-PSK handshake to a host
+const DoctorDialTimeout = 10 * time.Second
+
+// DoctorStatus is the result of a check
+type DoctorStatus string
+
+const (
+	DoctorPass DoctorStatus = "pass"
+	DoctorFail DoctorStatus = "fail"
+	// DoctorWarn is a failure which doesn't prevent forwards
+	DoctorWarn DoctorStatus = "warn"
+	DoctorSkip DoctorStatus = "skip"
+)
+
+// DoctorCheck is a step of 'cf forward-doctor'
+type DoctorCheck struct {
+	Name     string
+	Status   DoctorStatus
+	Duration time.Duration
+	Detail   string
+	// Remedy tells failed checks how to fix them
+	Remedy string
+}
+
+// DoctorReport collects the checks in the order they ran
+type DoctorReport struct {
+	Checks []DoctorCheck
+}
+
+// Failed returns the number of failed checks
+func (r *DoctorReport) Failed() int {
+	failed := 0
+	for _, check := range r.Checks {
+		if check.Status == DoctorFail {
+			failed++
+		}
+	}
+	return failed
+}
+
+// Check runs check and records its result. The hint of the error takes
+// precedence over remedy. Returns whether the check passed.
+func (r *DoctorReport) Check(name string, remedy string, check func() (string, error)) bool {
+	start := time.Now()
+	detail, err := check()
+	doctorCheck := DoctorCheck{Name: name, Status: DoctorPass, Duration: time.Since(start), Detail: detail}
+	if err != nil {
+		doctorCheck.Status = DoctorFail
+		doctorCheck.Detail = err.Error()
+		doctorCheck.Remedy = remedy
+		if hinter, ok := err.(Hinter); ok && len(hinter.Hint()) > 0 {
+			doctorCheck.Remedy = hinter.Hint()
+		}
+	}
+	r.Checks = append(r.Checks, doctorCheck)
+	return err == nil
+}
+
+// Skip records a check which didn't run
+func (r *DoctorReport) Skip(name string, reason string) {
+	r.Checks = append(r.Checks, DoctorCheck{Name: name, Status: DoctorSkip, Detail: reason})
+}
+
+// ForwardDoctor checks login, endpoint discovery and the service jumper api.
+// With a service instance it also creates a throwaway forward, connects to
+// every host and deletes the forward again.
+func (c *CfServiceJumperPlugin) ForwardDoctor(cliConnection plugin.CliConnection, commandLine *CommandLine) error {
+	report := &DoctorReport{}
+	c.runDoctor(cliConnection, commandLine, report)
+
+	for _, check := range report.Checks {
+		c.logger.Infof("forward-doctor: %s %s (%s) %s", check.Name, check.Status, check.Duration.Round(time.Millisecond), check.Detail)
+	}
+	if c.output == "json" {
+		err := OutputDoctorReportJSON(c.stdout(), report)
+		if err != nil {
+			return err
+		}
+	} else {
+		OutputDoctorReport(c.stdout(), report)
+	}
+
+	if failed := report.Failed(); failed > 0 {
+		return fmt.Errorf("[ERR] forward-doctor found %d problem(s)", failed)
+	}
+	return nil
+}
+
+func (c *CfServiceJumperPlugin) runDoctor(cliConnection plugin.CliConnection, commandLine *CommandLine, report *DoctorReport) {
+	report.Check("settings", "Fix the setting with 'cf forward-config set KEY VALUE' or the environment variable.", func() (string, error) {
+		return "forward.json and CF_FORWARD_* variables are valid", c.forwardConfig.Validate()
+	})
+
+	var apiEndpoint string
+	ok := report.Check("cf api", "Target a cf api with 'cf api URL'.", func() (string, error) {
+		var err error
+		apiEndpoint, err = cliConnection.ApiEndpoint()
+		if err == nil && len(apiEndpoint) < 1 {
+			err = fmt.Errorf("[ERR] no cf api targeted")
+		}
+		return apiEndpoint, err
+	})
+	if !ok {
+		report.Skip("service jumper endpoint", "no cf api")
+		return
+	}
+
+	loggedIn := report.Check("cf login", "Log in with 'cf login'.", func() (string, error) {
+		isLoggedIn, err := cliConnection.IsLoggedIn()
+		if err != nil {
+			return "", err
+		}
+		if !isLoggedIn {
+			return "", fmt.Errorf("[ERR] not logged in")
+		}
+		// the cli refreshes expired tokens on AccessToken
+		token, err := cliConnection.AccessToken()
+		if err != nil {
+			return "", err
+		}
+		expiry, err := jumperapi.TokenExpiry(token)
+		if err != nil {
+			return "access token without expiry", nil
+		}
+		return fmt.Sprintf("access token valid until %s", expiry.Local().Format(time.RFC3339)), nil
+	})
+
+	endpoint, ok := c.doctorDiscovery(cliConnection, report)
+	if !ok {
+		report.Skip("service jumper api", "no service jumper endpoint")
+		return
+	}
+	c.CfServiceJumperAPIEndpoint = endpoint
+
+	query := commandLine.ServiceQuery()
+	if len(query.Name) < 1 {
+		return
+	}
+	if !loggedIn {
+		report.Skip("service instance", "not logged in")
+		return
+	}
+
+	var serviceInstance ServiceInstance
+	ok = report.Check("service instance", "Check the name, --org and --space with 'cf services'.", func() (string, error) {
+		var err error
+		serviceInstance, err = c.ResolveServiceInstance(cliConnection, query)
+		if err != nil {
+			return "", err
+		}
+		if len(serviceInstance.Offering) < 1 {
+			return serviceInstance.GUID, nil
+		}
+		return fmt.Sprintf("%s (%s %s)", serviceInstance.GUID, serviceInstance.Offering, serviceInstance.Plan), nil
+	})
+	if !ok {
+		return
+	}
+
+	ok = report.Check("service jumper api", "", func() (string, error) {
+		err := c.InitJumperAPI(cliConnection)
+		if err != nil {
+			return "", err
+		}
+		forwards, err := c.JumperClient.ListForwards(context.Background(), serviceInstance.GUID)
+		return fmt.Sprintf("%d open forward(s)", len(forwards)), err
+	})
+	if !ok {
+		return
+	}
+
+	var forward ForwardDataSet
+	ok = report.Check("create forward", "", func() (string, error) {
+		var err error
+		forward, err = c.createForward(context.Background(), serviceInstance.GUID)
+		return fmt.Sprintf("forward %d to %s", forward.ID, strings.Join(forward.Hosts, ", ")), err
+	})
+	if !ok {
+		return
+	}
+
+	identity, key, err := GetIdentityAndKey(forward.SharedSecret)
+	for _, host := range forward.Hosts {
+		host := host
+		reachable := report.Check("tcp "+host, fmt.Sprintf("Allow outgoing connections to %s, e.g. in the firewall or proxy.", host), func() (string, error) {
+			conn, err := net.DialTimeout("tcp", host, DoctorDialTimeout)
+			if err != nil {
+				return "", err
+			}
+			conn.Close()
+			return "connected", nil
+		})
+		if !reachable {
+			report.Skip("tls-psk "+host, "tcp connect failed")
+			continue
+		}
+		report.Check("tls-psk "+host, "The port is reachable, but the handshake failed. Check for proxies intercepting TLS and report it with the output of 'cf forward-doctor -vv'.", func() (string, error) {
+			if err != nil {
+				return "", err
+			}
+			return "handshake succeeded", xtunnel.HandshakePSK(host, identity, key, DoctorDialTimeout)
+		})
+	}
+
+	report.Check("delete forward", fmt.Sprintf("Delete it with 'cf delete-forward --guid %s %d'.", serviceInstance.GUID, forward.ID), func() (string, error) {
+		return fmt.Sprintf("forward %d deleted", forward.ID), c.JumperClient.DeleteForward(context.Background(), serviceInstance.GUID, forward.ID)
+	})
+}
+
+// doctorDiscovery checks every endpoint source and returns the endpoint the
+// discovery uses. Failed sources are warnings if another source provides a
+// valid endpoint.
+func (c *CfServiceJumperPlugin) doctorDiscovery(cliConnection plugin.CliConnection, report *DoctorReport) (string, bool) {
+	var err error
+	c.isSSLDisabled, err = cliConnection.IsSSLDisabled()
+	if err == nil {
+		var discovery *EndpointDiscovery
+		discovery, err = c.newEndpointDiscovery(cliConnection, TLSOptionsFromConfig(c.forwardConfig, c.isSSLDisabled))
+		if err == nil {
+			discovery.Config = c.forwardConfig
+			attempts := discovery.ProbeAll()
+			selected := selectEndpointAttempt(attempts)
+			for i, attempt := range attempts {
+				recordEndpointAttempt(report, attempt, selected < 0 || i == selected)
+			}
+			if selected >= 0 {
+				return attempts[selected].Endpoint, attempts[selected].Err == nil
+			}
+			err = ErrCfServiceJumperEndpointNotFound
+		}
+	}
+
+	report.Check("service jumper endpoint", "Set the endpoint with 'cf forward-api URL'.", func() (string, error) {
+		return "", err
+	})
+	return "", false
+}
+
+// selectEndpointAttempt returns the index of the attempt Discover uses or -1
+func selectEndpointAttempt(attempts []EndpointAttempt) int {
+	for i, attempt := range attempts {
+		// an explicitly set endpoint is never bypassed
+		if attempt.Source == EndpointSourceConfig && len(attempt.Endpoint) > 0 {
+			return i
+		}
+		if len(attempt.Endpoint) > 0 && attempt.Err == nil {
+			return i
+		}
+	}
+	return -1
+}
+
+func recordEndpointAttempt(report *DoctorReport, attempt EndpointAttempt, required bool) {
+	name := "endpoint " + string(attempt.Source)
+	if len(attempt.Endpoint) < 1 {
+		report.Skip(name, attempt.Err.Error())
+		return
+	}
+
+	check := DoctorCheck{Name: name, Status: DoctorPass, Duration: attempt.Duration, Detail: attempt.Endpoint}
+	if attempt.Err != nil {
+		check.Status = DoctorWarn
+		if required {
+			check.Status = DoctorFail
+		}
+		check.Detail = fmt.Sprintf("%s: %s", attempt.Endpoint, attempt.Err)
+		if attempt.Source == EndpointSourceConfig {
+			check.Remedy = "Fix the endpoint with 'cf forward-api URL' or remove it with 'cf forward-api --delete'."
+		}
+	}
+	report.Checks = append(report.Checks, check)
+}
+
+// OutputDoctorReport shows the checks followed by the remedies of failed checks
+func OutputDoctorReport(out io.Writer, report *DoctorReport) {
+	table := tablewriter.NewWriter(out)
+	table.SetHeader([]string{"Check", "Result", "Time", "Details"})
+	for _, check := range report.Checks {
+		duration := ""
+		if check.Duration > 0 {
+			duration = check.Duration.Round(time.Millisecond).String()
+		}
+		table.Append([]string{check.Name, strings.ToUpper(string(check.Status)), duration, check.Detail})
+	}
+	table.Render()
+
+	remedies := false
+	for _, check := range report.Checks {
+		if check.Status != DoctorFail || len(check.Remedy) < 1 {
+			continue
+		}
+		if !remedies {
+			fmt.Fprintln(out, "\nRemedies:")
+			remedies = true
+		}
+		fmt.Fprintf(out, "- %s: %s\n", check.Name, check.Remedy)
+	}
+
+	if failed := report.Failed(); failed > 0 {
+		fmt.Fprintf(out, "\n%d check(s) failed.\n", failed)
+	} else {
+		fmt.Fprintln(out, "\nAll checks passed.")
+	}
+}
+
+// OutputDoctorReportJSON prints the checks as json
+func OutputDoctorReportJSON(out io.Writer, report *DoctorReport) error {
+	checks := make([]map[string]interface{}, len(report.Checks))
+	for i, check := range report.Checks {
+		checks[i] = map[string]interface{}{
+			"name":        check.Name,
+			"status":      check.Status,
+			"duration_ms": check.Duration.Nanoseconds() / int64(time.Millisecond),
+			"detail":      check.Detail,
+			"remedy":      check.Remedy,
+		}
+	}
+	return OutputJSON(out, map[string]interface{}{"checks": checks, "failed": report.Failed()})
+}
@@ -0,0 +1,260 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/anynines/cf_service_jumper_cli_plugin/jumperapi"
+	"github.com/anynines/cf_service_jumper_cli_plugin/plugin/config"
+	"github.com/anynines/cf_service_jumper_cli_plugin/plugin/logging"
+)
+
+// EndpointSource is where a service jumper endpoint candidate comes from
+type EndpointSource string
+
+const (
+	EndpointSourceConfig       EndpointSource = "config"
+	EndpointSourceCache        EndpointSource = "cache"
+	EndpointSourceV3Root       EndpointSource = "v3 root"
+	EndpointSourceV2Info       EndpointSource = "/v2/info"
+	EndpointSourceSharedDomain EndpointSource = "shared domain"
+
+	// EndpointHealthCheckTimeout limits the validation of a single candidate
+	EndpointHealthCheckTimeout = 10 * time.Second
+)
+
+var (
+	ErrCfServiceJumperEndpointNotFound = errors.New("[ERR] cf service jumper api endpoint not found. Set it with 'cf forward-api URL', 'cf forward-api --explain' shows the tried sources")
+	ErrEndpointNotSet                  = errors.New("not set")
+)
+
+// EndpointAttempt records a tried endpoint source for 'cf forward-api --explain'
+type EndpointAttempt struct {
+	Source   EndpointSource
+	Endpoint string
+	Err      error
+	Duration time.Duration
+}
+
+// EndpointDiscovery finds the service jumper endpoint of a cf api. Sources are
+// tried in order: the endpoint set by 'cf forward-api', the cached endpoint,
+// the v3 root links, /v2/info and the a9s-service-jumper route of the shared
+// domain. Every candidate is validated with a health check before use.
+type EndpointDiscovery struct {
+	CfAPIEndpoint string
+	Config        config.ForwardConfig
+	// CfHTTPClient requests the cf api
+	CfHTTPClient *http.Client
+	// JumperHTTPClient validates the candidates
+	JumperHTTPClient *http.Client
+	// Logger records the discovery steps if set
+	Logger *logging.Logger
+
+	// Attempts of the last Discover call
+	Attempts []EndpointAttempt
+}
+
+type endpointLookup struct {
+	source EndpointSource
+	lookup func() (string, error)
+}
+
+// lookups returns the endpoint sources in the order of precedence
+func (d *EndpointDiscovery) lookups() []endpointLookup {
+	setting := func(value string) func() (string, error) {
+		return func() (string, error) {
+			if len(value) < 1 {
+				return "", ErrEndpointNotSet
+			}
+			return value, nil
+		}
+	}
+	fetch := func(fetch func(cfAPIEndpoint string, httpClient *http.Client) (string, error)) func() (string, error) {
+		return func() (string, error) {
+			return fetch(d.CfAPIEndpoint, d.CfHTTPClient)
+		}
+	}
+
+	return []endpointLookup{
+		{EndpointSourceConfig, setting(d.Config.TargetFor(d.CfAPIEndpoint))},
+		{EndpointSourceCache, setting(d.Config.DiscoveredTargetFor(d.CfAPIEndpoint))},
+		{EndpointSourceV3Root, fetch(FetchCfServiceJumperAPIEndpointFromV3Root)},
+		{EndpointSourceV2Info, fetch(FetchCfServiceJumperAPIEndpointFromInfo)},
+		{EndpointSourceSharedDomain, func() (string, error) {
+			return FetchCfServiceJumperAPIEndpointFromSharedDomain(d.CfAPIEndpoint)
+		}},
+	}
+}
+
+// Discover returns the attempt of the first valid candidate
+func (d *EndpointDiscovery) Discover() (EndpointAttempt, error) {
+	d.Attempts = nil
+
+	for _, lookup := range d.lookups() {
+		endpoint, err := lookup.lookup()
+		if err != nil {
+			d.skip(lookup.source, err)
+			continue
+		}
+		attempt := d.validate(lookup.source, endpoint)
+		// an explicitly set endpoint is never bypassed
+		if attempt.Err == nil || lookup.source == EndpointSourceConfig {
+			return attempt, attempt.Err
+		}
+	}
+	return EndpointAttempt{}, ErrCfServiceJumperEndpointNotFound
+}
+
+// ProbeAll tries every source, even after a valid candidate was found, and
+// returns the attempts
+func (d *EndpointDiscovery) ProbeAll() []EndpointAttempt {
+	d.Attempts = nil
+
+	for _, lookup := range d.lookups() {
+		endpoint, err := lookup.lookup()
+		if err != nil {
+			d.skip(lookup.source, err)
+			continue
+		}
+		d.validate(lookup.source, endpoint)
+	}
+	return d.Attempts
+}
+
+func (d *EndpointDiscovery) skip(source EndpointSource, err error) {
+	d.Logger.Debugf("endpoint discovery: %s skipped. %s", source, err)
+	d.Attempts = append(d.Attempts, EndpointAttempt{Source: source, Err: err})
+}
+
+func (d *EndpointDiscovery) validate(source EndpointSource, endpoint string) EndpointAttempt {
+	ctx, cancel := context.WithTimeout(context.Background(), EndpointHealthCheckTimeout)
+	defer cancel()
+
+	start := time.Now()
+	attempt := EndpointAttempt{
+		Source:   source,
+		Endpoint: endpoint,
+		Err:      jumperapi.CheckHealth(ctx, d.JumperHTTPClient, endpoint),
+		Duration: time.Since(start),
+	}
+	if attempt.Err != nil {
+		d.Logger.Debugf("endpoint discovery: %s %s failed after %s. %s", source, endpoint, attempt.Duration.Round(time.Millisecond), attempt.Err)
+	} else {
+		d.Logger.Infof("endpoint discovery: using %s from %s (%s)", endpoint, source, attempt.Duration.Round(time.Millisecond))
+	}
+	d.Attempts = append(d.Attempts, attempt)
+	return attempt
+}
+
+// FetchCfServiceJumperAPIEndpoint discovers the Service Jumper API endpoint and
+// caches discovered endpoints per cf api
+func FetchCfServiceJumperAPIEndpoint(discovery *EndpointDiscovery) (string, error) {
+	forwardConfig, err := config.GetConfig()
+	if err != nil {
+		return "", err
+	}
+	discovery.Config = forwardConfig
+
+	attempt, err := discovery.Discover()
+	if err != nil {
+		if discovery.cachedEndpointFailed() {
+			// a stale cache would be validated again on every command
+			config.SetDiscoveredTarget(discovery.CfAPIEndpoint, "")
+		}
+		return "", err
+	}
+
+	if attempt.Source != EndpointSourceConfig && attempt.Endpoint != forwardConfig.DiscoveredTargetFor(discovery.CfAPIEndpoint) {
+		// the cache is an optimization, discovery works without it
+		config.SetDiscoveredTarget(discovery.CfAPIEndpoint, attempt.Endpoint)
+	}
+	return attempt.Endpoint, nil
+}
+
+// cachedEndpointFailed reports whether the last Discover call validated the
+// cached endpoint without success
+func (d *EndpointDiscovery) cachedEndpointFailed() bool {
+	for _, attempt := range d.Attempts {
+		if attempt.Source == EndpointSourceCache && len(attempt.Endpoint) > 0 && attempt.Err != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// FetchCfServiceJumperAPIEndpointFromV3Root reads the service_jumper link of the v3 root
+func FetchCfServiceJumperAPIEndpointFromV3Root(cfAPIEndpoint string, httpClient *http.Client) (string, error) {
+	type CfRoot struct {
+		Links map[string]struct {
+			Href string `json:"href"`
+		} `json:"links"`
+	}
+	var cfRoot CfRoot
+	err := fetchCfInfo(httpClient, strings.TrimSuffix(cfAPIEndpoint, "/")+"/", &cfRoot)
+	if err != nil {
+		return "", err
+	}
+
+	serviceJumperEndpoint := cfRoot.Links["service_jumper"].Href
+	if len(serviceJumperEndpoint) < 1 {
+		return "", ErrCfServiceJumperEndpointNotPresent
+	}
+	return serviceJumperEndpoint, nil
+}
+
+func FetchCfServiceJumperAPIEndpointFromInfo(cfAPIEndpoint string, httpClient *http.Client) (string, error) {
+	type CfInfo struct {
+		Custom map[string]string `json:"custom"`
+	}
+	var cfInfo CfInfo
+	err := fetchCfInfo(httpClient, fmt.Sprintf("%s/v2/info", cfAPIEndpoint), &cfInfo)
+	if err != nil {
+		return "", err
+	}
+
+	serviceJumperEndpoint := cfInfo.Custom["service_jumper_endpoint"]
+	if len(serviceJumperEndpoint) < 1 {
+		return "", ErrCfServiceJumperEndpointNotPresent
+	}
+
+	return serviceJumperEndpoint, nil
+}
+
+func fetchCfInfo(httpClient *http.Client, url string, result interface{}) error {
+	resp, err := httpClient.Get(url)
+	if err != nil {
+		return fmt.Errorf("%w. %w", ErrCfServiceJumperEndpointGetFailed, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%w. GET %s: %d %s", ErrCfServiceJumperEndpointStatusCodeWrong, url, resp.StatusCode, http.StatusText(resp.StatusCode))
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("%w. GET %s: %w", ErrCfServiceJumperEndpointGetFailed, url, err)
+	}
+
+	err = json.Unmarshal(body, result)
+	if err != nil {
+		return fmt.Errorf("[ERR] cf service jumper api endpoint unmarshal failed. %s", err)
+	}
+	return nil
+}
+
+func FetchCfServiceJumperAPIEndpointFromSharedDomain(cfAPIEndpoint string) (string, error) {
+	u, err := url.Parse(cfAPIEndpoint)
+	if err != nil {
+		return "", err
+	}
+
+	u.Host = strings.TrimPrefix(u.Host, "api.")
+	u.Host = PcfServiceJumperHostname + "." + u.Host
+	return u.String(), nil
+}
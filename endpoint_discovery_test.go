@@ -0,0 +1,186 @@
+package main_test
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+
+	. "github.com/anynines/cf_service_jumper_cli_plugin"
+	"github.com/anynines/cf_service_jumper_cli_plugin/plugin/config"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("EndpointDiscovery", func() {
+	var cfServer, jumperServer *httptest.Server
+	var v3Root, v2Info string
+	var discovery *EndpointDiscovery
+
+	BeforeEach(func() {
+		// the service jumper rejects the forwards of the health check without access token
+		jumperServer = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusUnauthorized)
+		}))
+		v3Root = `{"links": {}}`
+		v2Info = `{"custom": {}}`
+		cfServer = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			switch r.URL.Path {
+			case "/":
+				fmt.Fprint(w, v3Root)
+			case "/v2/info":
+				fmt.Fprint(w, v2Info)
+			default:
+				w.WriteHeader(http.StatusNotFound)
+			}
+		}))
+
+		discovery = &EndpointDiscovery{
+			CfAPIEndpoint:    cfServer.URL,
+			CfHTTPClient:     http.DefaultClient,
+			JumperHTTPClient: http.DefaultClient,
+		}
+	})
+
+	AfterEach(func() {
+		cfServer.Close()
+		jumperServer.Close()
+	})
+
+	It("uses the endpoint set for the cf api", func() {
+		discovery.Config.Targets = map[string]string{config.APIKey(cfServer.URL + "/"): jumperServer.URL}
+
+		attempt, err := discovery.Discover()
+		Expect(err).To(BeNil())
+		Expect(attempt.Source).To(Equal(EndpointSourceConfig))
+		Expect(attempt.Endpoint).To(Equal(jumperServer.URL))
+	})
+
+	It("doesn't bypass an unhealthy endpoint set for the cf api", func() {
+		v2Info = fmt.Sprintf(`{"custom": {"service_jumper_endpoint": "%s"}}`, jumperServer.URL)
+		discovery.Config.Settings = map[string]string{config.SettingTarget: jumperServer.URL}
+		jumperServer.Close()
+
+		_, err := discovery.Discover()
+		Expect(err).ToNot(BeNil())
+		Expect(discovery.Attempts).To(HaveLen(1))
+	})
+
+	It("prefers the v3 root link", func() {
+		v3Root = fmt.Sprintf(`{"links": {"service_jumper": {"href": "%s"}}}`, jumperServer.URL)
+		v2Info = `{"custom": {"service_jumper_endpoint": "https://other.example.com"}}`
+
+		attempt, err := discovery.Discover()
+		Expect(err).To(BeNil())
+		Expect(attempt.Source).To(Equal(EndpointSourceV3Root))
+		Expect(attempt.Endpoint).To(Equal(jumperServer.URL))
+	})
+
+	It("falls back to /v2/info and records why the other sources failed", func() {
+		v2Info = fmt.Sprintf(`{"custom": {"service_jumper_endpoint": "%s"}}`, jumperServer.URL)
+
+		attempt, err := discovery.Discover()
+		Expect(err).To(BeNil())
+		Expect(attempt.Source).To(Equal(EndpointSourceV2Info))
+
+		Expect(discovery.Attempts).To(HaveLen(4))
+		Expect(discovery.Attempts[0]).To(Equal(EndpointAttempt{Source: EndpointSourceConfig, Err: ErrEndpointNotSet}))
+		Expect(discovery.Attempts[1]).To(Equal(EndpointAttempt{Source: EndpointSourceCache, Err: ErrEndpointNotSet}))
+		Expect(discovery.Attempts[2]).To(Equal(EndpointAttempt{Source: EndpointSourceV3Root, Err: ErrCfServiceJumperEndpointNotPresent}))
+		Expect(discovery.Attempts[3].Err).To(BeNil())
+	})
+
+	It("records the status code and network errors of failed cf api requests", func() {
+		discovery.CfAPIEndpoint = cfServer.URL + "/missing"
+
+		_, err := discovery.Discover()
+		Expect(err).ToNot(BeNil())
+		Expect(errors.Is(discovery.Attempts[2].Err, ErrCfServiceJumperEndpointStatusCodeWrong)).To(BeTrue())
+		Expect(discovery.Attempts[2].Err.Error()).To(ContainSubstring("404 Not Found"))
+
+		cfServer.Close()
+		discovery.Attempts = nil
+		_, err = discovery.Discover()
+		Expect(err).ToNot(BeNil())
+		Expect(errors.Is(discovery.Attempts[2].Err, ErrCfServiceJumperEndpointGetFailed)).To(BeTrue())
+		var opError *net.OpError
+		Expect(errors.As(discovery.Attempts[2].Err, &opError)).To(BeTrue())
+		Expect(opError.Op).To(Equal("dial"))
+	})
+
+	It("skips cached endpoints failing the health check", func() {
+		v2Info = fmt.Sprintf(`{"custom": {"service_jumper_endpoint": "%s"}}`, jumperServer.URL)
+		routerServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("X-Cf-Routererror", "unknown_route")
+			w.WriteHeader(http.StatusNotFound)
+		}))
+		defer routerServer.Close()
+		discovery.Config.DiscoveredTargets = map[string]string{config.APIKey(cfServer.URL): routerServer.URL}
+
+		attempt, err := discovery.Discover()
+		Expect(err).To(BeNil())
+		Expect(attempt.Source).To(Equal(EndpointSourceV2Info))
+		Expect(discovery.Attempts[1].Source).To(Equal(EndpointSourceCache))
+		Expect(discovery.Attempts[1].Err).ToNot(BeNil())
+	})
+
+	It("errors if no source provides a healthy endpoint", func() {
+		discovery.CfAPIEndpoint = cfServer.URL + "/%zz"
+
+		_, err := discovery.Discover()
+		Expect(err).To(Equal(ErrCfServiceJumperEndpointNotFound))
+		Expect(discovery.Attempts[len(discovery.Attempts)-1].Source).To(Equal(EndpointSourceSharedDomain))
+	})
+
+	Describe("FetchCfServiceJumperAPIEndpoint", func() {
+		var cfHome string
+
+		BeforeEach(func() {
+			var err error
+			cfHome, err = ioutil.TempDir("", "cf_home")
+			Expect(err).To(BeNil())
+			Expect(os.Mkdir(filepath.Join(cfHome, ".cf"), 0700)).To(Succeed())
+			os.Setenv("CF_HOME", cfHome)
+		})
+
+		AfterEach(func() {
+			os.Unsetenv("CF_HOME")
+			os.RemoveAll(cfHome)
+		})
+
+		It("caches discovered endpoints per cf api", func() {
+			v2Info = fmt.Sprintf(`{"custom": {"service_jumper_endpoint": "%s"}}`, jumperServer.URL)
+
+			endpoint, err := FetchCfServiceJumperAPIEndpoint(discovery)
+			Expect(err).To(BeNil())
+			Expect(endpoint).To(Equal(jumperServer.URL))
+
+			forwardConfig, err := config.GetConfig()
+			Expect(err).To(BeNil())
+			Expect(forwardConfig.DiscoveredTargetFor(cfServer.URL)).To(Equal(jumperServer.URL))
+
+			v2Info = `{"custom": {}}`
+			discovery.Config = forwardConfig
+			attempt, err := discovery.Discover()
+			Expect(err).To(BeNil())
+			Expect(attempt.Source).To(Equal(EndpointSourceCache))
+		})
+
+		It("clears a cached endpoint failing the health check", func() {
+			Expect(config.SetDiscoveredTarget(cfServer.URL, jumperServer.URL)).To(Succeed())
+			jumperServer.Close()
+
+			_, err := FetchCfServiceJumperAPIEndpoint(discovery)
+			Expect(err).To(Equal(ErrCfServiceJumperEndpointNotFound))
+
+			forwardConfig, err := config.GetConfig()
+			Expect(err).To(BeNil())
+			Expect(forwardConfig.DiscoveredTargetFor(cfServer.URL)).To(BeEmpty())
+		})
+	})
+})
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/anynines/cf_service_jumper_cli_plugin/jumperapi"
+)
+
+// Exit codes of the plugin process. Errors of the service jumper api get a
+// distinct exit code per error class. The cf cli exits with 1 on any nonzero
+// exit code, so they are also printed with the error.
+const (
+	ExitCodeOK                    = 0
+	ExitCodeError                 = 1
+	ExitCodeUsage                 = 2
+	ExitCodeNotReady              = 3
+	ExitCodeJumperUnauthorized    = 10
+	ExitCodeJumperForbidden       = 11
+	ExitCodeJumperNotFound        = 12
+	ExitCodeJumperConflict        = 13
+	ExitCodeJumperUnprocessable   = 14
+	ExitCodeJumperServerError     = 15
+	ExitCodeJumperNotReachable    = 16
+	ExitCodeJumperInvalidResponse = 17
+	// ExitCodeInterrupted forward-app was interrupted before the command started
+	ExitCodeInterrupted = 130
+)
+
+var exitCodesByErrorKind = map[jumperapi.ErrorKind]int{
+	jumperapi.ErrorKindUnauthorized:  ExitCodeJumperUnauthorized,
+	jumperapi.ErrorKindForbidden:     ExitCodeJumperForbidden,
+	jumperapi.ErrorKindNotFound:      ExitCodeJumperNotFound,
+	jumperapi.ErrorKindConflict:      ExitCodeJumperConflict,
+	jumperapi.ErrorKindUnprocessable: ExitCodeJumperUnprocessable,
+	jumperapi.ErrorKindServer:        ExitCodeJumperServerError,
+}
+
+// CommandExitError passes on the non-zero exit code of a command run by the
+// plugin, e.g. by forward-app
+type CommandExitError struct {
+	ExitCode int
+}
+
+func (e *CommandExitError) Error() string {
+	return fmt.Sprintf("[ERR] command exited with status %d", e.ExitCode)
+}
+
+// Hinter is implemented by errors which know how the user might solve them
+type Hinter interface {
+	Hint() string
+}
+
+// ExitCode returns the exit code for err, including wrapped errors
+func ExitCode(err error) int {
+	if err == nil {
+		return ExitCodeOK
+	}
+
+	var commandExitError *CommandExitError
+	var apiError *jumperapi.APIError
+	var requestError *jumperapi.RequestError
+	var decodeError *jumperapi.DecodeError
+	var usageError *UsageError
+	var readinessError *ReadinessError
+	switch {
+	case errors.As(err, &commandExitError):
+		return commandExitError.ExitCode
+	case errors.As(err, &apiError):
+		if exitCode, ok := exitCodesByErrorKind[apiError.Kind]; ok {
+			return exitCode
+		}
+	case errors.As(err, &requestError):
+		return ExitCodeJumperNotReachable
+	case errors.As(err, &decodeError):
+		return ExitCodeJumperInvalidResponse
+	case errors.As(err, &usageError):
+		return ExitCodeUsage
+	case errors.As(err, &readinessError):
+		return ExitCodeNotReady
+	}
+	return ExitCodeError
+}
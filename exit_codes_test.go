@@ -0,0 +1,30 @@
+package main_test
+
+import (
+	"errors"
+	"fmt"
+
+	. "github.com/anynines/cf_service_jumper_cli_plugin"
+	"github.com/anynines/cf_service_jumper_cli_plugin/jumperapi"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("ExitCode", func() {
+	It("returns distinct exit codes per error class", func() {
+		Expect(ExitCode(nil)).To(Equal(ExitCodeOK))
+		Expect(ExitCode(errors.New("any error"))).To(Equal(ExitCodeError))
+		Expect(ExitCode(jumperapi.NewAPIError(401, ""))).To(Equal(ExitCodeJumperUnauthorized))
+		Expect(ExitCode(jumperapi.NewAPIError(409, ""))).To(Equal(ExitCodeJumperConflict))
+		Expect(ExitCode(jumperapi.NewAPIError(500, ""))).To(Equal(ExitCodeJumperServerError))
+		Expect(ExitCode(jumperapi.NewAPIError(418, ""))).To(Equal(ExitCodeError))
+		Expect(ExitCode(&ReadinessError{Err: errors.New("no host accepted a handshake")})).To(Equal(ExitCodeNotReady))
+		Expect(ExitCode(&jumperapi.RequestError{Err: errors.New("connection refused")})).To(Equal(ExitCodeJumperNotReachable))
+	})
+
+	It("returns the exit code of wrapped errors", func() {
+		err := fmt.Errorf("[ERR] Failed to list forwards. %w", jumperapi.NewAPIError(403, ""))
+		Expect(ExitCode(err)).To(Equal(ExitCodeJumperForbidden))
+		Expect(ExitCode(fmt.Errorf("wrapped: %w", &CommandExitError{ExitCode: 7}))).To(Equal(7))
+	})
+})
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+
+	"github.com/anynines/cf_service_jumper_cli_plugin/plugin/config"
+	"github.com/cloudfoundry/cli/plugin"
+)
+
+// ForwardAPI shows, sets (URL), deletes (-d) or explains (--explain) the service
+// jumper endpoint of the targeted cf api
+func (c *CfServiceJumperPlugin) ForwardAPI(cliConnection plugin.CliConnection, commandLine *CommandLine) error {
+	apiEndpoint, err := cliConnection.ApiEndpoint()
+	if err != nil {
+		return err
+	}
+
+	if commandLine.Bool("--explain") {
+		return c.explainForwardAPI(cliConnection)
+	}
+
+	if commandLine.Bool("--delete") {
+		// delete forward api endpoint
+		return config.SetTarget(apiEndpoint, "")
+	}
+
+	if target := commandLine.Arg(0); len(target) > 0 {
+		// set forward endpoint
+		_, err := url.ParseRequestURI(target)
+		if err != nil {
+			return err
+		}
+
+		err = config.SetTarget(apiEndpoint, target)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(c.stdout(), "forward-api of %s set to %s\n", apiEndpoint, target)
+		return nil
+	}
+
+	// show forward endpoint
+	forwardConfig := c.forwardConfig
+	if target := forwardConfig.TargetFor(apiEndpoint); len(target) > 0 {
+		fmt.Fprintf(c.stdout(), "forward-api %s\n", target)
+		return nil
+	}
+	if target := forwardConfig.DiscoveredTargetFor(apiEndpoint); len(target) > 0 {
+		fmt.Fprintf(c.stdout(), "forward-api %s (discovered)\n", target)
+		return nil
+	}
+	return config.ErrTargetBlank
+}
+
+// explainForwardAPI runs the endpoint discovery and shows the tried sources
+func (c *CfServiceJumperPlugin) explainForwardAPI(cliConnection plugin.CliConnection) error {
+	var err error
+	c.isSSLDisabled, err = cliConnection.IsSSLDisabled()
+	if err != nil {
+		return err
+	}
+
+	discovery, err := c.newEndpointDiscovery(cliConnection, TLSOptionsFromConfig(c.forwardConfig, c.isSSLDisabled))
+	if err != nil {
+		return err
+	}
+	discovery.Config = c.forwardConfig
+
+	attempt, err := discovery.Discover()
+	OutputEndpointAttempts(c.stdout(), discovery.CfAPIEndpoint, discovery.Attempts)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(c.stdout(), "\nUsing %s from %s\n", attempt.Endpoint, attempt.Source)
+	return nil
+}
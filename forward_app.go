@@ -0,0 +1,241 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"os/signal"
+	"sort"
+	"syscall"
+
+	"github.com/anynines/cf_service_jumper_cli_plugin/plugin/config"
+	"github.com/anynines/cf_service_jumper_cli_plugin/xtunnel"
+	"github.com/cloudfoundry/cli/plugin"
+)
+
+// VcapService is a service entry of the VCAP_SERVICES environment variable
+type VcapService struct {
+	Name           string               `json:"name"`
+	InstanceGUID   string               `json:"instance_guid,omitempty"`
+	InstanceName   string               `json:"instance_name,omitempty"`
+	BindingGUID    string               `json:"binding_guid,omitempty"`
+	BindingName    string               `json:"binding_name,omitempty"`
+	Label          string               `json:"label"`
+	Plan           string               `json:"plan"`
+	Provider       string               `json:"provider,omitempty"`
+	Tags           []string             `json:"tags"`
+	SyslogDrainURL string               `json:"syslog_drain_url,omitempty"`
+	VolumeMounts   []interface{}        `json:"volume_mounts"`
+	Credentials    ForwardSbCredentials `json:"credentials"`
+}
+
+// AppServiceForward is a forward to a service bound to an app
+type AppServiceForward struct {
+	ServiceGUID string
+	Forward     ForwardDataSet
+	Tunnels     []*xtunnel.XTunnel
+	VcapService VcapService
+}
+
+// BuildVcapServices returns the VCAP_SERVICES json of the app's bindings. The
+// credentials of forwarded services point to the local tunnels, unforwarded
+// services like user provided ones are passed on unchanged.
+func BuildVcapServices(appServiceForwards []AppServiceForward, unforwarded []VcapService) (string, error) {
+	services := append([]VcapService{}, unforwarded...)
+	for _, appServiceForward := range appServiceForwards {
+		vcapService := appServiceForward.VcapService
+		vcapService.Credentials = appServiceForward.Forward.Credentials.Credentials.WithLocalAddresses(LocalAddresses(appServiceForward.Tunnels))
+		services = append(services, vcapService)
+	}
+
+	vcapServices := make(map[string][]VcapService)
+	for _, vcapService := range services {
+		if vcapService.Tags == nil {
+			vcapService.Tags = []string{}
+		}
+		if vcapService.VolumeMounts == nil {
+			vcapService.VolumeMounts = []interface{}{}
+		}
+		vcapServices[vcapService.Label] = append(vcapServices[vcapService.Label], vcapService)
+	}
+
+	data, err := json.Marshal(vcapServices)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+// FetchAppVcapServices returns the service bindings of the app from its
+// VCAP_SERVICES, including tags, instance and binding names
+func FetchAppVcapServices(cfAPIClient CfAPIClient, appGUID string) ([]VcapService, error) {
+	var appEnv struct {
+		SystemEnvJSON struct {
+			VcapServices map[string][]VcapService `json:"VCAP_SERVICES"`
+		} `json:"system_env_json"`
+	}
+	err := cfAPIClient.Get(fmt.Sprintf("/v3/apps/%s/env", appGUID), nil, &appEnv)
+	if err != nil {
+		return nil, fmt.Errorf("[ERR] Failed to get the environment of app %s. %s", appGUID, err)
+	}
+
+	var vcapServices []VcapService
+	for _, services := range appEnv.SystemEnvJSON.VcapServices {
+		vcapServices = append(vcapServices, services...)
+	}
+	sort.Slice(vcapServices, func(i, j int) bool {
+		return vcapServices[i].Name < vcapServices[j].Name
+	})
+	return vcapServices, nil
+}
+
+// ForwardApp creates forwards to all services bound to the app and runs command
+// with VCAP_SERVICES pointing to the local tunnels. Returns the exit code of command.
+// Interrupts while the forwards are created stop and delete them.
+func (c *CfServiceJumperPlugin) ForwardApp(cliConnection plugin.CliConnection, appName string, command []string) (int, error) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(signals)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	forwarding := make(chan struct{})
+	watcherDone := make(chan struct{})
+	go func() {
+		defer close(watcherDone)
+		select {
+		case <-signals:
+			cancel()
+		case <-forwarding:
+		case <-ctx.Done():
+		}
+	}()
+
+	interrupted := func() (int, error) {
+		fmt.Fprintln(c.messageWriter(), "Interrupted, deleting the forwards")
+		return ExitCodeInterrupted, nil
+	}
+
+	var appServiceForwards []AppServiceForward
+	var unforwarded []VcapService
+	defer func() {
+		for _, appServiceForward := range appServiceForwards {
+			ShutdownTunnels(c.stderr(), appServiceForward.Tunnels)
+
+			c.logger.Infof("deleting forward %d of service %s", appServiceForward.Forward.ID, appServiceForward.VcapService.Name)
+			err := c.JumperClient.DeleteForward(context.Background(), appServiceForward.ServiceGUID, appServiceForward.Forward.ID)
+			if err != nil {
+				fmt.Fprintln(c.stderr(), err)
+			}
+		}
+	}()
+
+	app, err := cliConnection.GetApp(appName)
+	if err != nil {
+		return 0, fmt.Errorf("[ERR] Failed to get app %s. %s", appName, err)
+	}
+	cfAPIClient, err := c.newCfAPIClient(cliConnection)
+	if err != nil {
+		return 0, err
+	}
+	vcapServices, err := FetchAppVcapServices(cfAPIClient, app.Guid)
+	if err != nil {
+		return 0, err
+	}
+
+	for _, vcapService := range vcapServices {
+		if ctx.Err() != nil {
+			return interrupted()
+		}
+		if vcapService.Label == "user-provided" {
+			fmt.Fprintf(c.stdout(), "Passing on user provided service %s unchanged\n", vcapService.Name)
+			unforwarded = append(unforwarded, vcapService)
+			continue
+		}
+
+		appServiceForward := AppServiceForward{ServiceGUID: vcapService.InstanceGUID, VcapService: vcapService}
+		if len(appServiceForward.ServiceGUID) < 1 {
+			instanceName := vcapService.InstanceName
+			if len(instanceName) < 1 {
+				instanceName = vcapService.Name
+			}
+			service, err := cliConnection.GetService(instanceName)
+			if err != nil {
+				return 0, fmt.Errorf("[ERR] Failed to get service %s. %s", instanceName, err)
+			}
+			appServiceForward.ServiceGUID = service.Guid
+		}
+
+		appServiceForward.Forward, err = c.createForward(ctx, appServiceForward.ServiceGUID)
+		if ctx.Err() != nil {
+			return interrupted()
+		}
+		if err != nil {
+			fmt.Fprintf(c.stdout(), "Skipping service %s. %s\n", vcapService.Name, err)
+			continue
+		}
+
+		fmt.Fprintf(c.stdout(), "Forwarding service %s\n", vcapService.Name)
+		appServiceForward.Tunnels, err = ListenTunnels(c.messageWriter(), c.forwardConfig.Value(config.SettingBindAddress), appServiceForward.Forward.Hosts, appServiceForward.Forward.SharedSecret, c.logTunnelEvent)
+		appServiceForwards = append(appServiceForwards, appServiceForward)
+		if err != nil {
+			return 0, err
+		}
+		ServeTunnels(c.stderr(), appServiceForward.Tunnels)
+	}
+
+	close(forwarding)
+	<-watcherDone
+	if ctx.Err() != nil {
+		return interrupted()
+	}
+
+	vcapServicesJSON, err := BuildVcapServices(appServiceForwards, unforwarded)
+	if err != nil {
+		return 0, err
+	}
+
+	c.logger.Infof("running %s with %d forwarded service(s)", command[0], len(appServiceForwards))
+	exitCode, err := runWithVcapServices(command, vcapServicesJSON, signals, c.stdout(), c.stderr())
+	c.logger.Infof("%s exited with status %d, shutting down", command[0], exitCode)
+	return exitCode, err
+}
+
+// runWithVcapServices runs command with VCAP_SERVICES set. Interrupts are left
+// to the command so the forwards are cleaned up after it exited.
+func runWithVcapServices(command []string, vcapServices string, signals <-chan os.Signal, stdout io.Writer, stderr io.Writer) (int, error) {
+	cmd := exec.Command(command[0], command[1:]...)
+	cmd.Env = append(os.Environ(), "VCAP_SERVICES="+vcapServices)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+
+	err := cmd.Start()
+	if err != nil {
+		return 0, fmt.Errorf("[ERR] Failed to run %s. %s", command[0], err)
+	}
+	exited := make(chan struct{})
+	defer close(exited)
+	go func() {
+		for {
+			select {
+			case sig := <-signals:
+				cmd.Process.Signal(sig)
+			case <-exited:
+				return
+			}
+		}
+	}()
+
+	err = cmd.Wait()
+	if exitErr, ok := err.(*exec.ExitError); ok {
+		if status, ok := exitErr.Sys().(syscall.WaitStatus); ok {
+			return status.ExitStatus(), nil
+		}
+		return 1, nil
+	}
+	return 0, err
+}
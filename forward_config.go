@@ -0,0 +1,106 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/anynines/cf_service_jumper_cli_plugin/plugin/config"
+	"github.com/olekukonko/tablewriter"
+)
+
+var (
+	ErrUnknownForwardConfigCommand = errors.New("[ERR] unknown forward-config command. Use: cf forward-config get|set|unset|list")
+	ErrMissingSettingKey           = errors.New("[ERR] missing KEY")
+	ErrMissingSettingValue         = errors.New("[ERR] missing VALUE")
+)
+
+// ValidateForwardConfigArgs checks the subcommand and its KEY and VALUE
+func ValidateForwardConfigArgs(commandLine *CommandLine) error {
+	required := map[string]int{"": 0, "list": 0, "get": 1, "unset": 1, "set": 2}
+	count, ok := required[commandLine.Arg(0)]
+	if !ok {
+		return ErrUnknownForwardConfigCommand
+	}
+	switch args := len(commandLine.Args) - 1; {
+	case args > count:
+		return fmt.Errorf("[ERR] unexpected argument(s) %s", strings.Join(commandLine.Args[count+1:], " "))
+	case count > 0 && args < 1:
+		return ErrMissingSettingKey
+	case count > 1 && args < 2:
+		return ErrMissingSettingValue
+	}
+	return nil
+}
+
+// ForwardConfigCommand gets, sets, unsets or lists the settings of forward.json
+func (c *CfServiceJumperPlugin) ForwardConfigCommand(commandLine *CommandLine) error {
+	subcommand, key, value := commandLine.Arg(0), commandLine.Arg(1), commandLine.Arg(2)
+	if len(subcommand) < 1 || subcommand == "list" {
+		if c.output == "json" {
+			return OutputSettingsJSON(c.stdout(), c.forwardConfig)
+		}
+		OutputSettings(c.stdout(), c.forwardConfig)
+		return nil
+	}
+
+	setting, err := config.LookupSetting(key)
+	if err != nil {
+		return err
+	}
+
+	switch subcommand {
+	case "get":
+		if c.output == "json" {
+			return OutputJSON(c.stdout(), settingJSON(c.forwardConfig, setting))
+		}
+		fmt.Fprintln(c.stdout(), c.forwardConfig.Value(key))
+	case "set":
+		err = config.Set(key, value)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(c.messageWriter(), "forward-config %s set to %s\n", key, value)
+		c.warnOverridden(setting)
+	case "unset":
+		err = config.Unset(key)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(c.messageWriter(), "forward-config %s unset\n", key)
+		c.warnOverridden(setting)
+	}
+	return nil
+}
+
+func (c *CfServiceJumperPlugin) warnOverridden(setting config.Setting) {
+	if _, source := c.forwardConfig.Lookup(setting.Key); source == config.SourceEnv {
+		fmt.Fprintf(c.messageWriter(), "Note: %s overrides the setting\n", setting.EnvVar())
+	}
+}
+
+// OutputSettings shows the effective value and the source of all settings
+func OutputSettings(out io.Writer, forwardConfig config.ForwardConfig) {
+	table := tablewriter.NewWriter(out)
+	table.SetHeader([]string{"Key", "Value", "Source", "Env"})
+	for _, setting := range config.Settings {
+		value, source := forwardConfig.Lookup(setting.Key)
+		table.Append([]string{setting.Key, value, string(source), setting.EnvVar()})
+	}
+	table.Render()
+}
+
+// OutputSettingsJSON prints all settings with their source as json
+func OutputSettingsJSON(out io.Writer, forwardConfig config.ForwardConfig) error {
+	settings := make([]map[string]string, len(config.Settings))
+	for i, setting := range config.Settings {
+		settings[i] = settingJSON(forwardConfig, setting)
+	}
+	return OutputJSON(out, settings)
+}
+
+func settingJSON(forwardConfig config.ForwardConfig, setting config.Setting) map[string]string {
+	value, source := forwardConfig.Lookup(setting.Key)
+	return map[string]string{"key": setting.Key, "value": value, "source": string(source), "env": setting.EnvVar()}
+}
@@ -1,63 +1,112 @@
 package main
 
 import (
+	"errors"
 	"fmt"
+	"io"
+	"net"
 	"os"
 	"os/signal"
 	"syscall"
+	"time"
 
 	"github.com/anynines/cf_service_jumper_cli_plugin/xtunnel"
 )
 
-func ListenAndOutputInfo(hosts []string, sharedSecret string, connectionPrinter ConnectionPrinter) error {
-	var err error
+// Tunnel is a listening tunnel with its counters, implemented by xtunnel.XTunnel
+type Tunnel interface {
+	LocalAddress() string
+	RemoteAddress() string
+	Stats() xtunnel.Stats
+	CloseConnections() int
+}
+
+// PSKProbe returns a check of hosts with the TLS-PSK handshake of the forward's
+// shared secret
+func PSKProbe(sharedSecret string, timeout time.Duration) (func(host string) error, error) {
+	identity, key, err := GetIdentityAndKey(sharedSecret)
+	if err != nil {
+		return nil, err
+	}
+	return func(host string) error {
+		return xtunnel.HandshakePSK(host, identity, key, timeout)
+	}, nil
+}
 
+// tunnelsOf returns the tunnels as Tunnel interfaces
+func tunnelsOf(xtunnels []*xtunnel.XTunnel) []Tunnel {
+	tunnels := make([]Tunnel, len(xtunnels))
+	for i, xt := range xtunnels {
+		tunnels[i] = xt
+	}
+	return tunnels
+}
+
+// ListenTunnels opens a local listen socket on bindAddress for every host of the forward
+// and reports the addresses to out. onEvent receives the events of the tunnels if set.
+func ListenTunnels(out io.Writer, bindAddress string, hosts []string, sharedSecret string, onEvent func(event xtunnel.Event)) ([]*xtunnel.XTunnel, error) {
 	identity, key, err := GetIdentityAndKey(sharedSecret)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	tunnels := make([]*xtunnel.XTunnel, 0)
 	for _, host := range hosts {
-		xt := xtunnel.NewXTunnelPSK("localhost:0", host, identity, key)
+		xt := xtunnel.NewXTunnelPSK(net.JoinHostPort(bindAddress, "0"), host, identity, key)
+		xt.OnEvent = onEvent
 		localListenAddress, err := xt.Listen()
 		if err != nil {
-			return err
+			ShutdownTunnels(out, tunnels)
+			return nil, err
 		}
-		fmt.Println(fmt.Sprintf("Listening on %s", localListenAddress))
+		fmt.Fprintf(out, "Listening on %s\n", localListenAddress)
 
 		tunnels = append(tunnels, xt)
 	}
 
+	return tunnels, nil
+}
+
+// ServeTunnels processes client connections of all tunnels in the background
+// and reports failures to out. Closing the listener on shutdown isn't a failure.
+func ServeTunnels(out io.Writer, tunnels []*xtunnel.XTunnel) {
 	for _, tunnel := range tunnels {
 		go func(tunnel *xtunnel.XTunnel) {
-			err = tunnel.Serve()
-			if err != nil {
-				fmt.Println(fmt.Sprintf("Error on %s: %s", tunnel.LocalAddress(), err))
+			err := tunnel.Serve()
+			if err != nil && !errors.Is(err, net.ErrClosed) {
+				fmt.Fprintf(out, "Error on %s: %s\n", tunnel.LocalAddress(), err)
 			}
 		}(tunnel)
 	}
+}
 
-	var sampleOutputs []string
-	for _, tunnel := range tunnels {
-		sampleOutput := connectionPrinter.SampleCallOutput(tunnel.LocalAddress())
-		if len(sampleOutput) > 0 {
-			sampleOutputs = append(sampleOutputs, sampleOutput)
-		}
-	}
-	OutputSampleCmds(sampleOutputs)
+// WaitForSignal blocks until the process is interrupted or terminated.
+func WaitForSignal() {
+	_ = <-ShutdownSignals()
+}
 
+// ShutdownSignals returns a channel receiving interrupt and terminate signals
+func ShutdownSignals() <-chan os.Signal {
 	c := make(chan os.Signal, 1)
 	signal.Notify(c, os.Interrupt, os.Kill, syscall.SIGTERM)
+	return c
+}
 
-	_ = <-c
-
+// ShutdownTunnels closes the listen sockets of all tunnels and reports failures to out.
+func ShutdownTunnels(out io.Writer, tunnels []*xtunnel.XTunnel) {
 	for _, tunnel := range tunnels {
-		err = tunnel.Shutdown()
+		err := tunnel.Shutdown()
 		if err != nil {
-			fmt.Println("[ERR] Failed to shutdown listen socket", err)
+			fmt.Fprintln(out, "[ERR] Failed to shutdown listen socket", err)
 		}
 	}
+}
 
-	return nil
+// LocalAddresses returns the local listen addresses of the tunnels.
+func LocalAddresses(tunnels []*xtunnel.XTunnel) []string {
+	addresses := make([]string, len(tunnels))
+	for i, tunnel := range tunnels {
+		addresses[i] = tunnel.LocalAddress()
+	}
+	return addresses
 }
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/anynines/cf_service_jumper_cli_plugin/plugin/metrics"
+	"github.com/anynines/cf_service_jumper_cli_plugin/xtunnel"
+)
+
+const (
+	// MetricsProbeInterval is the maximum age of the dial or probe a host is
+	// reported up or down by
+	MetricsProbeInterval = 30 * time.Second
+	// MetricsProbeTimeout limits the handshake probing an idle host
+	MetricsProbeTimeout = 5 * time.Second
+)
+
+type hostCheck struct {
+	at  time.Time
+	err error
+}
+
+// ForwardMetrics exposes the tunnels of a forward in the prometheus text
+// format. Latencies are recorded from the dial events of the tunnels, hosts
+// without recent dials are probed with a handshake on scrape.
+type ForwardMetrics struct {
+	ForwardID   int
+	ServiceName string
+	Created     time.Time
+	// Tunnels must be set before metrics are served
+	Tunnels []Tunnel
+	// Probe checks an idle host, e.g. with a TLS-PSK handshake
+	Probe func(host string) error
+
+	mutex      sync.Mutex
+	dials      map[string]*metrics.Histogram
+	handshakes map[string]*metrics.Histogram
+	checks     map[string]hostCheck
+}
+
+// Event records the latencies and the result of dials
+func (m *ForwardMetrics) Event(event xtunnel.Event) {
+	if event.Type != xtunnel.EventDial {
+		return
+	}
+	dial, handshake := m.histograms(event.RemoteAddress)
+	m.mutex.Lock()
+	m.checks[event.RemoteAddress] = hostCheck{at: time.Now(), err: event.Err}
+	m.mutex.Unlock()
+
+	if event.Err != nil {
+		return
+	}
+	dial.Observe(event.Duration.Seconds())
+	if event.Handshake > 0 {
+		handshake.Observe(event.Handshake.Seconds())
+	}
+}
+
+func (m *ForwardMetrics) histograms(host string) (*metrics.Histogram, *metrics.Histogram) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if m.dials == nil {
+		m.dials = make(map[string]*metrics.Histogram)
+		m.handshakes = make(map[string]*metrics.Histogram)
+		m.checks = make(map[string]hostCheck)
+	}
+	if _, ok := m.dials[host]; !ok {
+		m.dials[host] = metrics.NewHistogram(metrics.DefaultBuckets)
+		m.handshakes[host] = metrics.NewHistogram(metrics.DefaultBuckets)
+	}
+	return m.dials[host], m.handshakes[host]
+}
+
+// hostsUp returns whether the hosts of the tunnels accept connections. Hosts
+// without a dial within MetricsProbeInterval are probed concurrently.
+func (m *ForwardMetrics) hostsUp(now time.Time) map[string]bool {
+	up := make(map[string]bool)
+	var wait sync.WaitGroup
+	var upMutex sync.Mutex
+	for _, tunnel := range m.Tunnels {
+		host := tunnel.RemoteAddress()
+		m.histograms(host)
+
+		m.mutex.Lock()
+		check, ok := m.checks[host]
+		m.mutex.Unlock()
+		if (ok && now.Sub(check.at) < MetricsProbeInterval) || m.Probe == nil {
+			up[host] = ok && check.err == nil
+			continue
+		}
+
+		wait.Add(1)
+		go func() {
+			defer wait.Done()
+			err := m.Probe(host)
+			m.mutex.Lock()
+			m.checks[host] = hostCheck{at: now, err: err}
+			m.mutex.Unlock()
+			upMutex.Lock()
+			up[host] = err == nil
+			upMutex.Unlock()
+		}()
+	}
+	wait.Wait()
+	return up
+}
+
+// WriteMetrics writes the metrics of the forward and its tunnels
+func (m *ForwardMetrics) WriteMetrics(w *metrics.Writer, now time.Time) {
+	forwardID := strconv.Itoa(m.ForwardID)
+	forwardLabels := metrics.Labels{"forward_id": forwardID}
+	w.Gauge("cf_forward_info", "Forward to a service instance.", metrics.Labels{"forward_id": forwardID, "service_instance": m.ServiceName}, 1)
+	w.Gauge("cf_forward_age_seconds", "Seconds since the forward was created.", forwardLabels, now.Sub(m.Created).Seconds())
+
+	up := m.hostsUp(now)
+	labels := make([]metrics.Labels, len(m.Tunnels))
+	stats := make([]xtunnel.Stats, len(m.Tunnels))
+	for i, tunnel := range m.Tunnels {
+		labels[i] = metrics.Labels{"forward_id": forwardID, "host": tunnel.RemoteAddress(), "local_address": tunnel.LocalAddress()}
+		stats[i] = tunnel.Stats()
+	}
+
+	// the samples of a metric are written together
+	for i := range m.Tunnels {
+		w.Gauge("cf_forward_host_up", "Whether the last dial or probe of the host succeeded.", labels[i], boolValue(up[m.Tunnels[i].RemoteAddress()]))
+	}
+	for i := range m.Tunnels {
+		w.Counter("cf_forward_connections_accepted_total", "Accepted client connections.", labels[i], float64(stats[i].Accepted))
+	}
+	for i := range m.Tunnels {
+		w.Gauge("cf_forward_connections_active", "Client connections with an open remote connection.", labels[i], float64(stats[i].Active))
+	}
+	for i := range m.Tunnels {
+		w.Counter("cf_forward_connections_failed_total", "Client connections closed because the host couldn't be dialed.", labels[i], float64(stats[i].Failed))
+	}
+	for i := range m.Tunnels {
+		w.Counter("cf_forward_bytes_total", "Bytes transferred by direction, sent from clients or received from the host.", labels[i].With("direction", "sent"), float64(stats[i].BytesSent))
+		w.Counter("cf_forward_bytes_total", "", labels[i].With("direction", "received"), float64(stats[i].BytesReceived))
+	}
+	for i, tunnel := range m.Tunnels {
+		dial, _ := m.histograms(tunnel.RemoteAddress())
+		w.Histogram("cf_forward_dial_duration_seconds", "Duration of successful dials of the host including the handshake.", labels[i], dial.Snapshot())
+	}
+	for i, tunnel := range m.Tunnels {
+		_, handshake := m.histograms(tunnel.RemoteAddress())
+		w.Histogram("cf_forward_handshake_duration_seconds", "Duration of successful TLS-PSK handshakes with the host.", labels[i], handshake.Snapshot())
+	}
+}
+
+func (m *ForwardMetrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/metrics" {
+		http.NotFound(w, r)
+		return
+	}
+	buffer := &bytes.Buffer{}
+	m.WriteMetrics(metrics.NewWriter(buffer), time.Now())
+	w.Header().Set("Content-Type", metrics.ContentType)
+	w.Write(buffer.Bytes())
+}
+
+// ListenMetrics opens the listen socket of the metrics before the forward is
+// created. Returns nil if address is blank.
+func ListenMetrics(address string) (net.Listener, error) {
+	if len(address) < 1 {
+		return nil, nil
+	}
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return nil, fmt.Errorf("[ERR] Failed to listen on --metrics-addr %s. %s", address, err)
+	}
+	return listener, nil
+}
+
+// serveMetrics serves the metrics of the tunnels on listener in the background
+// until the listener is closed
+func (c *CfServiceJumperPlugin) serveMetrics(listener net.Listener, forwardMetrics *ForwardMetrics, tunnels []*xtunnel.XTunnel, sharedSecret string) {
+	forwardMetrics.Probe, _ = PSKProbe(sharedSecret, MetricsProbeTimeout)
+	forwardMetrics.Tunnels = tunnelsOf(tunnels)
+
+	go http.Serve(listener, forwardMetrics)
+	fmt.Fprintf(c.messageWriter(), "Serving metrics on http://%s/metrics\n", listener.Addr())
+}
+
+func boolValue(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,77 @@
+package main_test
+
+import (
+	"bytes"
+	"errors"
+	"time"
+
+	. "github.com/anynines/cf_service_jumper_cli_plugin"
+	"github.com/anynines/cf_service_jumper_cli_plugin/plugin/metrics"
+	"github.com/anynines/cf_service_jumper_cli_plugin/xtunnel"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("ForwardMetrics", func() {
+	var (
+		tunnel         *fakeTunnel
+		forwardMetrics *ForwardMetrics
+		probed         []string
+		probeErr       error
+	)
+
+	BeforeEach(func() {
+		tunnel = &fakeTunnel{stats: xtunnel.Stats{Accepted: 3, Active: 1, Failed: 1, BytesSent: 10, BytesReceived: 20}}
+		probed, probeErr = nil, nil
+		forwardMetrics = &ForwardMetrics{
+			ForwardID:   42,
+			ServiceName: "db",
+			Created:     time.Now().Add(-time.Minute),
+			Tunnels:     []Tunnel{tunnel},
+			Probe: func(host string) error {
+				probed = append(probed, host)
+				return probeErr
+			},
+		}
+	})
+
+	write := func() string {
+		buffer := &bytes.Buffer{}
+		forwardMetrics.WriteMetrics(metrics.NewWriter(buffer), time.Now())
+		return buffer.String()
+	}
+
+	It("exposes the counters of the tunnels with forward id and host labels", func() {
+		output := write()
+		labels := `{forward_id="42",host="10.0.0.1:5432",local_address="127.0.0.1:5000"}`
+		Expect(output).To(ContainSubstring(`cf_forward_info{forward_id="42",service_instance="db"} 1`))
+		Expect(output).To(MatchRegexp(`cf_forward_age_seconds\{forward_id="42"\} 60\.\d+`))
+		Expect(output).To(ContainSubstring("cf_forward_connections_accepted_total" + labels + " 3"))
+		Expect(output).To(ContainSubstring("cf_forward_connections_active" + labels + " 1"))
+		Expect(output).To(ContainSubstring("cf_forward_connections_failed_total" + labels + " 1"))
+		Expect(output).To(ContainSubstring(`cf_forward_bytes_total{direction="sent",forward_id="42",host="10.0.0.1:5432",local_address="127.0.0.1:5000"} 10`))
+		Expect(output).To(ContainSubstring(`cf_forward_bytes_total{direction="received",forward_id="42",host="10.0.0.1:5432",local_address="127.0.0.1:5000"} 20`))
+		Expect(output).To(ContainSubstring("# TYPE cf_forward_dial_duration_seconds histogram"))
+		Expect(output).To(ContainSubstring("cf_forward_host_up" + labels + " 1"))
+	})
+
+	It("records dial and handshake latencies", func() {
+		forwardMetrics.Event(xtunnel.Event{Type: xtunnel.EventDial, RemoteAddress: "10.0.0.1:5432", Duration: 30 * time.Millisecond, Handshake: 20 * time.Millisecond})
+
+		output := write()
+		Expect(output).To(ContainSubstring(`cf_forward_dial_duration_seconds_bucket{forward_id="42",host="10.0.0.1:5432",le="0.05",local_address="127.0.0.1:5000"} 1`))
+		Expect(output).To(ContainSubstring(`cf_forward_dial_duration_seconds_bucket{forward_id="42",host="10.0.0.1:5432",le="0.025",local_address="127.0.0.1:5000"} 0`))
+		Expect(output).To(ContainSubstring(`cf_forward_handshake_duration_seconds_count{forward_id="42",host="10.0.0.1:5432",local_address="127.0.0.1:5000"} 1`))
+		// the recent dial makes the probe unnecessary
+		Expect(probed).To(BeEmpty())
+	})
+
+	It("reports hosts down by failed dials or probes", func() {
+		forwardMetrics.Event(xtunnel.Event{Type: xtunnel.EventDial, RemoteAddress: "10.0.0.1:5432", Err: errors.New("refused")})
+		Expect(write()).To(ContainSubstring(`cf_forward_host_up{forward_id="42",host="10.0.0.1:5432",local_address="127.0.0.1:5000"} 0`))
+
+		forwardMetrics = &ForwardMetrics{ForwardID: 42, Tunnels: []Tunnel{tunnel}, Probe: func(host string) error { return errors.New("timeout") }}
+		Expect(write()).To(ContainSubstring(`cf_forward_host_up{forward_id="42",host="10.0.0.1:5432",local_address="127.0.0.1:5000"} 0`))
+	})
+})
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"github.com/anynines/cf_service_jumper_cli_plugin/plugin/config"
+	"github.com/anynines/cf_service_jumper_cli_plugin/xtunnel"
+)
+
+// forwardSession is a created forward with listening tunnels, shared by
+// create-forward and forward-env
+type forwardSession struct {
+	ForwardInfo ForwardDataSet
+	Credentials map[string]string
+	ServiceType ServiceType
+	Tunnels     []*xtunnel.XTunnel
+	// HealthCheck holds the results of --health-check, nil if not checked
+	HealthCheck []HealthCheckResult
+	// ControlShutdown is closed by a shutdown of the control API, nil without --control
+	ControlShutdown <-chan struct{}
+	// Keep skips deleting the forward on Close, the user deletes it later
+	Keep bool
+
+	cleanups []func()
+}
+
+func (s *forwardSession) onClose(cleanup func()) {
+	s.cleanups = append(s.cleanups, cleanup)
+}
+
+// Close releases the session in reverse order and deletes the forward unless
+// it is kept
+func (s *forwardSession) Close() {
+	for i := len(s.cleanups) - 1; i >= 0; i-- {
+		s.cleanups[i]()
+	}
+	s.cleanups = nil
+}
+
+// startForwardSession creates a forward and opens its tunnels, the metrics
+// endpoint and the control API, runs the health check and signals readiness.
+// prepare is called after the forward is created and before the tunnels
+// listen; it returns additional tunnel event handlers. The forward is deleted
+// if anything fails once it exists.
+func (c *CfServiceJumperPlugin) startForwardSession(commandLine *CommandLine, serviceInstance ServiceInstance, prepare func(session *forwardSession) []func(event xtunnel.Event)) (session *forwardSession, err error) {
+	session = &forwardSession{}
+	defer func() {
+		if err != nil {
+			session.Close()
+			session = nil
+		}
+	}()
+
+	metricsListener, err := ListenMetrics(commandLine.String("--metrics-addr"))
+	if err != nil {
+		return
+	}
+	if metricsListener != nil {
+		session.onClose(func() { metricsListener.Close() })
+	}
+	controlListener, err := ListenControl(commandLine.String("--control"))
+	if err != nil {
+		return
+	}
+	if controlListener != nil {
+		session.onClose(func() { controlListener.Close() })
+	}
+
+	session.ForwardInfo, err = c.createForward(context.Background(), serviceInstance.GUID)
+	if err != nil {
+		return
+	}
+	forwardID := session.ForwardInfo.ID
+	session.onClose(func() {
+		if !session.Keep {
+			c.autoDeleteForward(serviceInstance.GUID, forwardID)
+		}
+	})
+	session.Credentials = session.ForwardInfo.CredentialsMap()
+	// the service type falls back to the credentials uri if the offering is unknown
+	session.ServiceType = DetectServiceType(serviceInstance.Offering, session.Credentials)
+
+	var eventHandlers []func(event xtunnel.Event)
+	forwardMetrics := &ForwardMetrics{ForwardID: forwardID, ServiceName: serviceInstance.Name, Created: time.Now()}
+	if metricsListener != nil {
+		eventHandlers = append(eventHandlers, forwardMetrics.Event)
+	}
+	if prepare != nil {
+		eventHandlers = append(eventHandlers, prepare(session)...)
+	}
+
+	session.Tunnels, err = ListenTunnels(c.messageWriter(), c.forwardConfig.Value(config.SettingBindAddress), session.ForwardInfo.Hosts, session.ForwardInfo.SharedSecret, c.tunnelEvents(eventHandlers...))
+	if err != nil {
+		return
+	}
+	session.onClose(func() { ShutdownTunnels(c.stderr(), session.Tunnels) })
+	ServeTunnels(c.stderr(), session.Tunnels)
+	if metricsListener != nil {
+		c.serveMetrics(metricsListener, forwardMetrics, session.Tunnels, session.ForwardInfo.SharedSecret)
+	}
+	if controlListener != nil {
+		session.ControlShutdown, err = c.serveControl(controlListener, commandLine, serviceInstance, session.ForwardInfo, session.Tunnels)
+		if err != nil {
+			return
+		}
+	}
+
+	session.HealthCheck = c.runHealthCheck(commandLine, session.ServiceType, session.ForwardInfo, session.Tunnels)
+	removeReadyFile, err := c.signalReady(commandLine, serviceInstance, session.ForwardInfo, session.Tunnels, session.HealthCheck)
+	if err != nil {
+		return
+	}
+	session.onClose(removeReadyFile)
+	return
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/anynines/cf_service_jumper_cli_plugin/plugin/healthcheck"
+	"github.com/anynines/cf_service_jumper_cli_plugin/xtunnel"
+	"github.com/olekukonko/tablewriter"
+)
+
+// HealthCheckTimeout limits the handshake with a host, see --health-check
+const HealthCheckTimeout = 10 * time.Second
+
+var healthCheckProtocols = map[ServiceType]healthcheck.Protocol{
+	ServiceTypePostgres:      healthcheck.ProtocolPostgres,
+	ServiceTypeMongodb:       healthcheck.ProtocolMongodb,
+	ServiceTypeRedis:         healthcheck.ProtocolRedis,
+	ServiceTypeRabbitMQ:      healthcheck.ProtocolAMQP,
+	ServiceTypeElasticsearch: healthcheck.ProtocolHTTP,
+	ServiceTypeMariaDB:       healthcheck.ProtocolMySQL,
+}
+
+// HealthCheckResult is the result of the handshake with a host
+type HealthCheckResult struct {
+	LocalAddress string
+	Host         string
+	// Check is the protocol of the handshake, tls-psk for unknown service types
+	Check    string
+	Duration time.Duration
+	Detail   string
+	Err      error
+}
+
+// HealthCheck verifies the hosts of a forward with a minimal handshake of the
+// service protocol through the tunnels, which proves that the shared secret
+// and the node work
+type HealthCheck struct {
+	ServiceType ServiceType
+	Credentials map[string]string
+	Timeout     time.Duration
+	// Probe checks hosts of unknown service types, e.g. with a TLS-PSK handshake
+	Probe func(host string) error
+}
+
+// Run checks all tunnels concurrently. Results are in the order of the tunnels.
+func (h HealthCheck) Run(tunnels []Tunnel) []HealthCheckResult {
+	results := make([]HealthCheckResult, len(tunnels))
+	done := make(chan struct{})
+	for i, tunnel := range tunnels {
+		go func(result *HealthCheckResult, tunnel Tunnel) {
+			defer func() { done <- struct{}{} }()
+			*result = h.check(tunnel)
+		}(&results[i], tunnel)
+	}
+	for range tunnels {
+		<-done
+	}
+	return results
+}
+
+func (h HealthCheck) check(tunnel Tunnel) HealthCheckResult {
+	result := HealthCheckResult{LocalAddress: tunnel.LocalAddress(), Host: tunnel.RemoteAddress()}
+	start := time.Now()
+	defer func() { result.Duration = time.Since(start) }()
+
+	protocol, ok := healthCheckProtocols[h.ServiceType]
+	if !ok {
+		result.Check = "tls-psk"
+		if h.Probe == nil {
+			result.Err = fmt.Errorf("no check of %s services", h.ServiceType)
+			return result
+		}
+		result.Err = h.Probe(result.Host)
+		if result.Err == nil {
+			result.Detail = "handshake succeeded, no protocol check for unknown services"
+		}
+		return result
+	}
+
+	result.Check = string(protocol)
+	result.Detail, result.Err = healthcheck.Run(protocol, dialAddress(result.LocalAddress), healthCheckCredentials(h.Credentials), h.Timeout)
+	return result
+}
+
+// dialAddress returns a loopback address for tunnels listening on all interfaces
+func dialAddress(localAddress string) string {
+	host, port, err := net.SplitHostPort(localAddress)
+	if err != nil {
+		return localAddress
+	}
+	if ip := net.ParseIP(host); ip != nil && ip.IsUnspecified() {
+		host = "127.0.0.1"
+		if ip.To4() == nil {
+			host = "::1"
+		}
+	}
+	return net.JoinHostPort(host, port)
+}
+
+// healthCheckCredentials takes username, password and database from the
+// credentials, falling back to the uri
+func healthCheckCredentials(credentials map[string]string) healthcheck.Credentials {
+	result := healthcheck.Credentials{
+		Username: firstCredential(credentials, "username", "user"),
+		Password: credentials["password"],
+		Database: firstCredential(credentials, "name", "database", "db"),
+	}
+
+	uri, err := url.Parse(credentials["uri"])
+	if err != nil {
+		return result
+	}
+	if uri.User != nil {
+		if len(result.Username) < 1 {
+			result.Username = uri.User.Username()
+		}
+		if password, ok := uri.User.Password(); ok && len(result.Password) < 1 {
+			result.Password = password
+		}
+	}
+	if len(result.Database) < 1 {
+		result.Database = strings.TrimPrefix(uri.Path, "/")
+	}
+	query := uri.Query()
+	result.TLS = stringInStrSlice(uri.Scheme, []string{"rediss", "amqps", "https"}) || query.Get("ssl") == "true" || query.Get("tls") == "true"
+	return result
+}
+
+func firstCredential(credentials map[string]string, keys ...string) string {
+	for _, key := range keys {
+		if value := credentials[key]; len(value) > 0 {
+			return value
+		}
+	}
+	return ""
+}
+
+// HealthyHosts returns the number of hosts passing the check
+func HealthyHosts(results []HealthCheckResult) int {
+	healthy := 0
+	for _, result := range results {
+		if result.Err == nil {
+			healthy++
+		}
+	}
+	return healthy
+}
+
+// OutputHealthCheck prints a table of the results per host
+func OutputHealthCheck(out io.Writer, results []HealthCheckResult) {
+	fmt.Fprintln(out, "\nHealth check:")
+	table := tablewriter.NewWriter(out)
+	table.SetHeader([]string{"Local", "Host", "Check", "Result", "Time", "Details"})
+	for _, result := range results {
+		status, detail := "OK", result.Detail
+		if result.Err != nil {
+			status, detail = "FAIL", result.Err.Error()
+		}
+		table.Append([]string{result.LocalAddress, result.Host, result.Check, status, result.Duration.Round(time.Millisecond).String(), detail})
+	}
+	table.Render()
+	fmt.Fprintf(out, "%d of %d host(s) healthy.\n", HealthyHosts(results), len(results))
+}
+
+// healthCheckJSON converts the results for json output
+func healthCheckJSON(results []HealthCheckResult) []map[string]interface{} {
+	hosts := make([]map[string]interface{}, len(results))
+	for i, result := range results {
+		hosts[i] = map[string]interface{}{
+			"local_address": result.LocalAddress,
+			"host":          result.Host,
+			"check":         result.Check,
+			"healthy":       result.Err == nil,
+			"duration_ms":   result.Duration.Nanoseconds() / int64(time.Millisecond),
+			"detail":        result.Detail,
+		}
+		if result.Err != nil {
+			hosts[i]["detail"] = result.Err.Error()
+		}
+	}
+	return hosts
+}
+
+// runHealthCheck checks the hosts of the forward with --health-check and logs
+// the results. Returns nil without --health-check.
+func (c *CfServiceJumperPlugin) runHealthCheck(commandLine *CommandLine, serviceType ServiceType, forwardInfo ForwardDataSet, tunnels []*xtunnel.XTunnel) []HealthCheckResult {
+	if !commandLine.Bool("--health-check") {
+		return nil
+	}
+	healthCheck := HealthCheck{ServiceType: serviceType, Credentials: forwardInfo.CredentialsMap(), Timeout: HealthCheckTimeout}
+	healthCheck.Probe, _ = PSKProbe(forwardInfo.SharedSecret, HealthCheckTimeout)
+
+	results := healthCheck.Run(tunnelsOf(tunnels))
+	for _, result := range results {
+		if result.Err != nil {
+			c.logger.Warnf("health check of %s via %s failed. %s", result.Host, result.LocalAddress, result.Err)
+		} else {
+			c.logger.Infof("health check of %s via %s: %s", result.Host, result.LocalAddress, result.Detail)
+		}
+	}
+	return results
+}
@@ -0,0 +1,105 @@
+package main_test
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"time"
+
+	. "github.com/anynines/cf_service_jumper_cli_plugin"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// addressTunnel is a tunnel with a dialable local address
+type addressTunnel struct {
+	fakeTunnel
+	local, remote string
+}
+
+func (a *addressTunnel) LocalAddress() string  { return a.local }
+func (a *addressTunnel) RemoteAddress() string { return a.remote }
+
+// redisServer answers AUTH and PING of one connection and records the commands
+func redisServer(commands chan<- string) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	Expect(err).To(BeNil())
+	go func() {
+		defer listener.Close()
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		reader := bufio.NewReader(conn)
+		for {
+			header, err := reader.ReadString('\n')
+			if err != nil {
+				return
+			}
+			var args []string
+			for i := 0; i < 2*int(header[1]-'0'); i++ {
+				line, _ := reader.ReadString('\n')
+				if i%2 == 1 {
+					args = append(args, strings.TrimSpace(line))
+				}
+			}
+			commands <- strings.Join(args, " ")
+			if args[0] == "AUTH" {
+				io.WriteString(conn, "+OK\r\n")
+			} else {
+				io.WriteString(conn, "+PONG\r\n")
+			}
+		}
+	}()
+	return listener.Addr().String()
+}
+
+var _ = Describe("HealthCheck", func() {
+	It("performs the protocol handshake through the tunnels with credentials of the uri", func() {
+		commands := make(chan string, 2)
+		healthCheck := HealthCheck{
+			ServiceType: ServiceTypeRedis,
+			Credentials: map[string]string{"uri": "redis://:the_password@10.0.0.1:6379"},
+			Timeout:     time.Second,
+		}
+
+		results := healthCheck.Run([]Tunnel{&addressTunnel{local: redisServer(commands), remote: "10.0.0.1:6379"}})
+		Expect(results).To(HaveLen(1))
+		Expect(results[0].Err).To(BeNil())
+		Expect(results[0].Check).To(Equal("redis"))
+		Expect(results[0].Host).To(Equal("10.0.0.1:6379"))
+		Expect(<-commands).To(Equal("AUTH the_password"))
+		Expect(<-commands).To(Equal("PING"))
+	})
+
+	It("probes hosts of unknown services", func() {
+		healthCheck := HealthCheck{
+			Timeout: time.Second,
+			Probe: func(host string) error {
+				if host == "10.0.0.2:1234" {
+					return errors.New("connection refused")
+				}
+				return nil
+			},
+		}
+
+		results := healthCheck.Run([]Tunnel{
+			&addressTunnel{local: "127.0.0.1:5000", remote: "10.0.0.1:1234"},
+			&addressTunnel{local: "127.0.0.1:5001", remote: "10.0.0.2:1234"},
+		})
+		Expect(results[0].Check).To(Equal("tls-psk"))
+		Expect(results[0].Err).To(BeNil())
+		Expect(results[1].Err).To(MatchError("connection refused"))
+		Expect(HealthyHosts(results)).To(Equal(1))
+
+		out := &bytes.Buffer{}
+		OutputHealthCheck(out, results)
+		Expect(out.String()).To(MatchRegexp(`10\.0\.0\.2:1234\s*\|\s*tls-psk\s*\|\s*FAIL`))
+		Expect(out.String()).To(ContainSubstring("1 of 2 host(s) healthy."))
+	})
+})
@@ -2,14 +2,27 @@ package main
 
 import (
 	"crypto/tls"
+	"io"
+	"net/http"
+	"time"
 
-	"github.com/parnurzeal/gorequest"
+	"github.com/anynines/cf_service_jumper_cli_plugin/plugin/logging"
+	"github.com/anynines/cf_service_jumper_cli_plugin/plugin/trace"
 )
 
-func NewHttpClient(isSSLDisabled bool) *gorequest.SuperAgent {
-	request := gorequest.New()
-	if isSSLDisabled {
-		request = request.TLSClientConfig(&tls.Config{InsecureSkipVerify: true})
+// NewHttpTransport returns a transport honouring the proxy environment variables.
+// Requests are traced to traceWriter and logged to logger unless they are nil.
+func NewHttpTransport(tlsConfig *tls.Config, traceWriter io.Writer, logger *logging.Logger) http.RoundTripper {
+	transport := &http.Transport{
+		Proxy:           http.ProxyFromEnvironment,
+		TLSClientConfig: tlsConfig,
+	}
+	return logging.NewTransport(trace.NewTransport(transport, traceWriter), logger)
+}
+
+func NewHttpClient(tlsConfig *tls.Config, timeout time.Duration, traceWriter io.Writer, logger *logging.Logger) *http.Client {
+	return &http.Client{
+		Transport: NewHttpTransport(tlsConfig, traceWriter, logger),
+		Timeout:   timeout,
 	}
-	return request
 }
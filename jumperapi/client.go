@@ -0,0 +1,309 @@
+package jumperapi
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptrace"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// DefaultTimeout is used if Config.Timeout is blank
+const DefaultTimeout = 30 * time.Second
+
+// Client is a client of the service jumper api
+type Client interface {
+	CreateForward(ctx context.Context, serviceGUID string) (Forward, error)
+	GetForward(ctx context.Context, serviceGUID string, forwardID int) (Forward, error)
+	ListForwards(ctx context.Context, serviceGUID string) ([]Forward, error)
+	DeleteForward(ctx context.Context, serviceGUID string, forwardID int) error
+}
+
+// Config configures the client
+type Config struct {
+	// Endpoint of the service jumper api, e.g. https://a9s-service-jumper.example.com
+	Endpoint string
+	// AccessToken of the cf cli including the token type, e.g. "bearer ...".
+	// Ignored if TokenSource is set.
+	AccessToken string
+	// TokenSource provides refreshed access tokens for long sessions
+	TokenSource TokenSource
+	// Timeout of a single request
+	Timeout time.Duration
+
+	// RetryPolicy for transient failures, DefaultRetryPolicy if blank
+	RetryPolicy RetryPolicy
+	// OnRetry is called before a failed request is retried, e.g. for verbose output
+	OnRetry func(event RetryEvent)
+	// TLSConfig of https connections, e.g. with custom root CAs or pinning
+	TLSConfig *tls.Config
+	// Transport of the http client, e.g. for tracing. TLSConfig is ignored if set.
+	Transport http.RoundTripper
+}
+
+type client struct {
+	config     Config
+	httpClient *http.Client
+}
+
+// NewClient creates a new service jumper api client
+func NewClient(config Config) Client {
+	if config.Timeout <= 0 {
+		config.Timeout = DefaultTimeout
+	}
+	config.Endpoint = strings.TrimSuffix(config.Endpoint, "/")
+	if config.TokenSource == nil {
+		config.TokenSource = StaticTokenSource(config.AccessToken)
+	}
+	if config.RetryPolicy == (RetryPolicy{}) {
+		config.RetryPolicy = DefaultRetryPolicy
+	}
+
+	transport := config.Transport
+	if transport == nil {
+		transport = &http.Transport{
+			Proxy:           http.ProxyFromEnvironment,
+			TLSClientConfig: config.TLSConfig,
+		}
+	}
+
+	return &client{
+		config: config,
+		httpClient: &http.Client{
+			Transport: transport,
+			Timeout:   config.Timeout,
+		},
+	}
+}
+
+// CreateForward creates/recycles a forward to the service instance. A POST
+// which never reached the service jumper is retried. If it was sent but the
+// response got lost, the forwards are compared with the ones known before the
+// POST: a single new forward is adopted, without one the POST is retried. An
+// UnconfirmedForwardError is returned if that can't be decided.
+func (c *client) CreateForward(ctx context.Context, serviceGUID string) (Forward, error) {
+	path := fmt.Sprintf("/services/%s/forwards", serviceGUID)
+
+	var knownForwardIDs map[int]bool
+	if c.config.RetryPolicy.MaxRetries > 0 {
+		knownForwardIDs, _ = c.forwardIDs(ctx, serviceGUID)
+	}
+
+	var forward Forward
+	var lostErr *RequestError
+	err := c.retry(ctx, "POST", path, func(attempt int) error {
+		if lostErr != nil {
+			created, err := c.createdForward(ctx, serviceGUID, knownForwardIDs, lostErr)
+			if err != nil {
+				return err
+			}
+			if created != nil {
+				forward = *created
+				return nil
+			}
+		}
+
+		err := c.do(ctx, "POST", path, &forward)
+		if requestError, ok := err.(*RequestError); ok && !isNotSentError(requestError) {
+			lostErr = requestError
+			if knownForwardIDs == nil {
+				return &UnconfirmedForwardError{Err: requestError}
+			}
+		}
+		return err
+	})
+	if requestError, ok := err.(*RequestError); ok && requestError == lostErr {
+		created, checkErr := c.createdForward(ctx, serviceGUID, knownForwardIDs, lostErr)
+		if checkErr != nil {
+			return forward, checkErr
+		}
+		if created != nil {
+			return *created, nil
+		}
+	}
+	return forward, err
+}
+
+// createdForward looks for a forward created by a POST whose response got
+// lost. It returns nil if no new forward exists and an UnconfirmedForwardError
+// if the forwards can't be listed or several new forwards exist.
+func (c *client) createdForward(ctx context.Context, serviceGUID string, knownForwardIDs map[int]bool, lostErr *RequestError) (*Forward, error) {
+	forwardIDs, err := c.forwardIDs(ctx, serviceGUID)
+	if err != nil {
+		return nil, &UnconfirmedForwardError{Err: lostErr}
+	}
+
+	var newForwardIDs []int
+	for forwardID := range forwardIDs {
+		if !knownForwardIDs[forwardID] {
+			newForwardIDs = append(newForwardIDs, forwardID)
+		}
+	}
+	switch len(newForwardIDs) {
+	case 0:
+		return nil, nil
+	case 1:
+		forward, err := c.GetForward(ctx, serviceGUID, newForwardIDs[0])
+		if err != nil {
+			return nil, &UnconfirmedForwardError{Err: lostErr}
+		}
+		return &forward, nil
+	}
+	return nil, &UnconfirmedForwardError{Err: lostErr}
+}
+
+// forwardIDs returns the ids of the open forwards
+func (c *client) forwardIDs(ctx context.Context, serviceGUID string) (map[int]bool, error) {
+	var forwards []Forward
+	err := c.do(ctx, "GET", fmt.Sprintf("/services/%s/forwards/", serviceGUID), &forwards)
+	if err != nil {
+		return nil, err
+	}
+
+	forwardIDs := make(map[int]bool)
+	for _, forward := range forwards {
+		forwardIDs[forward.ID] = true
+	}
+	return forwardIDs, nil
+}
+
+// GetForward fetches a single forward of the service instance
+func (c *client) GetForward(ctx context.Context, serviceGUID string, forwardID int) (Forward, error) {
+	path := fmt.Sprintf("/services/%s/forwards/%d", serviceGUID, forwardID)
+
+	var forward Forward
+	err := c.retry(ctx, "GET", path, func(attempt int) error {
+		return c.do(ctx, "GET", path, &forward)
+	})
+	return forward, err
+}
+
+// ListForwards lists all open forwards of the service instance
+func (c *client) ListForwards(ctx context.Context, serviceGUID string) ([]Forward, error) {
+	path := fmt.Sprintf("/services/%s/forwards/", serviceGUID)
+
+	var forwards []Forward
+	err := c.retry(ctx, "GET", path, func(attempt int) error {
+		return c.do(ctx, "GET", path, &forwards)
+	})
+	return forwards, err
+}
+
+// DeleteForward deletes the forward of the service instance. A 404 of a retry
+// is treated as success since the previous attempt might have deleted it.
+func (c *client) DeleteForward(ctx context.Context, serviceGUID string, forwardID int) error {
+	path := fmt.Sprintf("/services/%s/forwards/%d", serviceGUID, forwardID)
+
+	return c.retry(ctx, "DELETE", path, func(attempt int) error {
+		err := c.do(ctx, "DELETE", path, nil)
+		if attempt > 1 && ErrorKindOf(err) == ErrorKindNotFound {
+			return nil
+		}
+		return err
+	})
+}
+
+// retry calls request until it succeeds, fails permanently or the retries
+// of the retry policy are used up.
+func (c *client) retry(ctx context.Context, method string, path string, request func(attempt int) error) error {
+	maxAttempts := c.config.RetryPolicy.MaxRetries + 1
+	if maxAttempts < 1 {
+		maxAttempts = 1
+	}
+
+	var err error
+	for attempt := 1; ; attempt++ {
+		err = request(attempt)
+		if err == nil || attempt >= maxAttempts || !isRetryable(method, err) || ctx.Err() != nil {
+			return err
+		}
+
+		delay := c.config.RetryPolicy.Delay(attempt)
+		if c.config.OnRetry != nil {
+			c.config.OnRetry(RetryEvent{
+				Method:      method,
+				Path:        path,
+				Attempt:     attempt + 1,
+				MaxAttempts: maxAttempts,
+				Delay:       delay,
+				Err:         err,
+			})
+		}
+		if sleep(ctx, delay) != nil {
+			return err
+		}
+	}
+}
+
+// do sends the request and decodes the json response into result unless it is nil.
+// Requests failing with 401 are retried once with a refreshed access token.
+func (c *client) do(ctx context.Context, method string, path string, result interface{}) error {
+	token, err := c.config.TokenSource.Token()
+	if err != nil {
+		return err
+	}
+
+	err = c.doWithToken(ctx, method, path, token, result)
+	if ErrorKindOf(err) != ErrorKindUnauthorized {
+		return err
+	}
+
+	refreshedToken, refreshErr := c.config.TokenSource.Refresh()
+	if refreshErr != nil || refreshedToken == token {
+		return err
+	}
+	return c.doWithToken(ctx, method, path, refreshedToken, result)
+}
+
+func (c *client) doWithToken(ctx context.Context, method string, path string, token string, result interface{}) error {
+	url := c.config.Endpoint + path
+
+	req, err := http.NewRequest(method, url, nil)
+	if err != nil {
+		return &RequestError{Method: method, URL: url, Err: err}
+	}
+	// a failed tls handshake before a connection was obtained means nothing
+	// was sent, e.g. on untrusted or unpinned certificates
+	var gotConn, handshakeFailed int32
+	req = req.WithContext(httptrace.WithClientTrace(ctx, &httptrace.ClientTrace{
+		GotConn: func(httptrace.GotConnInfo) {
+			atomic.StoreInt32(&gotConn, 1)
+		},
+		TLSHandshakeDone: func(state tls.ConnectionState, err error) {
+			if err != nil {
+				atomic.StoreInt32(&handshakeFailed, 1)
+			}
+		},
+	}))
+	req.Header.Set("Authorization", token)
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		notSent := atomic.LoadInt32(&gotConn) == 0 && atomic.LoadInt32(&handshakeFailed) == 1
+		return &RequestError{Method: method, URL: url, Err: err, notSent: notSent}
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return &RequestError{Method: method, URL: url, Err: err}
+	}
+	if resp.StatusCode != http.StatusOK {
+		return NewAPIError(resp.StatusCode, string(body))
+	}
+
+	if result == nil {
+		return nil
+	}
+	err = json.Unmarshal(body, result)
+	if err != nil {
+		return &DecodeError{Err: err}
+	}
+	return nil
+}
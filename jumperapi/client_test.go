@@ -0,0 +1,174 @@
+package jumperapi_test
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	. "github.com/anynines/cf_service_jumper_cli_plugin/jumperapi"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Client", func() {
+	var fakeServer *httptest.Server
+	var handler http.HandlerFunc
+	var client Client
+
+	BeforeEach(func() {
+		fakeServer = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			handler(w, r)
+		}))
+		client = NewClient(Config{
+			Endpoint:    fakeServer.URL,
+			AccessToken: "bearer the_token",
+		})
+	})
+
+	AfterEach(func() {
+		fakeServer.Close()
+	})
+
+	Describe("CreateForward", func() {
+		It("returns Forward", func() {
+			handler = func(w http.ResponseWriter, r *http.Request) {
+				if r.Method == "GET" {
+					// list of known forwards for duplicate detection
+					fmt.Fprint(w, `[]`)
+					return
+				}
+				Expect(r.Method).To(Equal("POST"))
+				Expect(r.URL.Path).To(Equal("/services/serviceGuid/forwards"))
+				Expect(r.Header.Get("Authorization")).To(Equal("bearer the_token"))
+
+				jsonStr := `{ "public_uris": ["10.100.0.60:27017", "10.100.0.61:27017"], "credentials": { "credentials": { "username": "the_username" } }, "shared_secret": "luser:01234567890123456789", "id": 1234 }`
+				fmt.Fprintln(w, jsonStr)
+			}
+
+			forward, err := client.CreateForward(context.Background(), "serviceGuid")
+			Expect(err).To(BeNil())
+
+			expectedForward := Forward{
+				ID:           1234,
+				Hosts:        []string{"10.100.0.60:27017", "10.100.0.61:27017"},
+				SharedSecret: "luser:01234567890123456789",
+				Credentials: ForwardCredentials{
+					Credentials: map[string]interface{}{
+						"username": "the_username",
+					},
+				},
+			}
+			Expect(forward).To(Equal(expectedForward))
+		})
+
+		It("returns APIError on unexpected status code", func() {
+			handler = func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusBadGateway)
+				fmt.Fprint(w, "bad gateway")
+			}
+
+			_, err := client.CreateForward(context.Background(), "serviceGuid")
+			Expect(err).To(BeAssignableToTypeOf(&APIError{}))
+			Expect(err.(*APIError).StatusCode).To(Equal(http.StatusBadGateway))
+			Expect(err.(*APIError).Body).To(Equal("bad gateway"))
+		})
+
+		It("returns DecodeError on broken json", func() {
+			handler = func(w http.ResponseWriter, r *http.Request) {
+				fmt.Fprint(w, `"id": 1`)
+			}
+
+			_, err := client.CreateForward(context.Background(), "serviceGuid")
+			Expect(err).To(BeAssignableToTypeOf(&DecodeError{}))
+		})
+	})
+
+	Describe("ListForwards", func() {
+		It("returns Forwards", func() {
+			handler = func(w http.ResponseWriter, r *http.Request) {
+				Expect(r.Method).To(Equal("GET"))
+				Expect(r.URL.Path).To(Equal("/services/serviceGuid/forwards/"))
+				fmt.Fprint(w, `[{ "id": 1 }, { "id": 2 }]`)
+			}
+
+			forwards, err := client.ListForwards(context.Background(), "serviceGuid")
+			Expect(err).To(BeNil())
+			Expect(forwards).To(Equal([]Forward{Forward{ID: 1}, Forward{ID: 2}}))
+		})
+	})
+
+	Describe("GetForward", func() {
+		It("returns Forward", func() {
+			handler = func(w http.ResponseWriter, r *http.Request) {
+				Expect(r.Method).To(Equal("GET"))
+				Expect(r.URL.Path).To(Equal("/services/serviceGuid/forwards/42"))
+				fmt.Fprint(w, `{ "id": 42 }`)
+			}
+
+			forward, err := client.GetForward(context.Background(), "serviceGuid", 42)
+			Expect(err).To(BeNil())
+			Expect(forward.ID).To(Equal(42))
+		})
+	})
+
+	Describe("DeleteForward", func() {
+		It("deletes forward", func() {
+			requested := false
+			handler = func(w http.ResponseWriter, r *http.Request) {
+				Expect(r.Method).To(Equal("DELETE"))
+				Expect(r.URL.Path).To(Equal("/services/serviceGuid/forwards/42"))
+				requested = true
+			}
+
+			Expect(client.DeleteForward(context.Background(), "serviceGuid", 42)).To(Succeed())
+			Expect(requested).To(BeTrue())
+		})
+	})
+
+	It("returns RequestError on timeout", func() {
+		handler = func(w http.ResponseWriter, r *http.Request) {
+			time.Sleep(200 * time.Millisecond)
+		}
+		client = NewClient(Config{Endpoint: fakeServer.URL, Timeout: 50 * time.Millisecond, RetryPolicy: NoRetries})
+
+		err := client.DeleteForward(context.Background(), "serviceGuid", 42)
+		Expect(err).To(BeAssignableToTypeOf(&RequestError{}))
+	})
+
+	It("returns RequestError if context is canceled", func() {
+		handler = func(w http.ResponseWriter, r *http.Request) {}
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		_, err := client.ListForwards(ctx, "serviceGuid")
+		Expect(err).To(BeAssignableToTypeOf(&RequestError{}))
+	})
+})
+
+var _ = Describe("APIError", func() {
+	It("parses json error bodies", func() {
+		apiError := NewAPIError(403, `{"error": "Forbidden", "description": "not a space developer"}`)
+		Expect(apiError.Kind).To(Equal(ErrorKindForbidden))
+		Expect(apiError.Message).To(Equal("Forbidden: not a space developer"))
+		Expect(apiError.Error()).To(Equal("[ERR] cf service jumper request failed. 403 Forbidden. Forbidden: not a space developer"))
+		Expect(apiError.Hint()).ToNot(BeEmpty())
+	})
+
+	It("falls back to the raw body", func() {
+		apiError := NewAPIError(502, "<html>bad gateway</html>")
+		Expect(apiError.Kind).To(Equal(ErrorKindServer))
+		Expect(apiError.Message).To(BeEmpty())
+		Expect(apiError.Error()).To(ContainSubstring("<html>bad gateway</html>"))
+	})
+
+	It("maps status codes to kinds", func() {
+		Expect(ErrorKindForStatusCode(401)).To(Equal(ErrorKindUnauthorized))
+		Expect(ErrorKindForStatusCode(404)).To(Equal(ErrorKindNotFound))
+		Expect(ErrorKindForStatusCode(409)).To(Equal(ErrorKindConflict))
+		Expect(ErrorKindForStatusCode(422)).To(Equal(ErrorKindUnprocessable))
+		Expect(ErrorKindForStatusCode(503)).To(Equal(ErrorKindServer))
+		Expect(ErrorKindForStatusCode(418)).To(Equal(ErrorKindUnknown))
+	})
+})
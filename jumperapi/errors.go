@@ -0,0 +1,153 @@
+package jumperapi
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// ErrorKind classifies service jumper api errors
+type ErrorKind int
+
+const (
+	ErrorKindUnknown ErrorKind = iota
+	// ErrorKindUnauthorized the access token is invalid or expired (401)
+	ErrorKindUnauthorized
+	// ErrorKindForbidden the user lacks permissions on the service instance (403)
+	ErrorKindForbidden
+	// ErrorKindNotFound the service instance or forward doesn't exist (404)
+	ErrorKindNotFound
+	// ErrorKindConflict e.g. the forward quota is exhausted (409)
+	ErrorKindConflict
+	// ErrorKindUnprocessable e.g. the service isn't supported by the service jumper (422)
+	ErrorKindUnprocessable
+	// ErrorKindServer the service jumper failed (5xx)
+	ErrorKindServer
+)
+
+var errorKindHints = map[ErrorKind]string{
+	ErrorKindUnauthorized:  "Your access token is invalid or expired. Run 'cf login' and try again.",
+	ErrorKindForbidden:     "You are not allowed to forward to this service instance. The SpaceDeveloper role in the space of the service instance is required.",
+	ErrorKindNotFound:      "The service instance or forward doesn't exist. Check the name with 'cf services' and the forward id with 'cf list-forwards'.",
+	ErrorKindConflict:      "The maximum number of forwards is reached. Delete unused forwards with 'cf list-forwards' and 'cf delete-forward'.",
+	ErrorKindUnprocessable: "The service instance can't be forwarded. Only anynines (a9s) data services are supported by the service jumper.",
+	ErrorKindServer:        "The service jumper failed. Try again later or contact your platform operator.",
+}
+
+// ErrorKindForStatusCode maps http status codes to error kinds
+func ErrorKindForStatusCode(statusCode int) ErrorKind {
+	switch {
+	case statusCode == http.StatusUnauthorized:
+		return ErrorKindUnauthorized
+	case statusCode == http.StatusForbidden:
+		return ErrorKindForbidden
+	case statusCode == http.StatusNotFound:
+		return ErrorKindNotFound
+	case statusCode == http.StatusConflict:
+		return ErrorKindConflict
+	case statusCode == http.StatusUnprocessableEntity:
+		return ErrorKindUnprocessable
+	case statusCode >= 500:
+		return ErrorKindServer
+	}
+	return ErrorKindUnknown
+}
+
+// RequestError is returned if a request couldn't be sent or the response
+// couldn't be read, e.g. on network errors or timeouts.
+type RequestError struct {
+	Method string
+	URL    string
+	Err    error
+
+	// notSent is set if the request failed before it was sent
+	notSent bool
+}
+
+func (e *RequestError) Error() string {
+	return fmt.Sprintf("[ERR] cf service jumper request %s %s failed. %s", e.Method, e.URL, e.Err)
+}
+
+// Hint returns a human readable hint how to solve the error
+func (e *RequestError) Hint() string {
+	return "The service jumper isn't reachable. Check your network connection and the endpoint shown by 'cf forward-api'."
+}
+
+// UnconfirmedForwardError is returned if the request creating a forward was
+// sent but its response got lost and the forwards couldn't tell whether it
+// was created.
+type UnconfirmedForwardError struct {
+	Err *RequestError
+}
+
+func (e *UnconfirmedForwardError) Error() string {
+	return fmt.Sprintf("%s. The forward might have been created anyway", e.Err)
+}
+
+// Hint returns a human readable hint how to solve the error
+func (e *UnconfirmedForwardError) Hint() string {
+	return "Check for the forward with 'cf list-forwards' and delete it with 'cf delete-forward' if it exists."
+}
+
+// APIError is returned for responses with an unexpected status code.
+type APIError struct {
+	StatusCode int
+	Kind       ErrorKind
+	// Message parsed from the json error body, blank if the body isn't json
+	Message string
+	Body    string
+}
+
+// NewAPIError creates an APIError and parses json error bodies like
+// {"error": "...", "description": "..."}
+func NewAPIError(statusCode int, body string) *APIError {
+	apiError := &APIError{
+		StatusCode: statusCode,
+		Kind:       ErrorKindForStatusCode(statusCode),
+		Body:       body,
+	}
+
+	var errorBody map[string]interface{}
+	if json.Unmarshal([]byte(body), &errorBody) == nil {
+		var messages []string
+		for _, key := range []string{"error", "message", "description"} {
+			if message, ok := errorBody[key].(string); ok && len(message) > 0 {
+				messages = append(messages, message)
+			}
+		}
+		apiError.Message = strings.Join(messages, ": ")
+	}
+
+	return apiError
+}
+
+func (e *APIError) Error() string {
+	message := e.Message
+	if len(message) < 1 {
+		message = e.Body
+	}
+	return fmt.Sprintf("[ERR] cf service jumper request failed. %d %s. %s", e.StatusCode, http.StatusText(e.StatusCode), strings.TrimSpace(message))
+}
+
+// Hint returns a human readable hint how to solve the error
+func (e *APIError) Hint() string {
+	return errorKindHints[e.Kind]
+}
+
+// DecodeError is returned if the response body isn't valid json.
+type DecodeError struct {
+	Err error
+}
+
+func (e *DecodeError) Error() string {
+	return fmt.Sprintf("[ERR] cf service jumper request failed. unmarshal error: %s", e.Err)
+}
+
+// ErrorKindOf returns the kind of api errors and ErrorKindUnknown for all other errors
+func ErrorKindOf(err error) ErrorKind {
+	if apiError, ok := err.(*APIError); ok {
+		return apiError.Kind
+	}
+	return ErrorKindUnknown
+}
@@ -0,0 +1,50 @@
+package jumperapi
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+)
+
+// healthCheckPath is a forwards resource requested without access token. The
+// service jumper rejects it with 401 or 403, other apps don't know the path.
+const healthCheckPath = "/services/00000000-0000-0000-0000-000000000000/forwards/"
+
+// ErrNotServiceJumper is returned by CheckHealth if the endpoint answers but
+// isn't a service jumper, e.g. an unknown route of the cf router or another app.
+var ErrNotServiceJumper = errors.New("[ERR] endpoint is not a service jumper. It doesn't protect the forwards resource")
+
+// CheckHealth checks that a service jumper answers at endpoint. The api has no
+// dedicated health resource, so the forwards of a service instance are listed
+// without access token, which only a service jumper rejects as unauthorized.
+func CheckHealth(ctx context.Context, httpClient *http.Client, endpoint string) error {
+	url := strings.TrimSuffix(endpoint, "/") + healthCheckPath
+
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return &RequestError{Method: "GET", URL: url, Err: err}
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return &RequestError{Method: "GET", URL: url, Err: err}
+	}
+	defer resp.Body.Close()
+	body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 4096))
+
+	if len(resp.Header.Get("X-Cf-Routererror")) > 0 {
+		return ErrNotServiceJumper
+	}
+	if resp.StatusCode >= 500 {
+		return NewAPIError(resp.StatusCode, string(body))
+	}
+	if resp.StatusCode != http.StatusUnauthorized && resp.StatusCode != http.StatusForbidden {
+		return ErrNotServiceJumper
+	}
+	return nil
+}
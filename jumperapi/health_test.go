@@ -0,0 +1,72 @@
+package jumperapi_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+
+	. "github.com/anynines/cf_service_jumper_cli_plugin/jumperapi"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("CheckHealth", func() {
+	var fakeServer *httptest.Server
+	var handler http.HandlerFunc
+
+	BeforeEach(func() {
+		fakeServer = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			handler(w, r)
+		}))
+	})
+
+	AfterEach(func() {
+		fakeServer.Close()
+	})
+
+	It("accepts answers of the service jumper", func() {
+		handler = func(w http.ResponseWriter, r *http.Request) {
+			Expect(r.URL.Path).To(HavePrefix("/services/"))
+			Expect(r.URL.Path).To(HaveSuffix("/forwards/"))
+			Expect(r.Header.Get("Authorization")).To(BeEmpty())
+			w.WriteHeader(http.StatusUnauthorized)
+		}
+		Expect(CheckHealth(context.Background(), http.DefaultClient, fakeServer.URL+"/")).To(Succeed())
+	})
+
+	It("rejects apps which aren't a service jumper", func() {
+		handler = func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path != "/" {
+				w.WriteHeader(http.StatusNotFound)
+			}
+		}
+		Expect(CheckHealth(context.Background(), http.DefaultClient, fakeServer.URL)).To(Equal(ErrNotServiceJumper))
+
+		handler = func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte("<html>welcome</html>"))
+		}
+		Expect(CheckHealth(context.Background(), http.DefaultClient, fakeServer.URL)).To(Equal(ErrNotServiceJumper))
+	})
+
+	It("rejects unknown cf routes", func() {
+		handler = func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("X-Cf-Routererror", "unknown_route")
+			w.WriteHeader(http.StatusNotFound)
+		}
+		Expect(CheckHealth(context.Background(), http.DefaultClient, fakeServer.URL)).To(Equal(ErrNotServiceJumper))
+	})
+
+	It("rejects server errors", func() {
+		handler = func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusBadGateway)
+		}
+		err := CheckHealth(context.Background(), http.DefaultClient, fakeServer.URL)
+		Expect(ErrorKindOf(err)).To(Equal(ErrorKindServer))
+	})
+
+	It("rejects unreachable endpoints", func() {
+		fakeServer.Close()
+		err := CheckHealth(context.Background(), http.DefaultClient, fakeServer.URL)
+		Expect(err).To(BeAssignableToTypeOf(&RequestError{}))
+	})
+})
@@ -0,0 +1,12 @@
+package jumperapi_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"testing"
+)
+
+func TestJumperapiSuite(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Jumperapi Suite")
+}
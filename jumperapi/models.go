@@ -0,0 +1,14 @@
+package jumperapi
+
+// Forward is a forward to a service instance as returned by the service jumper api
+type Forward struct {
+	ID           int                `json:"id"`
+	Hosts        []string           `json:"public_uris"`
+	SharedSecret string             `json:"shared_secret"`
+	Credentials  ForwardCredentials `json:"credentials"`
+}
+
+// ForwardCredentials wraps the service binding credentials of a forward
+type ForwardCredentials struct {
+	Credentials map[string]interface{} `json:"credentials"`
+}
@@ -0,0 +1,99 @@
+package jumperapi
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"net"
+	"net/http"
+	"time"
+)
+
+// RetryPolicy configures retries of transient failures
+type RetryPolicy struct {
+	// MaxRetries after the first attempt, 0 disables retries
+	MaxRetries int
+	// BaseDelay is doubled on every retry up to MaxDelay
+	BaseDelay time.Duration
+	MaxDelay  time.Duration
+}
+
+// DefaultRetryPolicy is used if Config.RetryPolicy is blank
+var DefaultRetryPolicy = RetryPolicy{
+	MaxRetries: 3,
+	BaseDelay:  500 * time.Millisecond,
+	MaxDelay:   5 * time.Second,
+}
+
+// NoRetries disables retries
+var NoRetries = RetryPolicy{MaxRetries: -1}
+
+// RetryEvent describes a retry and is passed to Config.OnRetry
+type RetryEvent struct {
+	Method string
+	Path   string
+	// Attempt is the number of the upcoming attempt starting with 2
+	Attempt     int
+	MaxAttempts int
+	Delay       time.Duration
+	Err         error
+}
+
+// Delay returns the jittered exponential backoff before retry number retry
+// (starting with 1). The delay is between half and the full backoff.
+func (p RetryPolicy) Delay(retry int) time.Duration {
+	backoff := p.BaseDelay
+	for i := 1; i < retry && backoff < p.MaxDelay; i++ {
+		backoff *= 2
+	}
+	if backoff > p.MaxDelay {
+		backoff = p.MaxDelay
+	}
+	if backoff <= 0 {
+		return 0
+	}
+
+	half := backoff / 2
+	return half + time.Duration(rand.Int63n(int64(backoff-half)+1))
+}
+
+// isRetryable reports whether a failed request may be sent again. A POST
+// might have been processed by the service jumper, even if its response got
+// lost. It's retried if it wasn't sent and otherwise left to CreateForward,
+// which checks for a created forward first.
+func isRetryable(method string, err error) bool {
+	switch err := err.(type) {
+	case *RequestError:
+		return true
+	case *APIError:
+		if method == "POST" {
+			return false
+		}
+		switch err.StatusCode {
+		case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout, http.StatusTooManyRequests:
+			return true
+		}
+	}
+	return false
+}
+
+// isNotSentError reports whether the request failed before it was sent, e.g.
+// because the connection was refused, the name didn't resolve or the tls
+// handshake failed
+func isNotSentError(err *RequestError) bool {
+	var opError *net.OpError
+	return err.notSent || errors.As(err.Err, &opError) && opError.Op == "dial"
+}
+
+// sleep waits for delay unless ctx is done first
+func sleep(ctx context.Context, delay time.Duration) error {
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
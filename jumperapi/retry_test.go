@@ -0,0 +1,281 @@
+package jumperapi_test
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	. "github.com/anynines/cf_service_jumper_cli_plugin/jumperapi"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("RetryPolicy", func() {
+	It("returns jittered exponential delays", func() {
+		policy := RetryPolicy{MaxRetries: 5, BaseDelay: 100 * time.Millisecond, MaxDelay: time.Second}
+
+		Expect(policy.Delay(1)).To(BeNumerically(">=", 50*time.Millisecond))
+		Expect(policy.Delay(1)).To(BeNumerically("<=", 100*time.Millisecond))
+		Expect(policy.Delay(3)).To(BeNumerically(">=", 200*time.Millisecond))
+		Expect(policy.Delay(3)).To(BeNumerically("<=", 400*time.Millisecond))
+		Expect(policy.Delay(10)).To(BeNumerically("<=", time.Second))
+	})
+})
+
+var _ = Describe("Client retries", func() {
+	var fakeServer *httptest.Server
+	var handler http.HandlerFunc
+	var retryEvents []RetryEvent
+	var client Client
+
+	BeforeEach(func() {
+		retryEvents = nil
+		fakeServer = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			handler(w, r)
+		}))
+		client = NewClient(Config{
+			Endpoint:    fakeServer.URL,
+			RetryPolicy: RetryPolicy{MaxRetries: 2, BaseDelay: time.Millisecond, MaxDelay: time.Millisecond},
+			OnRetry: func(event RetryEvent) {
+				retryEvents = append(retryEvents, event)
+			},
+		})
+	})
+
+	AfterEach(func() {
+		fakeServer.Close()
+	})
+
+	It("retries GET on 502", func() {
+		requests := 0
+		handler = func(w http.ResponseWriter, r *http.Request) {
+			requests++
+			if requests == 1 {
+				w.WriteHeader(http.StatusBadGateway)
+				return
+			}
+			fmt.Fprint(w, `[{ "id": 1 }]`)
+		}
+
+		forwards, err := client.ListForwards(context.Background(), "serviceGuid")
+		Expect(err).To(BeNil())
+		Expect(forwards).To(HaveLen(1))
+		Expect(retryEvents).To(HaveLen(1))
+		Expect(retryEvents[0].Method).To(Equal("GET"))
+		Expect(retryEvents[0].Attempt).To(Equal(2))
+		Expect(retryEvents[0].MaxAttempts).To(Equal(3))
+	})
+
+	It("gives up after max retries", func() {
+		handler = func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+
+		_, err := client.ListForwards(context.Background(), "serviceGuid")
+		Expect(ErrorKindOf(err)).To(Equal(ErrorKindServer))
+		Expect(retryEvents).To(HaveLen(2))
+	})
+
+	It("doesn't retry POST on 502", func() {
+		posts := 0
+		handler = func(w http.ResponseWriter, r *http.Request) {
+			if r.Method == "POST" {
+				posts++
+			}
+			w.WriteHeader(http.StatusBadGateway)
+		}
+
+		_, err := client.CreateForward(context.Background(), "serviceGuid")
+		Expect(ErrorKindOf(err)).To(Equal(ErrorKindServer))
+		Expect(posts).To(Equal(1))
+	})
+
+	It("adopts the forward created by a POST whose response got lost", func() {
+		var posts int32
+		var forwards []string
+		var mutex sync.Mutex
+		handler = func(w http.ResponseWriter, r *http.Request) {
+			mutex.Lock()
+			defer mutex.Unlock()
+			switch {
+			case r.Method == "POST":
+				atomic.AddInt32(&posts, 1)
+				forwards = append(forwards, `{ "id": 7, "shared_secret": "luser:secret" }`)
+				// drop the response after creating the forward
+				conn, _, _ := w.(http.Hijacker).Hijack()
+				conn.Close()
+			case r.URL.Path == "/services/serviceGuid/forwards/":
+				fmt.Fprintf(w, "[%s]", strings.Join(forwards, ","))
+			case r.URL.Path == "/services/serviceGuid/forwards/7":
+				fmt.Fprint(w, forwards[0])
+			default:
+				w.WriteHeader(http.StatusNotFound)
+			}
+		}
+
+		forward, err := client.CreateForward(context.Background(), "serviceGuid")
+		Expect(err).To(BeNil())
+		Expect(forward.ID).To(Equal(7))
+		Expect(forward.SharedSecret).To(Equal("luser:secret"))
+		Expect(atomic.LoadInt32(&posts)).To(Equal(int32(1)))
+	})
+
+	It("retries a POST whose response got lost if no forward was created", func() {
+		var posts int32
+		handler = func(w http.ResponseWriter, r *http.Request) {
+			if r.Method == "GET" {
+				fmt.Fprint(w, `[]`)
+				return
+			}
+			if atomic.AddInt32(&posts, 1) == 1 {
+				conn, _, _ := w.(http.Hijacker).Hijack()
+				conn.Close()
+				return
+			}
+			fmt.Fprint(w, `{ "id": 8 }`)
+		}
+
+		forward, err := client.CreateForward(context.Background(), "serviceGuid")
+		Expect(err).To(BeNil())
+		Expect(forward.ID).To(Equal(8))
+		Expect(atomic.LoadInt32(&posts)).To(Equal(int32(2)))
+		Expect(retryEvents).To(HaveLen(1))
+		Expect(retryEvents[0].Method).To(Equal("POST"))
+	})
+
+	It("returns UnconfirmedForwardError if the forwards of a lost POST can't be listed", func() {
+		var posts int32
+		handler = func(w http.ResponseWriter, r *http.Request) {
+			if r.Method == "GET" {
+				w.WriteHeader(http.StatusForbidden)
+				return
+			}
+			atomic.AddInt32(&posts, 1)
+			conn, _, _ := w.(http.Hijacker).Hijack()
+			conn.Close()
+		}
+
+		_, err := client.CreateForward(context.Background(), "serviceGuid")
+		Expect(err).To(BeAssignableToTypeOf(&UnconfirmedForwardError{}))
+		Expect(err.(*UnconfirmedForwardError).Hint()).To(ContainSubstring("cf list-forwards"))
+		Expect(atomic.LoadInt32(&posts)).To(Equal(int32(1)))
+		Expect(retryEvents).To(BeEmpty())
+	})
+
+	Context("with a failing tls handshake", func() {
+		var tlsServer *httptest.Server
+		var requests int32
+
+		BeforeEach(func() {
+			requests = 0
+			tlsServer = httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				atomic.AddInt32(&requests, 1)
+			}))
+			tlsServer.Config.ErrorLog = log.New(ioutil.Discard, "", 0)
+			tlsServer.StartTLS()
+		})
+
+		AfterEach(func() {
+			tlsServer.Close()
+		})
+
+		createForward := func(tlsConfig *tls.Config) error {
+			client = NewClient(Config{
+				Endpoint:    tlsServer.URL,
+				TLSConfig:   tlsConfig,
+				RetryPolicy: RetryPolicy{MaxRetries: 2, BaseDelay: time.Millisecond, MaxDelay: time.Millisecond},
+				OnRetry: func(event RetryEvent) {
+					retryEvents = append(retryEvents, event)
+				},
+			})
+			_, err := client.CreateForward(context.Background(), "serviceGuid")
+			return err
+		}
+
+		It("retries a POST with an untrusted certificate", func() {
+			err := createForward(nil)
+			Expect(err).To(BeAssignableToTypeOf(&RequestError{}))
+			Expect(retryEvents).To(HaveLen(2))
+			Expect(atomic.LoadInt32(&requests)).To(Equal(int32(0)))
+		})
+
+		It("retries a POST with an unpinned certificate", func() {
+			err := createForward(&tls.Config{
+				InsecureSkipVerify: true,
+				VerifyPeerCertificate: func(rawCerts [][]byte, verifiedChains [][]*x509.Certificate) error {
+					return errors.New("certificate not pinned")
+				},
+			})
+			Expect(err).To(BeAssignableToTypeOf(&RequestError{}))
+			Expect(err.Error()).To(ContainSubstring("certificate not pinned"))
+			Expect(retryEvents).To(HaveLen(2))
+			Expect(atomic.LoadInt32(&requests)).To(Equal(int32(0)))
+		})
+	})
+
+	It("retries a POST which couldn't connect", func() {
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		Expect(err).To(BeNil())
+		endpoint := "http://" + listener.Addr().String()
+		listener.Close()
+
+		client = NewClient(Config{
+			Endpoint:    endpoint,
+			RetryPolicy: RetryPolicy{MaxRetries: 2, BaseDelay: time.Millisecond, MaxDelay: time.Millisecond},
+			OnRetry: func(event RetryEvent) {
+				retryEvents = append(retryEvents, event)
+			},
+		})
+
+		_, err = client.CreateForward(context.Background(), "serviceGuid")
+		Expect(err).To(BeAssignableToTypeOf(&RequestError{}))
+		Expect(retryEvents).To(HaveLen(2))
+		Expect(retryEvents[0].Method).To(Equal("POST"))
+	})
+
+	It("treats 404 of a retried DELETE as success", func() {
+		deletes := 0
+		handler = func(w http.ResponseWriter, r *http.Request) {
+			deletes++
+			if deletes == 1 {
+				conn, _, _ := w.(http.Hijacker).Hijack()
+				conn.Close()
+				return
+			}
+			w.WriteHeader(http.StatusNotFound)
+		}
+
+		Expect(client.DeleteForward(context.Background(), "serviceGuid", 2)).To(Succeed())
+		Expect(deletes).To(Equal(2))
+	})
+
+	It("retries network errors", func() {
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		Expect(err).To(BeNil())
+		address := listener.Addr().String()
+		listener.Close()
+
+		client = NewClient(Config{
+			Endpoint:    "http://" + address,
+			RetryPolicy: RetryPolicy{MaxRetries: 1, BaseDelay: time.Millisecond, MaxDelay: time.Millisecond},
+			OnRetry: func(event RetryEvent) {
+				retryEvents = append(retryEvents, event)
+			},
+		})
+
+		_, err = client.GetForward(context.Background(), "serviceGuid", 1)
+		Expect(err).To(BeAssignableToTypeOf(&RequestError{}))
+		Expect(retryEvents).To(HaveLen(1))
+	})
+})
@@ -0,0 +1,106 @@
+package jumperapi
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+)
+
+// TokenExpiryLeeway is the time before the expiry at which tokens are refreshed
+const TokenExpiryLeeway = 2 * time.Minute
+
+var ErrTokenNotJWT = errors.New("[ERR] access token is not a JWT")
+
+// TokenSource provides access tokens including the token type, e.g. "bearer ..."
+type TokenSource interface {
+	// Token returns a token which isn't about to expire
+	Token() (string, error)
+	// Refresh fetches a new token, e.g. after a 401 response
+	Refresh() (string, error)
+}
+
+// StaticTokenSource always returns the same token
+type StaticTokenSource string
+
+func (s StaticTokenSource) Token() (string, error) {
+	return string(s), nil
+}
+
+func (s StaticTokenSource) Refresh() (string, error) {
+	return string(s), nil
+}
+
+type refreshingTokenSource struct {
+	mutex     sync.Mutex
+	fetch     func() (string, error)
+	now       func() time.Time
+	token     string
+	expiresAt time.Time
+}
+
+// NewRefreshingTokenSource creates a token source which fetches tokens using
+// fetch, e.g. plugin.CliConnection.AccessToken, and refetches them once they
+// are about to expire according to the JWT exp claim.
+func NewRefreshingTokenSource(fetch func() (string, error)) TokenSource {
+	return &refreshingTokenSource{fetch: fetch, now: time.Now}
+}
+
+func (s *refreshingTokenSource) Token() (string, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if len(s.token) > 0 && (s.expiresAt.IsZero() || s.now().Add(TokenExpiryLeeway).Before(s.expiresAt)) {
+		return s.token, nil
+	}
+	return s.refresh()
+}
+
+func (s *refreshingTokenSource) Refresh() (string, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return s.refresh()
+}
+
+func (s *refreshingTokenSource) refresh() (string, error) {
+	token, err := s.fetch()
+	if err != nil {
+		return "", err
+	}
+
+	s.token = token
+	// tokens without exp claim are only refreshed on 401 responses
+	s.expiresAt, _ = TokenExpiry(token)
+	return token, nil
+}
+
+// TokenExpiry decodes the exp claim of a JWT access token. The token type
+// prefix, e.g. "bearer ", is ignored.
+func TokenExpiry(token string) (time.Time, error) {
+	if index := strings.LastIndex(token, " "); index >= 0 {
+		token = token[index+1:]
+	}
+
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return time.Time{}, ErrTokenNotJWT
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(parts[1], "="))
+	if err != nil {
+		return time.Time{}, ErrTokenNotJWT
+	}
+
+	var claims struct {
+		Exp int64 `json:"exp"`
+	}
+	err = json.Unmarshal(payload, &claims)
+	if err != nil || claims.Exp == 0 {
+		return time.Time{}, ErrTokenNotJWT
+	}
+
+	return time.Unix(claims.Exp, 0), nil
+}
@@ -0,0 +1,112 @@
+package jumperapi_test
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	. "github.com/anynines/cf_service_jumper_cli_plugin/jumperapi"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+func jwtExpiringAt(expiresAt time.Time) string {
+	payload := fmt.Sprintf(`{"exp": %d, "user_name": "the_user"}`, expiresAt.Unix())
+	return "bearer header." + base64.RawURLEncoding.EncodeToString([]byte(payload)) + ".signature"
+}
+
+var _ = Describe("TokenExpiry", func() {
+	It("decodes the exp claim", func() {
+		expiresAt := time.Unix(1700000000, 0)
+		Expect(TokenExpiry(jwtExpiringAt(expiresAt))).To(Equal(expiresAt))
+	})
+
+	It("errors if token isn't a JWT", func() {
+		_, err := TokenExpiry("bearer the_token")
+		Expect(err).To(Equal(ErrTokenNotJWT))
+	})
+})
+
+var _ = Describe("RefreshingTokenSource", func() {
+	var fetchCount int
+	var fetchedToken string
+
+	fetch := func() (string, error) {
+		fetchCount++
+		return fetchedToken, nil
+	}
+
+	BeforeEach(func() {
+		fetchCount = 0
+	})
+
+	It("caches tokens which aren't about to expire", func() {
+		fetchedToken = jwtExpiringAt(time.Now().Add(time.Hour))
+		tokenSource := NewRefreshingTokenSource(fetch)
+
+		Expect(tokenSource.Token()).To(Equal(fetchedToken))
+		Expect(tokenSource.Token()).To(Equal(fetchedToken))
+		Expect(fetchCount).To(Equal(1))
+	})
+
+	It("refreshes tokens which are about to expire", func() {
+		fetchedToken = jwtExpiringAt(time.Now().Add(time.Minute))
+		tokenSource := NewRefreshingTokenSource(fetch)
+
+		tokenSource.Token()
+		tokenSource.Token()
+		Expect(fetchCount).To(Equal(2))
+	})
+
+	It("refreshes on demand", func() {
+		fetchedToken = "bearer opaque"
+		tokenSource := NewRefreshingTokenSource(fetch)
+
+		tokenSource.Token()
+		tokenSource.Token()
+		tokenSource.Refresh()
+		Expect(fetchCount).To(Equal(2))
+	})
+})
+
+var _ = Describe("Client with TokenSource", func() {
+	It("retries once with a refreshed token on 401", func() {
+		var authorizations []string
+		fakeServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			authorizations = append(authorizations, r.Header.Get("Authorization"))
+			if r.Header.Get("Authorization") != "bearer fresh" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			fmt.Fprint(w, `[]`)
+		}))
+		defer fakeServer.Close()
+
+		tokens := []string{"bearer expired", "bearer fresh"}
+		tokenSource := NewRefreshingTokenSource(func() (string, error) {
+			token := tokens[0]
+			tokens = tokens[1:]
+			return token, nil
+		})
+		client := NewClient(Config{Endpoint: fakeServer.URL, TokenSource: tokenSource})
+
+		_, err := client.ListForwards(context.Background(), "serviceGuid")
+		Expect(err).To(BeNil())
+		Expect(authorizations).To(Equal([]string{"bearer expired", "bearer fresh"}))
+	})
+
+	It("returns the 401 if the refreshed token is rejected as well", func() {
+		fakeServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusUnauthorized)
+		}))
+		defer fakeServer.Close()
+
+		client := NewClient(Config{Endpoint: fakeServer.URL, AccessToken: "bearer the_token"})
+
+		_, err := client.ListForwards(context.Background(), "serviceGuid")
+		Expect(ErrorKindOf(err)).To(Equal(ErrorKindUnauthorized))
+	})
+})
@@ -1,310 +1,502 @@
 package main
 
 import (
-	"encoding/json"
+	"context"
 	"errors"
 	"fmt"
+	"io"
 	"net/http"
-	"net/url"
 	"os"
+	"path/filepath"
+	"strconv"
 	"strings"
+	"time"
 
+	"github.com/anynines/cf_service_jumper_cli_plugin/jumperapi"
+	"github.com/anynines/cf_service_jumper_cli_plugin/plugin/bundle"
 	"github.com/anynines/cf_service_jumper_cli_plugin/plugin/config"
+	"github.com/anynines/cf_service_jumper_cli_plugin/plugin/logging"
+	"github.com/anynines/cf_service_jumper_cli_plugin/plugin/trace"
+	"github.com/anynines/cf_service_jumper_cli_plugin/xtunnel"
 	"github.com/cloudfoundry/cli/plugin"
-	"github.com/parnurzeal/gorequest"
 )
 
-func fatalIf(err error) {
-	if err != nil {
-		fmt.Fprintln(os.Stdout, "error: ", err)
-		os.Exit(1)
-	}
-}
-
 var (
 	ErrMissingServiceInstanceArg              = errors.New("[ERR] missing SERVICE_INSTANCE")
-	ErrMissingConnectionID                    = errors.New("[ERR] missing CONNECTION_ID")
+	ErrInvalidConnectionID                    = errors.New("[ERR] CONNECTION_ID must be numeric")
+	ErrMissingCommand                         = errors.New("[ERR] missing command. Use: cf forward-app APP_NAME -- COMMAND [ARGS...]")
 	ErrCfServiceJumperEndpointGetFailed       = errors.New("[ERR] Failed to fetch information from Cloud Foundry api endpoint")
 	ErrCfServiceJumperEndpointStatusCodeWrong = errors.New("[ERR] Failed to fetch information from Cloud Foundry api endpoint. HTTP status code != 200")
 	ErrCfServiceJumperEndpointNotPresent      = errors.New("[ERR] cf service jumper api endpoint not present/installed")
 	PcfServiceJumperHostname                  = "a9s-service-jumper"
 )
 
-// ArgsExtractServiceInstanceName extract service instance name from args
-func ArgsExtractServiceInstanceName(args []string) (string, error) {
-	if len(args) < 2 {
-		return "", ErrMissingServiceInstanceArg
-	}
+// CfServiceJumperPlugin This is the struct implementing the interface defined by the core CLI. It can
+// be found at  "https://github.com/cloudfoundry/cli/blob/master/plugin/plugin.go"
+type CfServiceJumperPlugin struct {
+	CfServiceJumperAccessToken string
+	CfServiceJumperAPIEndpoint string
+
+	// JumperClient is created by InitJumperAPI unless set
+	JumperClient jumperapi.Client
 
-	return args[1], nil
+	isSSLDisabled bool
+	// logger writes to stderr with -v/-vv and to forward.log with the log_file setting
+	logger *logging.Logger
+	// output is the --output format, text or json
+	output string
+	// traceWriter receives http traces if CF_TRACE is set
+	traceWriter io.Writer
+	// forwardConfig is loaded from forward.json on every run
+	forwardConfig config.ForwardConfig
+	// debugBundle collects logs and api calls with --debug-bundle
+	debugBundle *bundle.Bundle
+	// discovery is the last endpoint discovery, reported by the debug bundle
+	discovery *EndpointDiscovery
+
+	// Stdout and Stderr receive the output of commands, os.Stdout and os.Stderr unless set
+	Stdout io.Writer
+	Stderr io.Writer
+	// Exit ends the process with a non-zero exit code, os.Exit unless set
+	Exit func(code int)
+	// WaitForShutdown blocks while the forward is open, WaitForSignal unless set
+	WaitForShutdown func()
 }
 
-// ArgsExtractConnectionID extracts connection ID from args
-func ArgsExtractConnectionID(args []string) (string, error) {
-	if len(args) < 3 {
-		return "", ErrMissingConnectionID
+// InitJumperAPI fetches access token and service jumper api endpoint
+func (c *CfServiceJumperPlugin) InitJumperAPI(cliConnection plugin.CliConnection) error {
+	var err error
+
+	// the cli refreshes the token on every AccessToken call
+	tokenSource := jumperapi.NewRefreshingTokenSource(cliConnection.AccessToken)
+	c.CfServiceJumperAccessToken, err = tokenSource.Token()
+	if err != nil {
+		return err
 	}
 
-	return args[2], nil
-}
+	tlsOptions := TLSOptionsFromConfig(c.forwardConfig, c.isSSLDisabled)
 
-// FetchCfServiceJumperAPIEndpoint fetches the Service Jumper API endpoint
-func FetchCfServiceJumperAPIEndpoint(cfAPIEndpoint string, isSSLDisabled bool) (string, error) {
-	endpoint, err := FetchCfServiceJumperAPIEndpointFromConfig()
-	if err == nil && endpoint != "" {
-		return endpoint, nil
+	// the endpoint is discovered unless set, e.g. by forward-doctor
+	if len(c.CfServiceJumperAPIEndpoint) < 1 {
+		discovery, err := c.newEndpointDiscovery(cliConnection, tlsOptions)
+		if err != nil {
+			return err
+		}
+		c.CfServiceJumperAPIEndpoint, err = FetchCfServiceJumperAPIEndpoint(discovery)
+		if err != nil {
+			return err
+		}
 	}
 
-	endpoint, err = FetchCfServiceJumperAPIEndpointFromInfo(cfAPIEndpoint, isSSLDisabled)
-	if err == nil {
-		return endpoint, nil
+	tlsConfig, err := NewTLSConfig(tlsOptions, true)
+	if err != nil {
+		return err
 	}
 
-	return FetchCfServiceJumperAPIEndpointFromSharedDomain(cfAPIEndpoint)
-}
-
-func FetchCfServiceJumperAPIEndpointFromConfig() (string, error) {
-	forwardConfig, err := config.GetConfig()
-	if err == config.ErrForwardConfigMissing {
-		return "", config.ErrTargetBlank
-	}
-	if forwardConfig.Target == "" {
-		return "", config.ErrTargetBlank
+	if c.JumperClient == nil {
+		c.JumperClient = jumperapi.NewClient(jumperapi.Config{
+			Endpoint:    c.CfServiceJumperAPIEndpoint,
+			TokenSource: tokenSource,
+			Timeout:     c.forwardConfig.Duration(config.SettingTimeout),
+			RetryPolicy: RetryPolicyFromConfig(c.forwardConfig),
+			OnRetry:     c.logRetry,
+			Transport:   NewHttpTransport(tlsConfig, c.traceWriter, c.logger),
+		})
 	}
-	return forwardConfig.Target, nil
+	return nil
 }
 
-func FetchCfServiceJumperAPIEndpointFromInfo(cfAPIEndpoint string, isSSLDisabled bool) (string, error) {
-	url := fmt.Sprintf("%s/v2/info", cfAPIEndpoint)
-
-	httpClient := NewHttpClient(isSSLDisabled)
-	resp, body, errs := httpClient.Get(url).End()
-
-	if len(errs) > 0 {
-		return "", ErrCfServiceJumperEndpointGetFailed
-	}
-	if resp.StatusCode != http.StatusOK {
-		return "", ErrCfServiceJumperEndpointStatusCodeWrong
+// newEndpointDiscovery prepares the service jumper endpoint discovery of the
+// targeted cf api
+func (c *CfServiceJumperPlugin) newEndpointDiscovery(cliConnection plugin.CliConnection, tlsOptions TLSOptions) (*EndpointDiscovery, error) {
+	apiEndpoint, err := cliConnection.ApiEndpoint()
+	if err != nil {
+		return nil, err
 	}
 
-	type CfInfo struct {
-		Custom map[string]string `json:"custom"`
+	cfHTTPClient, err := c.cfHTTPClient()
+	if err != nil {
+		return nil, err
 	}
-	var cfInfo CfInfo
-	err := json.Unmarshal([]byte(body), &cfInfo)
+	jumperTLSConfig, err := NewTLSConfig(tlsOptions, true)
 	if err != nil {
-		return "", fmt.Errorf("[ERR] cf service jumper api endpoint unmarshal failed. %s", err)
+		return nil, err
 	}
 
-	serviceJumperEndpoint := cfInfo.Custom["service_jumper_endpoint"]
-	if len(serviceJumperEndpoint) < 1 {
-		return "", ErrCfServiceJumperEndpointNotPresent
+	c.discovery = &EndpointDiscovery{
+		CfAPIEndpoint:    apiEndpoint,
+		CfHTTPClient:     cfHTTPClient,
+		JumperHTTPClient: NewHttpClient(jumperTLSConfig, c.forwardConfig.Duration(config.SettingTimeout), c.traceWriter, c.logger),
+		Logger:           c.logger,
 	}
-
-	return serviceJumperEndpoint, nil
+	return c.discovery, nil
 }
 
-func FetchCfServiceJumperAPIEndpointFromSharedDomain(cfAPIEndpoint string) (string, error) {
-	u, err := url.Parse(cfAPIEndpoint)
+// cfHTTPClient returns the http client of cf api requests. Pins only apply to
+// the service jumper, not to the cf api.
+func (c *CfServiceJumperPlugin) cfHTTPClient() (*http.Client, error) {
+	tlsConfig, err := NewTLSConfig(TLSOptionsFromConfig(c.forwardConfig, c.isSSLDisabled), false)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
-
-	u.Host = strings.TrimPrefix(u.Host, "api.")
-	u.Host = PcfServiceJumperHostname + "." + u.Host
-	return u.String(), nil
+	return NewHttpClient(tlsConfig, c.forwardConfig.Duration(config.SettingTimeout), c.traceWriter, c.logger), nil
 }
 
-// CfServiceJumperPlugin This is the struct implementing the interface defined by the core CLI. It can
-// be found at  "https://github.com/cloudfoundry/cli/blob/master/plugin/plugin.go"
-type CfServiceJumperPlugin struct {
-	CfServiceJumperAccessToken string
-	CfServiceJumperAPIEndpoint string
+// RetryPolicyFromConfig returns the default retry policy with the number of
+// retries of the retries setting (CF_FORWARD_RETRIES). 0 disables retries.
+func RetryPolicyFromConfig(forwardConfig config.ForwardConfig) jumperapi.RetryPolicy {
+	maxRetries := forwardConfig.Int(config.SettingRetries)
+	if maxRetries == 0 {
+		return jumperapi.NoRetries
+	}
 
-	isSSLDisabled bool
+	retryPolicy := jumperapi.DefaultRetryPolicy
+	retryPolicy.MaxRetries = maxRetries
+	return retryPolicy
 }
 
-// FetchServiceGUID fetch service GUID by service name
-func (c *CfServiceJumperPlugin) FetchServiceGUID(cliConnection plugin.CliConnection, serviceInstanceName string) (string, error) {
-	cmdOutput, err := cliConnection.CliCommandWithoutTerminalOutput("service", serviceInstanceName, "--guid")
+// autoDeleteForward deletes the forward on exit, see the auto_delete setting
+func (c *CfServiceJumperPlugin) autoDeleteForward(serviceGUID string, forwardID int) {
+	c.logger.Infof("deleting forward %d of service instance %s", forwardID, serviceGUID)
+	err := c.JumperClient.DeleteForward(context.Background(), serviceGUID, forwardID)
 	if err != nil {
-		return "", fmt.Errorf("Failed to get service guid. %s", err.Error())
+		c.logger.Errorf("failed to delete forward %d. %s", forwardID, err)
+		fmt.Fprintln(c.stderr(), err)
+		return
 	}
-	serviceGUID := strings.Trim(cmdOutput[0], " \n")
-
-	return serviceGUID, nil
+	fmt.Fprintf(c.messageWriter(), "\nForward %d deleted.\n", forwardID)
 }
 
-func (c *CfServiceJumperPlugin) NewHttpClient() *gorequest.SuperAgent {
-	return NewHttpClient(c.isSSLDisabled)
+// logRetry reports retries, shown on stderr with -v
+func (c *CfServiceJumperPlugin) logRetry(event jumperapi.RetryEvent) {
+	c.logger.Infof("%s %s failed. Retrying in %s (attempt %d/%d). %s", event.Method, event.Path, event.Delay, event.Attempt, event.MaxAttempts, event.Err)
 }
 
-func (c *CfServiceJumperPlugin) NewUrl(path string) string {
-	u := fmt.Sprintf("%s%s", c.CfServiceJumperAPIEndpoint, path)
-	if c.isSSLDisabled {
-		u = u + "?skip-ssl-validation=true"
+// createForward creates a forward for the service using the service jumper api
+func (c *CfServiceJumperPlugin) createForward(ctx context.Context, serviceGUID string) (ForwardDataSet, error) {
+	forward, err := c.JumperClient.CreateForward(ctx, serviceGUID)
+	if err != nil {
+		return ForwardDataSet{}, err
 	}
-	return u
+	return NewForwardDataSet(forward), nil
 }
 
-// CreateForward create forward for service
-func (c *CfServiceJumperPlugin) CreateForward(serviceGUID string) (ForwardDataSet, error) {
-	var forwardDataSet ForwardDataSet
-
-	path := fmt.Sprintf("/services/%s/forwards", serviceGUID)
-	url := c.NewUrl(path)
+// Run This function must be implemented by any plugin because it is part of the
+// plugin interface defined by the core CLI.
+//
+// Run(....) is the entry point when the core CLI is invoking a command defined
+// by a plugin. The first parameter, plugin.CliConnection, is a struct that can
+// be used to invoke cli commands. The second paramter, args, is a slice of
+// strings. args[0] will be the name of the command, and will be followed by
+// any additional arguments a cli user typed in.
+//
+// Any error handling should be handled with the plugin itself (this means printing
+// user facing errors). The CLI will exit 0 if the plugin exits 0 and will exit
+// 1 should the plugin exits nonzero.
+func (c *CfServiceJumperPlugin) Run(cliConnection plugin.CliConnection, args []string) {
+	err := c.Execute(cliConnection, args)
+	if exitCode := c.reportError(err); exitCode != ExitCodeOK {
+		c.exit(exitCode)
+	}
+}
 
-	httpClient := c.NewHttpClient()
-	resp, body, errs := httpClient.Post(url).Set("Authorization", c.CfServiceJumperAccessToken).End()
-	if errs != nil {
-		return forwardDataSet, fmt.Errorf("[ERR] cf service jumper request failed. %s", errs[0])
+// reportError prints err with its hint and exit code and returns the exit
+// code. The cf cli exits with 1 on any failure of a plugin, so the exit code
+// is printed with the error, as json with --output json.
+func (c *CfServiceJumperPlugin) reportError(err error) int {
+	if err == nil {
+		return ExitCodeOK
 	}
-	if resp.StatusCode != http.StatusOK {
-		return forwardDataSet, fmt.Errorf("[ERR] cf service jumper request failed. status != 200.\n%s", body)
+	exitCode := ExitCode(err)
+	var commandExitError *CommandExitError
+	if errors.As(err, &commandExitError) {
+		return exitCode
+	}
+	hint := ""
+	var hinter Hinter
+	if errors.As(err, &hinter) {
+		hint = hinter.Hint()
 	}
 
-	err := json.Unmarshal([]byte(body), &forwardDataSet)
-	if err != nil {
-		return forwardDataSet, fmt.Errorf("[ERR] cf service jumper request failed. unmarshal error: %s", err)
+	if c.output == "json" {
+		OutputJSON(c.stderr(), map[string]interface{}{"error": err.Error(), "hint": hint, "exit_code": exitCode})
+		return exitCode
+	}
+	fmt.Fprintln(c.messageWriter(), "error: ", err)
+	if len(hint) > 0 {
+		fmt.Fprintln(c.messageWriter(), "hint: ", hint)
 	}
-	return forwardDataSet, nil
+	fmt.Fprintf(c.messageWriter(), "exit code: %d\n", exitCode)
+	return exitCode
 }
 
-// DeleteForward delete forward for service
-func (c *CfServiceJumperPlugin) DeleteForward(serviceGUID string, connectionID string) error {
-	path := fmt.Sprintf("/services/%s/forwards/%s", serviceGUID, connectionID)
-	url := c.NewUrl(path)
+// Execute runs the command of args and returns its error instead of exiting
+func (c *CfServiceJumperPlugin) Execute(cliConnection plugin.CliConnection, args []string) (err error) {
+	if len(args) > 0 && args[0] == "CLI-MESSAGE-UNINSTALL" {
+		return nil
+	}
 
-	httpClient := c.NewHttpClient()
-	resp, body, errs := httpClient.Delete(url).Set("Authorization", c.CfServiceJumperAccessToken).End()
-	if errs != nil {
-		return fmt.Errorf("[ERR] Failed cf_service_jumper request. %s", errs[0].Error())
+	commandLine, err := ParseCommandLine(args)
+	if err != nil {
+		return err
 	}
-	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("[ERR] Failed cf_service_jumper request. HTTP status code != 200.\n%s", body)
+	c.output = commandLine.Output()
+	if len(commandLine.String("--debug-bundle")) > 0 {
+		c.debugBundle = bundle.New()
 	}
 
-	fmt.Println(body)
-	return nil
-}
+	c.traceWriter, err = trace.WriterFromEnv()
+	if err != nil {
+		return err
+	}
+	if c.debugBundle != nil {
+		var bundleTrace io.Writer = c.debugBundle.Writer("http.log")
+		if c.traceWriter != nil {
+			bundleTrace = io.MultiWriter(c.traceWriter, bundleTrace)
+		}
+		c.traceWriter = bundleTrace
+	}
 
-// ListForwards list all forwards for the given service
-func (c *CfServiceJumperPlugin) ListForwards(serviceGUID string) error {
-	path := fmt.Sprintf("/services/%s/forwards/", serviceGUID)
-	url := c.NewUrl(path)
+	c.forwardConfig, err = config.GetConfig()
+	if err != nil {
+		return err
+	}
 
-	httpClient := c.NewHttpClient()
-	resp, body, errs := httpClient.Get(url).Set("Authorization", c.CfServiceJumperAccessToken).End()
-	if errs != nil {
-		return fmt.Errorf("Failed cf_service_jumper request. %s", errs[0].Error())
+	c.logger, err = c.newLogger(commandLine)
+	if err != nil {
+		return err
+	}
+	defer c.logger.Close()
+	if c.debugBundle != nil {
+		defer func() {
+			bundleErr := c.writeDebugBundle(cliConnection, commandLine, args, err)
+			if bundleErr != nil && err == nil {
+				err = bundleErr
+			}
+		}()
+	}
+	c.logger.Debugf("cf %s", strings.Join(args, " "))
+	defer func() {
+		if err != nil {
+			c.logger.Errorf("cf %s failed. %s", commandLine.Spec.Name, err)
+		}
+	}()
+
+	switch commandLine.Spec.Name {
+	case "forward-config":
+		return c.ForwardConfigCommand(commandLine)
+	case "forward-completion":
+		return c.ForwardCompletion(commandLine)
+	case "forward-complete":
+		return c.ForwardComplete(cliConnection, commandLine)
 	}
-	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("Failed cf_service_jumper request. HTTP status code != 200.\n%s", body)
+
+	if commandLine.Spec.Name == "forward-doctor" {
+		// the settings are one of the checks
+		return c.ForwardDoctor(cliConnection, commandLine)
 	}
 
-	var forwardDataSetCollection []ForwardDataSet
-	err := json.Unmarshal([]byte(body), &forwardDataSetCollection)
+	// forward-config can fix invalid settings, all other commands rely on them
+	err = c.forwardConfig.Validate()
 	if err != nil {
-		return fmt.Errorf("[ERR] cf service jumper request failed. unmarshal error: %s", err)
+		return err
 	}
 
-	OutputForwardDataSets(forwardDataSetCollection)
+	if commandLine.Spec.Name == "forward-api" {
+		return c.ForwardAPI(cliConnection, commandLine)
+	}
 
-	return nil
-}
+	c.isSSLDisabled, err = cliConnection.IsSSLDisabled()
+	if err != nil {
+		return err
+	}
 
-// Run This function must be implemented by any plugin because it is part of the
-// plugin interface defined by the core CLI.
-//
-// Run(....) is the entry point when the core CLI is invoking a command defined
-// by a plugin. The first parameter, plugin.CliConnection, is a struct that can
-// be used to invoke cli commands. The second paramter, args, is a slice of
-// strings. args[0] will be the name of the command, and will be followed by
-// any additional arguments a cli user typed in.
-//
-// Any error handling should be handled with the plugin itself (this means printing
-// user facing errors). The CLI will exit 0 if the plugin exits 0 and will exit
-// 1 should the plugin exits nonzero.
-func (c *CfServiceJumperPlugin) Run(cliConnection plugin.CliConnection, args []string) {
-	var err error
+	if commandLine.Spec.Name == "forward-app" {
+		err = c.InitJumperAPI(cliConnection)
+		if err != nil {
+			return err
+		}
 
-	if args[0] == "CLI-MESSAGE-UNINSTALL" {
-		os.Exit(0)
+		exitCode, err := c.ForwardApp(cliConnection, commandLine.Arg(0), commandLine.Passthrough)
+		if err != nil {
+			return err
+		}
+		if exitCode != ExitCodeOK {
+			return &CommandExitError{ExitCode: exitCode}
+		}
+		return nil
 	}
 
-	if args[0] == "forward-api" {
-		if len(args) > 1 {
-			if args[1] == "-d" {
-				// delete forward api endpoint
-				err = config.SetTarget("")
-				fatalIf(err)
-				return
-			}
+	serviceInstance, err := c.ResolveServiceInstance(cliConnection, commandLine.ServiceQuery())
+	if err != nil {
+		return err
+	}
+
+	err = c.InitJumperAPI(cliConnection)
+	if err != nil {
+		return err
+	}
 
-			// set forward endpoint
-			_, err := url.ParseRequestURI(args[1])
-			fatalIf(err)
+	switch commandLine.Spec.Name {
+	case "create-forward":
+		return c.runCreateForward(commandLine, serviceInstance)
+	case "forward-env":
+		return c.runForwardEnv(commandLine, serviceInstance)
+	case "delete-forward":
+		return c.runDeleteForward(commandLine, serviceInstance)
+	case "list-forwards":
+		return c.runListForwards(serviceInstance)
+	}
+	return ErrUnknownCommand
+}
 
-			err = config.SetTarget(args[1])
-			fatalIf(err)
-			fmt.Printf("forwarf-api set to %s\n", args[1])
-			return
+// runCreateForward creates a forward, prints credentials and sample commands
+// and keeps the tunnels open until shutdown
+func (c *CfServiceJumperPlugin) runCreateForward(commandLine *CommandLine, serviceInstance ServiceInstance) error {
+	dashboard := &Dashboard{ServiceName: serviceInstance.Name}
+	showDashboard := c.dashboardEnabled(commandLine)
+	session, err := c.startForwardSession(commandLine, serviceInstance, func(session *forwardSession) []func(event xtunnel.Event) {
+		if showDashboard {
+			dashboard.ForwardID = session.ForwardInfo.ID
+			dashboard.Credentials = session.Credentials
+			return []func(event xtunnel.Event){dashboard.Event}
 		}
+		// the dashboard shows credentials on demand only
+		OutputCredentials(c.stdout(), session.Credentials)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	defer session.Close()
+	forwardInfo, credentials, serviceType, tunnels := session.ForwardInfo, session.Credentials, session.ServiceType, session.Tunnels
 
-		// show forward endpoint
-		endpoint, err := FetchCfServiceJumperAPIEndpointFromConfig()
-		fatalIf(err)
-		fmt.Printf("forward-api %s\n", endpoint)
-		return
+	if session.HealthCheck != nil {
+		OutputHealthCheck(c.stdout(), session.HealthCheck)
+	}
+	connectionPrinter := c.connectionPrinter(serviceType, credentials)
+	if !showDashboard {
+		OutputSampleCmds(c.stdout(), SampleCallOutputs(connectionPrinter, LocalAddresses(tunnels)))
 	}
 
-	c.isSSLDisabled, err = cliConnection.IsSSLDisabled()
-	fatalIf(err)
+	if exportProfile := commandLine.Values("--export-profile"); len(exportProfile) > 0 {
+		profileFormat, profileFile := exportProfile[0], exportProfile[1]
+		profileExporter := ProfileExporter{
+			Name:           serviceInstance.Name,
+			ServiceType:    serviceType,
+			Credentials:    credentials,
+			LocalAddresses: LocalAddresses(tunnels),
+			SavePassword:   commandLine.Bool("--save-password"),
+		}
+		err = profileExporter.Export(profileFormat, profileFile)
+		if err != nil {
+			fmt.Fprintln(c.stderr(), "[ERR] Failed to export connection profile.", err)
+		} else {
+			fmt.Fprintf(c.stdout(), "\nConnection profile for %s written to %s\n", profileFormat, profileFile)
+		}
+	}
 
-	serviceInstanceName, err := ArgsExtractServiceInstanceName(args)
-	fatalIf(err)
+	quit := false
+	if showDashboard {
+		dashboard.Tunnels = tunnelsOf(tunnels)
+		dashboard.ConnectionStrings, dashboard.MaskedConnectionStrings = c.dashboardConnectionStrings(serviceType, forwardInfo, LocalAddresses(tunnels))
+		dashboard.Stop = session.ControlShutdown
+		dashboard.ProbeRoles = c.dashboardRoleProbe(serviceType, credentials, dashboard.Tunnels)
+		quit = c.runDashboard(dashboard)
+	} else {
+		quit = c.waitForShutdownOr(session.ControlShutdown)
+	}
+	c.logger.Infof("shutting down forward %d", forwardInfo.ID)
 
-	serviceGUID, err := c.FetchServiceGUID(cliConnection, serviceInstanceName)
-	fatalIf(err)
+	// quitting the dashboard or a shutdown of the control API deletes the forward
+	session.Keep = !quit && !c.forwardConfig.Bool(config.SettingAutoDelete)
+	if session.Keep {
+		fmt.Fprintln(c.stdout(), "\nRemember to 'cf delete-forward'!")
+	}
+	return nil
+}
 
-	c.CfServiceJumperAccessToken, err = cliConnection.AccessToken()
-	fatalIf(err)
+// runForwardEnv creates a forward and prints connection strings for local apps
+func (c *CfServiceJumperPlugin) runForwardEnv(commandLine *CommandLine, serviceInstance ServiceInstance) error {
+	format := commandLine.StringOr("--format", c.forwardConfig.Value(config.SettingConnectionFormat))
 
-	apiEndpoint, err := cliConnection.ApiEndpoint()
-	fatalIf(err)
+	session, err := c.startForwardSession(commandLine, serviceInstance, nil)
+	if err != nil {
+		return err
+	}
+	defer session.Close()
+	forwardInfo, tunnels := session.ForwardInfo, session.Tunnels
+
+	var connectionStrings []string
+	if format == "env" {
+		connectionStrings = forwardInfo.Credentials.Credentials.WithLocalAddresses(LocalAddresses(tunnels)).EnvVars()
+	} else {
+		connectionStringBuilder := ConnectionStringBuilder{
+			ServiceType:    session.ServiceType,
+			Credentials:    session.Credentials,
+			LocalAddresses: LocalAddresses(tunnels),
+		}
+		connectionStrings, err = connectionStringBuilder.Build(format)
+		if err != nil {
+			return err
+		}
+	}
+	if c.output == "json" {
+		result := map[string]interface{}{"forward_id": forwardInfo.ID, "format": format, "connection_strings": connectionStrings}
+		if session.HealthCheck != nil {
+			result["health_check"] = healthCheckJSON(session.HealthCheck)
+		}
+		err = OutputJSON(c.stdout(), result)
+		if err != nil {
+			return err
+		}
+	} else {
+		if session.HealthCheck != nil {
+			OutputHealthCheck(c.stdout(), session.HealthCheck)
+		}
+		OutputConnectionStrings(c.stdout(), connectionStrings)
+	}
 
-	c.CfServiceJumperAPIEndpoint, err = FetchCfServiceJumperAPIEndpoint(apiEndpoint, c.isSSLDisabled)
-	fatalIf(err)
+	if !c.forwardConfig.Bool(config.SettingAutoDelete) {
+		fmt.Fprintln(c.messageWriter(), "\nRemember to 'cf delete-forward'!")
+	}
 
-	if args[0] == "create-forward" {
-		forwardInfo, err := c.CreateForward(serviceGUID)
-		fatalIf(err)
-		credentials := forwardInfo.CredentialsMap()
+	quit := c.waitForShutdownOr(session.ControlShutdown)
+	c.logger.Infof("shutting down forward %d", forwardInfo.ID)
 
-		fmt.Println("\nCredentials:")
-		for credentialKey, credentialValue := range credentials {
-			if stringInStrSlice(credentialKey, []string{"uri", "host", "port"}) {
-				continue
-			}
+	session.Keep = !quit && !c.forwardConfig.Bool(config.SettingAutoDelete)
+	return nil
+}
 
-			fmt.Println(fmt.Sprintf("%s: %s", credentialKey, credentialValue))
-		}
-		fmt.Printf("\n")
+// runDeleteForward deletes the forward CONNECTION_ID
+func (c *CfServiceJumperPlugin) runDeleteForward(commandLine *CommandLine, serviceInstance ServiceInstance) error {
+	// validated by the command line parser
+	forwardID, _ := strconv.Atoi(commandLine.Arg(1))
 
-		connectionPrinter := SelectConnectionPrinter(credentials)
-		ListenAndOutputInfo(forwardInfo.Hosts, forwardInfo.SharedSecret, connectionPrinter)
+	err := c.JumperClient.DeleteForward(context.Background(), serviceInstance.GUID, forwardID)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(c.stdout(), "Forward %d deleted.\n", forwardID)
+	return nil
+}
 
-		fmt.Println("\nRemember to 'cf delete-forward'!")
+// runListForwards lists the open forwards of the service instance
+func (c *CfServiceJumperPlugin) runListForwards(serviceInstance ServiceInstance) error {
+	forwards, err := c.JumperClient.ListForwards(context.Background(), serviceInstance.GUID)
+	if err != nil {
+		return err
+	}
 
-	} else if args[0] == "delete-forward" {
-		connectionID, err := ArgsExtractConnectionID(args)
-		fatalIf(err)
-		err = c.DeleteForward(serviceGUID, connectionID)
-		fatalIf(err)
-	} else if args[0] == "list-forwards" {
-		err = c.ListForwards(serviceGUID)
-		fatalIf(err)
+	forwardDataSets := make([]ForwardDataSet, len(forwards))
+	for i, forward := range forwards {
+		forwardDataSets[i] = NewForwardDataSet(forward)
+	}
+	if c.output == "json" {
+		return OutputForwardDataSetsJSON(c.stdout(), forwardDataSets)
 	}
+	OutputForwardDataSets(c.stdout(), forwardDataSets)
+	return nil
 }
 
 // GetMetadata must be implemented as part of the plugin interface
@@ -327,36 +519,128 @@ func (c *CfServiceJumperPlugin) GetMetadata() plugin.PluginMetadata {
 			Minor: 0,
 			Build: 0,
 		},
-		Commands: []plugin.Command{
-			plugin.Command{
-				Name:     "create-forward",
-				HelpText: "Creates/Recycles forward to service instance.",
-				UsageDetails: plugin.Usage{
-					Usage: "cf create-forward SERVICE_INSTANCE",
-				},
-			},
-			plugin.Command{
-				Name:     "delete-forward",
-				HelpText: "Deletes forward to service instance.",
-				UsageDetails: plugin.Usage{
-					Usage: "cf delete-forward SERVICE_INSTANCE CONNECTION_ID",
-				},
-			},
-			plugin.Command{
-				Name:     "list-forwards",
-				HelpText: "List open forwards to service instance.",
-				UsageDetails: plugin.Usage{
-					Usage: "cf list-forwards",
-				},
-			},
-			plugin.Command{
-				Name:     "forward-api",
-				HelpText: "Show/Set/Delete service jumper api url.",
-				UsageDetails: plugin.Usage{
-					Usage: "cf forward-api SERVICE_JUMPER_API_URL\ncf forward-api [-d]",
-				},
-			},
-		},
+		Commands: PluginCommands(),
+	}
+}
+
+// newLogger logs to stderr with -v (info) or -vv (debug), to the debug bundle
+// and to forward.log if the log_file setting is enabled
+func (c *CfServiceJumperPlugin) newLogger(commandLine *CommandLine) (*logging.Logger, error) {
+	logger := logging.New()
+	switch {
+	case commandLine.Bool("--debug"):
+		logger.AddSink(c.stderr(), logging.LevelDebug)
+	case commandLine.Verbose():
+		logger.AddSink(c.stderr(), logging.LevelInfo)
+	}
+	if c.debugBundle != nil {
+		logger.AddSink(c.debugBundle.Writer("forward.log"), logging.LevelDebug)
+	}
+
+	if !c.forwardConfig.Bool(config.SettingLogFile) {
+		return logger, nil
+	}
+	// invalid levels are reported by the settings validation
+	level, err := logging.ParseLevel(c.forwardConfig.Value(config.SettingLogLevel))
+	if err != nil {
+		level = logging.LevelDebug
+	}
+	logFilePath, err := config.LogFilePath()
+	if err != nil {
+		return nil, err
+	}
+	err = os.MkdirAll(filepath.Dir(logFilePath), 0700)
+	if err != nil {
+		return nil, err
+	}
+	logFile, err := logging.OpenRotatingFile(logFilePath, logging.DefaultMaxSize, logging.DefaultMaxBackups)
+	if err != nil {
+		return nil, err
+	}
+	logger.AddSink(logFile, level)
+	return logger, nil
+}
+
+// tunnelEvents returns the event handler of tunnels which logs events and
+// passes them to handlers
+func (c *CfServiceJumperPlugin) tunnelEvents(handlers ...func(event xtunnel.Event)) func(event xtunnel.Event) {
+	return func(event xtunnel.Event) {
+		c.logTunnelEvent(event)
+		for _, handler := range handlers {
+			handler(event)
+		}
+	}
+}
+
+// logTunnelEvent records listen, accept, dial, close and shutdown events of tunnels
+func (c *CfServiceJumperPlugin) logTunnelEvent(event xtunnel.Event) {
+	switch {
+	case event.Err != nil:
+		c.logger.Warnf("tunnel %s -> %s: %s failed after %s. %s", event.LocalAddress, event.RemoteAddress, event.Type, event.Duration.Round(time.Millisecond), event.Err)
+	case event.Type == xtunnel.EventListen || event.Type == xtunnel.EventShutdown:
+		c.logger.Infof("tunnel %s -> %s: %s", event.LocalAddress, event.RemoteAddress, event.Type)
+	case event.Type == xtunnel.EventAccept:
+		c.logger.Debugf("tunnel %s -> %s: accepted %s", event.LocalAddress, event.RemoteAddress, event.ClientAddress)
+	default:
+		c.logger.Debugf("tunnel %s -> %s: %s %s (%s)", event.LocalAddress, event.RemoteAddress, event.Type, event.ClientAddress, event.Duration.Round(time.Millisecond))
+	}
+}
+
+func (c *CfServiceJumperPlugin) stdout() io.Writer {
+	if c.Stdout == nil {
+		return os.Stdout
+	}
+	return c.Stdout
+}
+
+func (c *CfServiceJumperPlugin) stderr() io.Writer {
+	if c.Stderr == nil {
+		return os.Stderr
+	}
+	return c.Stderr
+}
+
+// messageWriter returns the writer of informational messages which must not
+// mix with json output
+func (c *CfServiceJumperPlugin) messageWriter() io.Writer {
+	if c.output == "json" {
+		return c.stderr()
+	}
+	return c.stdout()
+}
+
+func (c *CfServiceJumperPlugin) exit(code int) {
+	if c.Exit == nil {
+		os.Exit(code)
+	}
+	c.Exit(code)
+}
+
+func (c *CfServiceJumperPlugin) waitForShutdown() {
+	if c.WaitForShutdown == nil {
+		WaitForSignal()
+		return
+	}
+	c.WaitForShutdown()
+}
+
+// waitForShutdownOr waits for shutdown or until stop is closed. Returns true if
+// stop was closed.
+func (c *CfServiceJumperPlugin) waitForShutdownOr(stop <-chan struct{}) bool {
+	if stop == nil {
+		c.waitForShutdown()
+		return false
+	}
+	done := make(chan struct{})
+	go func() {
+		c.waitForShutdown()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return false
+	case <-stop:
+		return true
 	}
 }
 
@@ -4,36 +4,39 @@ import (
 	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"time"
 
 	. "github.com/anynines/cf_service_jumper_cli_plugin"
+	"github.com/anynines/cf_service_jumper_cli_plugin/jumperapi"
+	"github.com/anynines/cf_service_jumper_cli_plugin/plugin/config"
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
 )
 
 var _ = Describe("main", func() {
-	Describe("ArgsExtractServiceInstanceName", func() {
-		It("errors if less than 2 args", func() {
-			_, err := ArgsExtractServiceInstanceName([]string{"arg0"})
-			Expect(err).To(Equal(ErrMissingServiceInstanceArg))
+	Describe("RetryPolicyFromConfig", func() {
+		AfterEach(func() {
+			os.Unsetenv("CF_FORWARD_RETRIES")
 		})
 
-		It("works with 2 args", func() {
-			instanceName, err := ArgsExtractServiceInstanceName([]string{"arg0", "arg1"})
-			Expect(err).To(BeNil())
-			Expect(instanceName).To(Equal("arg1"))
+		It("returns the default retry policy", func() {
+			Expect(RetryPolicyFromConfig(config.ForwardConfig{})).To(Equal(jumperapi.DefaultRetryPolicy))
 		})
-	})
 
-	Describe("ArgsExtractConnectionID", func() {
-		It("errors if less than 2 args", func() {
-			_, err := ArgsExtractConnectionID([]string{"arg0", "arg1"})
-			Expect(err).To(Equal(ErrMissingConnectionID))
+		It("disables retries", func() {
+			os.Setenv("CF_FORWARD_RETRIES", "0")
+			Expect(RetryPolicyFromConfig(config.ForwardConfig{})).To(Equal(jumperapi.NoRetries))
 		})
 
-		It("works with 2 args", func() {
-			connectionId, err := ArgsExtractConnectionID([]string{"arg0", "arg1", "arg2"})
-			Expect(err).To(BeNil())
-			Expect(connectionId).To(Equal("arg2"))
+		It("uses the retries setting", func() {
+			retryPolicy := RetryPolicyFromConfig(config.ForwardConfig{Settings: map[string]string{config.SettingRetries: "5"}})
+			Expect(retryPolicy.MaxRetries).To(Equal(5))
+		})
+
+		It("rejects invalid values", func() {
+			os.Setenv("CF_FORWARD_RETRIES", "many")
+			Expect(config.ForwardConfig{}.Validate()).ToNot(Succeed())
 		})
 	})
 
@@ -41,14 +44,14 @@ var _ = Describe("main", func() {
 		It("returns service jumper endpoint", func() {
 			fakeEndpointServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 				if r.Method != "GET" {
-					panic(fmt.Sprintf("fake endpoint server: verb GET expected", r.Method))
+					panic(fmt.Sprintf("fake endpoint server: verb GET expected, got %s", r.Method))
 				}
 
 				jsonStr := `{ "name": "Anynines", "custom": { "service_jumper_endpoint": "https://service-jumper.de.a9sservice.eu" } }`
 				fmt.Fprintln(w, jsonStr)
 			}))
 
-			sjEndpoint, err := FetchCfServiceJumperAPIEndpointFromInfo(fakeEndpointServer.URL, false)
+			sjEndpoint, err := FetchCfServiceJumperAPIEndpointFromInfo(fakeEndpointServer.URL, NewHttpClient(nil, time.Second, nil, nil))
 			Expect(err).To(BeNil())
 			Expect(sjEndpoint).To(Equal("https://service-jumper.de.a9sservice.eu"))
 		})
@@ -59,7 +62,7 @@ var _ = Describe("main", func() {
 				fmt.Fprintln(w, jsonStr)
 			}))
 
-			_, err := FetchCfServiceJumperAPIEndpointFromInfo(fakeEndpointServer.URL, false)
+			_, err := FetchCfServiceJumperAPIEndpointFromInfo(fakeEndpointServer.URL, NewHttpClient(nil, time.Second, nil, nil))
 			Expect(err).ToNot(BeNil())
 		})
 
@@ -69,7 +72,7 @@ var _ = Describe("main", func() {
 				fmt.Fprintln(w, jsonStr)
 			}))
 
-			_, err := FetchCfServiceJumperAPIEndpointFromInfo(fakeEndpointServer.URL, false)
+			_, err := FetchCfServiceJumperAPIEndpointFromInfo(fakeEndpointServer.URL, NewHttpClient(nil, time.Second, nil, nil))
 			Expect(err).To(Equal(ErrCfServiceJumperEndpointNotPresent))
 		})
 	})
@@ -90,37 +93,6 @@ var _ = Describe("main", func() {
 		})
 	})
 
-	Describe("create forward", func() {
-		It("returns ForwardDataSet", func() {
-			fakeServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-				if r.Method != "POST" {
-					panic(fmt.Sprintf("fake server: verb POST expected", r.Method))
-				}
-
-				jsonStr := `{ "public_uris": ["10.100.0.60:27017", "10.100.0.61:27017"], "credentials": { "credentials": { "username": "the_username" } }, "shared_secret": "luser:01234567890123456789", "id": 1234 }`
-				fmt.Fprintln(w, jsonStr)
-			}))
-			p := CfServiceJumperPlugin{
-				CfServiceJumperAPIEndpoint: fakeServer.URL,
-			}
-
-			forwardDataSet, err := p.CreateForward("serviceGuid")
-			Expect(err).To(BeNil())
-
-			expectedForwardDataSet := ForwardDataSet{
-				ID:           1234,
-				Hosts:        []string{"10.100.0.60:27017", "10.100.0.61:27017"},
-				SharedSecret: "luser:01234567890123456789",
-				Credentials: ForwardCredentials{
-					Credentials: ForwardSbCredentials{
-						"username": "the_username",
-					},
-				},
-			}
-			Expect(forwardDataSet).To(Equal(expectedForwardDataSet))
-		})
-	})
-
 	Describe("ForwardDataSet", func() {
 		Describe("CredentialsMap", func() {
 			It("return a human readble string", func() {
@@ -142,6 +114,46 @@ var _ = Describe("main", func() {
 				Expect(credentials["default_database"]).To(Equal("defaultdatabase"))
 				Expect(credentials["database"]).To(Equal("database"))
 			})
+
+			It("flattens nested and non-scalar credentials", func() {
+				fds := ForwardDataSet{
+					Credentials: ForwardCredentials{
+						Credentials: ForwardSbCredentials{
+							"port":  float64(5432),
+							"big":   float64(12345678),
+							"ratio": 0.5,
+							"ssl":   map[string]interface{}{"enabled": true, "cacrt": "the_cert"},
+							"hosts": []interface{}{"10.0.0.1", "10.0.0.2"},
+							"nodes": []interface{}{
+								map[string]interface{}{"host": "10.0.0.1"},
+							},
+							"empty": nil,
+						},
+					},
+				}
+				credentials := fds.CredentialsMap()
+				Expect(credentials["port"]).To(Equal("5432"))
+				Expect(credentials["big"]).To(Equal("12345678"))
+				Expect(credentials["ratio"]).To(Equal("0.5"))
+				Expect(credentials["ssl.enabled"]).To(Equal("true"))
+				Expect(credentials["ssl.cacrt"]).To(Equal("the_cert"))
+				Expect(credentials["hosts"]).To(Equal("10.0.0.1,10.0.0.2"))
+				Expect(credentials["nodes.0.host"]).To(Equal("10.0.0.1"))
+				Expect(credentials["empty"]).To(Equal(""))
+			})
+		})
+
+		Describe("EnvVars", func() {
+			It("returns sorted dotenv lines", func() {
+				credentials := ForwardSbCredentials{
+					"username": "the_user",
+					"ssl":      map[string]interface{}{"cacrt": "line1\nline2"},
+				}
+				Expect(credentials.EnvVars()).To(Equal([]string{
+					`SSL_CACRT="line1\nline2"`,
+					"USERNAME=the_user",
+				}))
+			})
 		})
 	})
 })
@@ -1,15 +1,22 @@
 package main
 
 import (
+	"bytes"
+	"encoding/json"
 	"fmt"
-	"os"
+	"io"
+	"net"
+	"sort"
 	"strconv"
 	"strings"
+	"text/template"
+	"time"
 
+	"github.com/anynines/cf_service_jumper_cli_plugin/plugin/config"
 	"github.com/olekukonko/tablewriter"
 )
 
-func OutputForwardDataSets(forwardDataSetCollection []ForwardDataSet) {
+func OutputForwardDataSets(out io.Writer, forwardDataSetCollection []ForwardDataSet) {
 	data := make([][]string, len(forwardDataSetCollection))
 	for index, forwardDataSet := range forwardDataSetCollection {
 		info := []string{
@@ -19,7 +26,7 @@ func OutputForwardDataSets(forwardDataSetCollection []ForwardDataSet) {
 		data[index] = info
 	}
 
-	table := tablewriter.NewWriter(os.Stdout)
+	table := tablewriter.NewWriter(out)
 	table.SetHeader([]string{"ID"})
 	for _, v := range data {
 		table.Append(v)
@@ -27,36 +34,162 @@ func OutputForwardDataSets(forwardDataSetCollection []ForwardDataSet) {
 	table.Render()
 }
 
-func OutputSampleCmds(sampleCmds []string) {
+// OutputEndpointAttempts shows the tried service jumper endpoint sources
+func OutputEndpointAttempts(out io.Writer, cfAPIEndpoint string, attempts []EndpointAttempt) {
+	fmt.Fprintf(out, "Service jumper endpoint discovery for %s:\n", cfAPIEndpoint)
+
+	table := tablewriter.NewWriter(out)
+	table.SetHeader([]string{"Source", "Endpoint", "Result", "Time"})
+	for _, attempt := range attempts {
+		result := "ok"
+		if attempt.Err != nil {
+			result = attempt.Err.Error()
+		}
+		duration := ""
+		if attempt.Duration > 0 {
+			duration = attempt.Duration.Round(time.Millisecond).String()
+		}
+		table.Append([]string{string(attempt.Source), attempt.Endpoint, result, duration})
+	}
+	table.Render()
+}
+
+// OutputJSON prints v as indented json for --output json
+func OutputJSON(out io.Writer, v interface{}) error {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(out, string(data))
+	return nil
+}
+
+// OutputForwardDataSetsJSON prints the forwards without secrets as json
+func OutputForwardDataSetsJSON(out io.Writer, forwardDataSetCollection []ForwardDataSet) error {
+	forwards := make([]map[string]interface{}, len(forwardDataSetCollection))
+	for i, forwardDataSet := range forwardDataSetCollection {
+		forwards[i] = map[string]interface{}{
+			"id":    forwardDataSet.ID,
+			"hosts": forwardDataSet.Hosts,
+		}
+	}
+	return OutputJSON(out, forwards)
+}
+
+func OutputSampleCmds(out io.Writer, sampleCmds []string) {
 	if len(sampleCmds) < 1 {
 		return
 	}
 
-	fmt.Printf("\nYou can connect to the service using the following command(s):\n")
+	fmt.Fprintf(out, "\nYou can connect to the service using the following command(s):\n")
 	for _, sampleOutput := range sampleCmds {
-		fmt.Println(sampleOutput)
+		fmt.Fprintln(out, sampleOutput)
 	}
 
 }
 
+// OutputCredentials prints the credentials sorted by key. Addresses are left
+// out since they point to the remote service.
+func OutputCredentials(out io.Writer, credentials map[string]string) {
+	keys := make([]string, 0, len(credentials))
+	for credentialKey := range credentials {
+		if stringInStrSlice(credentialKey, []string{"uri", "host", "hosts", "port"}) {
+			continue
+		}
+		keys = append(keys, credentialKey)
+	}
+	sort.Strings(keys)
+
+	fmt.Fprintln(out, "\nCredentials:")
+	for _, credentialKey := range keys {
+		fmt.Fprintf(out, "%s: %s\n", credentialKey, credentials[credentialKey])
+	}
+	fmt.Fprintf(out, "\n")
+}
+
+// OutputConnectionStrings prints the connection strings.
+func OutputConnectionStrings(out io.Writer, connectionStrings []string) {
+	fmt.Fprintf(out, "\nConnection string(s):\n")
+	for _, connectionString := range connectionStrings {
+		fmt.Fprintln(out, connectionString)
+	}
+}
+
 type ConnectionPrinter interface {
 	SampleCallOutput(localListenAddress string) string
 }
 
+// SampleCallOutputs returns the sample calls for all local listen addresses.
+func SampleCallOutputs(connectionPrinter ConnectionPrinter, localListenAddresses []string) []string {
+	var sampleOutputs []string
+	for _, localListenAddress := range localListenAddresses {
+		sampleOutput := connectionPrinter.SampleCallOutput(localListenAddress)
+		if len(sampleOutput) > 0 {
+			sampleOutputs = append(sampleOutputs, sampleOutput)
+		}
+	}
+	return sampleOutputs
+}
+
 type ConnectionPrinterCredentials map[string]string
 
-func SelectConnectionPrinter(credentials map[string]string) ConnectionPrinter {
-	if strings.HasPrefix(credentials["uri"], "mongodb://") {
+func SelectConnectionPrinter(serviceType ServiceType, credentials map[string]string) ConnectionPrinter {
+	switch serviceType {
+	case ServiceTypeMongodb:
 		return MongodbConnectionPrinter{ConnectionPrinterCredentials: credentials}
-	} else if strings.HasPrefix(credentials["uri"], "postgres://") {
+	case ServiceTypePostgres:
 		return PostgresConnectionPrinter{ConnectionPrinterCredentials: credentials}
-	} else if strings.HasPrefix(credentials["uri"], "amqp://") {
+	case ServiceTypeRabbitMQ:
 		return RabbitMQConnectionPrinter{ConnectionPrinterCredentials: credentials}
+	case ServiceTypeRedis:
+		return RedisConnectionPrinter{ConnectionPrinterCredentials: credentials}
+	case ServiceTypeMariaDB:
+		return MariadbConnectionPrinter{ConnectionPrinterCredentials: credentials}
 	}
 
 	return DefaultConnectionPrinter{ConnectionPrinterCredentials: credentials}
 }
 
+// connectionPrinter returns the printer of the service type. Templates of the
+// printer.<service type> settings take precedence over the built-in printers.
+func (c *CfServiceJumperPlugin) connectionPrinter(serviceType ServiceType, credentials map[string]string) ConnectionPrinter {
+	printerKey := "default"
+	if serviceType != ServiceTypeUnknown {
+		printerKey = string(serviceType)
+	}
+
+	if text := c.forwardConfig.Value(config.SettingPrinterPrefix + printerKey); len(text) > 0 {
+		tmpl, err := template.New(printerKey).Parse(text)
+		if err == nil {
+			return TemplateConnectionPrinter{Template: tmpl, ConnectionPrinterCredentials: credentials}
+		}
+	}
+	return SelectConnectionPrinter(serviceType, credentials)
+}
+
+// TemplateConnectionPrinter renders sample calls of a user defined template.
+// The template gets the local Host, Port and Address and the Credentials.
+type TemplateConnectionPrinter struct {
+	Template *template.Template
+	ConnectionPrinterCredentials
+}
+
+func (d TemplateConnectionPrinter) SampleCallOutput(localListenAddress string) string {
+	host, port, _ := net.SplitHostPort(localListenAddress)
+
+	var sampleCall bytes.Buffer
+	err := d.Template.Execute(&sampleCall, map[string]interface{}{
+		"Host":        host,
+		"Port":        port,
+		"Address":     localListenAddress,
+		"Credentials": map[string]string(d.ConnectionPrinterCredentials),
+	})
+	if err != nil {
+		return fmt.Sprintf("[ERR] Failed to render sample call template. %s", err)
+	}
+	return sampleCall.String()
+}
+
 type DefaultConnectionPrinter struct {
 	ConnectionPrinterCredentials
 }
@@ -91,3 +224,25 @@ type RabbitMQConnectionPrinter struct {
 func (d RabbitMQConnectionPrinter) SampleCallOutput(localListenAddress string) string {
 	return "" // do nothing since there is no cmd line tool we might use at the moment
 }
+
+type RedisConnectionPrinter struct {
+	ConnectionPrinterCredentials
+}
+
+func (d RedisConnectionPrinter) SampleCallOutput(localListenAddress string) string {
+	host := strings.Split(localListenAddress, ":")[0]
+	port := strings.Split(localListenAddress, ":")[1]
+
+	return "REDISCLI_AUTH=" + d.ConnectionPrinterCredentials["password"] + " redis-cli -h " + host + " -p " + port
+}
+
+type MariadbConnectionPrinter struct {
+	ConnectionPrinterCredentials
+}
+
+func (d MariadbConnectionPrinter) SampleCallOutput(localListenAddress string) string {
+	host := strings.Split(localListenAddress, ":")[0]
+	port := strings.Split(localListenAddress, ":")[1]
+
+	return "MYSQL_PWD=" + d.ConnectionPrinterCredentials["password"] + " mysql -h " + host + " -P " + port + " -u " + d.ConnectionPrinterCredentials["username"] + " " + d.ConnectionPrinterCredentials["name"]
+}
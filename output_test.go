@@ -1,6 +1,8 @@
 package main_test
 
 import (
+	"text/template"
+
 	. "github.com/anynines/cf_service_jumper_cli_plugin"
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
@@ -53,3 +55,41 @@ var _ = Describe("RabbitMQConnectionPrinter", func() {
 		})
 	})
 })
+
+var _ = Describe("RedisConnectionPrinter", func() {
+	Describe("SampleCallOutput", func() {
+		It("returns cmd", func() {
+			cp := RedisConnectionPrinter{
+				ConnectionPrinterCredentials: map[string]string{
+					"password": "the_password",
+				},
+			}
+
+			Expect(cp.SampleCallOutput("localhost:56789")).To(Equal("REDISCLI_AUTH=the_password redis-cli -h localhost -p 56789"))
+		})
+	})
+})
+
+var _ = Describe("SelectConnectionPrinter", func() {
+	It("selects printer by service type", func() {
+		Expect(SelectConnectionPrinter(ServiceTypePostgres, map[string]string{})).To(BeAssignableToTypeOf(PostgresConnectionPrinter{}))
+		Expect(SelectConnectionPrinter(ServiceTypeMariaDB, map[string]string{})).To(BeAssignableToTypeOf(MariadbConnectionPrinter{}))
+		Expect(SelectConnectionPrinter(ServiceTypeUnknown, map[string]string{})).To(BeAssignableToTypeOf(DefaultConnectionPrinter{}))
+	})
+})
+
+var _ = Describe("TemplateConnectionPrinter", func() {
+	Describe("SampleCallOutput", func() {
+		It("renders the template", func() {
+			cp := TemplateConnectionPrinter{
+				Template: template.Must(template.New("postgresql").Parse(`pgcli postgres://{{.Credentials.username}}@{{.Host}}:{{.Port}}/{{index .Credentials "name"}} # {{.Address}}`)),
+				ConnectionPrinterCredentials: map[string]string{
+					"username": "the_username",
+					"name":     "the_db",
+				},
+			}
+
+			Expect(cp.SampleCallOutput("localhost:56789")).To(Equal("pgcli postgres://the_username@localhost:56789/the_db # localhost:56789"))
+		})
+	})
+})
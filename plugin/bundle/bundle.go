@@ -0,0 +1,147 @@
+package bundle
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/anynines/cf_service_jumper_cli_plugin/plugin/redact"
+)
+
+type file struct {
+	name    string
+	content func() []byte
+}
+
+// Bundle collects files for support tickets and writes them redacted to a
+// tar.gz archive. All methods are safe for concurrent use.
+type Bundle struct {
+	mutex   sync.Mutex
+	files   []file
+	created time.Time
+}
+
+// New returns an empty bundle
+func New() *Bundle {
+	return &Bundle{created: time.Now()}
+}
+
+// Add adds a file with content
+func (b *Bundle) Add(name string, content []byte) {
+	b.add(name, func() []byte { return content })
+}
+
+// AddJSON adds a file with v encoded as indented json
+func (b *Bundle) AddJSON(name string, v interface{}) error {
+	content, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return fmt.Errorf("[ERR] debug bundle: failed to encode %s. %s", name, err)
+	}
+	b.Add(name, append(content, '\n'))
+	return nil
+}
+
+// Writer returns a writer whose content is added as file, e.g. for logs
+// written while the command runs
+func (b *Bundle) Writer(name string) *Buffer {
+	buffer := &Buffer{}
+	b.add(name, buffer.Bytes)
+	return buffer
+}
+
+func (b *Bundle) add(name string, content func() []byte) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.files = append(b.files, file{name: name, content: content})
+}
+
+// WriteFile writes the redacted files to a tar.gz archive readable by the
+// owner only. The files are placed in a directory named after the file.
+func (b *Bundle) WriteFile(filePath string) error {
+	b.mutex.Lock()
+	files := append([]file{}, b.files...)
+	b.mutex.Unlock()
+
+	archive := &bytes.Buffer{}
+	gzipWriter := gzip.NewWriter(archive)
+	tarWriter := tar.NewWriter(gzipWriter)
+	dir := Dir(filePath)
+	for _, file := range files {
+		content := []byte(redact.String(string(file.content())))
+		err := tarWriter.WriteHeader(&tar.Header{
+			Name:    path.Join(dir, file.name),
+			Mode:    0600,
+			Size:    int64(len(content)),
+			ModTime: b.created,
+		})
+		if err == nil {
+			_, err = tarWriter.Write(content)
+		}
+		if err != nil {
+			return fmt.Errorf("[ERR] debug bundle: failed to add %s. %s", file.name, err)
+		}
+	}
+	err := tarWriter.Close()
+	if err == nil {
+		err = gzipWriter.Close()
+	}
+	if err == nil {
+		err = writeFile(filePath, archive.Bytes())
+	}
+	if err != nil {
+		return fmt.Errorf("[ERR] debug bundle: failed to write %s. %s", filePath, err)
+	}
+	return nil
+}
+
+func writeFile(filePath string, data []byte) error {
+	file, err := os.OpenFile(filePath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	_, err = file.Write(data)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// Dir returns the directory of the files in the archive, e.g. forward-debug
+// for forward-debug.tar.gz
+func Dir(filePath string) string {
+	name := filepath.Base(filePath)
+	for _, extension := range []string{".gz", ".tgz", ".tar"} {
+		name = strings.TrimSuffix(name, extension)
+	}
+	if len(name) < 1 || name == "." {
+		return "forward-debug"
+	}
+	return name
+}
+
+// Buffer is a bytes.Buffer safe for concurrent use
+type Buffer struct {
+	mutex  sync.Mutex
+	buffer bytes.Buffer
+}
+
+func (b *Buffer) Write(p []byte) (int, error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return b.buffer.Write(p)
+}
+
+// Bytes returns a copy of the written bytes
+func (b *Buffer) Bytes() []byte {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return append([]byte{}, b.buffer.Bytes()...)
+}
@@ -0,0 +1,79 @@
+package bundle_test
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	. "github.com/anynines/cf_service_jumper_cli_plugin/plugin/bundle"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// readArchive returns the files of a tar.gz by name
+func readArchive(filePath string) map[string]string {
+	file, err := os.Open(filePath)
+	Expect(err).To(BeNil())
+	defer file.Close()
+	gzipReader, err := gzip.NewReader(file)
+	Expect(err).To(BeNil())
+
+	files := make(map[string]string)
+	tarReader := tar.NewReader(gzipReader)
+	for {
+		header, err := tarReader.Next()
+		if err != nil {
+			break
+		}
+		content, err := ioutil.ReadAll(tarReader)
+		Expect(err).To(BeNil())
+		files[header.Name] = string(content)
+	}
+	return files
+}
+
+var _ = Describe("Bundle", func() {
+	var dir string
+
+	BeforeEach(func() {
+		var err error
+		dir, err = ioutil.TempDir("", "bundle")
+		Expect(err).To(BeNil())
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(dir)
+	})
+
+	It("writes redacted files to a tar.gz readable by the owner only", func() {
+		b := New()
+		b.Add("forward.json", []byte(`{"password": "secret"}`))
+		Expect(b.AddJSON("versions.json", map[string]string{"plugin": "2.0.0"})).To(Succeed())
+		log := b.Writer("forward.log")
+		fmt.Fprintln(log, "GET postgres://user:secret@pg:5432/db")
+		fmt.Fprintln(log, "Authorization: bearer eyJhbGciOi")
+
+		filePath := filepath.Join(dir, "support.tar.gz")
+		Expect(b.WriteFile(filePath)).To(Succeed())
+
+		info, err := os.Stat(filePath)
+		Expect(err).To(BeNil())
+		Expect(info.Mode().Perm()).To(Equal(os.FileMode(0600)))
+
+		files := readArchive(filePath)
+		Expect(files).To(HaveLen(3))
+		Expect(files["support/forward.json"]).To(Equal(`{"password": "[PRIVATE DATA HIDDEN]"}`))
+		Expect(files["support/versions.json"]).To(ContainSubstring(`"plugin": "2.0.0"`))
+		Expect(files["support/forward.log"]).To(ContainSubstring("GET postgres://user:[PRIVATE DATA HIDDEN]@pg:5432/db"))
+		Expect(files["support/forward.log"]).ToNot(ContainSubstring("eyJhbGciOi"))
+	})
+
+	It("names the directory after the file", func() {
+		Expect(Dir("/tmp/support.tar.gz")).To(Equal("support"))
+		Expect(Dir("support.tgz")).To(Equal("support"))
+		Expect(Dir("bundle")).To(Equal("bundle"))
+	})
+})
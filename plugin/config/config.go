@@ -3,26 +3,71 @@ package config
 import (
 	"encoding/json"
 	"errors"
+	"fmt"
 	"io/ioutil"
+	"os"
 	"path/filepath"
+	"strings"
 
 	"github.com/cloudfoundry/cli/cf/configuration/confighelpers"
 )
 
+// SchemaVersion of forward.json written by this plugin version. Files without
+// a version are version 1.
+const SchemaVersion = 2
+
 var (
-	ErrForwardConfigMissing = errors.New("[ERR] forward.json not found")
-	ErrTargetBlank          = errors.New("[ERR] target not present")
+	ErrTargetBlank = errors.New("[ERR] target not present")
+	ErrNewerSchema = fmt.Errorf("[ERR] forward.json was written by a newer plugin version. Supported schema version: %d", SchemaVersion)
 )
 
 type ForwardConfig struct {
-	Target string `json:"target"`
+	Version int `json:"version"`
+	// Targets maps cf api endpoints to service jumper endpoints set by 'cf forward-api'
+	Targets map[string]string `json:"targets,omitempty"`
+	// DiscoveredTargets caches discovered service jumper endpoints per cf api endpoint
+	DiscoveredTargets map[string]string `json:"discovered_targets,omitempty"`
+	// Settings set by 'cf forward-config', see Settings for the known keys
+	Settings map[string]string `json:"settings,omitempty"`
 }
 
 func newForwardConfig() ForwardConfig {
-	return ForwardConfig{}
+	return ForwardConfig{Version: SchemaVersion}
+}
+
+// APIKey normalizes cf api endpoints used as keys of Targets and DiscoveredTargets
+func APIKey(cfAPIEndpoint string) string {
+	return strings.TrimSuffix(strings.ToLower(cfAPIEndpoint), "/")
+}
+
+// TargetFor returns the service jumper endpoint set for the cf api. CF_FORWARD_TARGET
+// takes precedence, the target setting is the fallback of all cf apis.
+func (c ForwardConfig) TargetFor(cfAPIEndpoint string) string {
+	target, source := c.Lookup(SettingTarget)
+	if source == SourceEnv {
+		return target
+	}
+	if apiTarget := c.Targets[APIKey(cfAPIEndpoint)]; len(apiTarget) > 0 {
+		return apiTarget
+	}
+	return target
 }
 
+// DiscoveredTargetFor returns the cached service jumper endpoint of the cf api
+func (c ForwardConfig) DiscoveredTargetFor(cfAPIEndpoint string) string {
+	return c.DiscoveredTargets[APIKey(cfAPIEndpoint)]
+}
+
+// DefaultFilePath returns the path of forward.json. CF_FORWARD_CONFIG takes
+// precedence over the .cf directory in CF_PLUGIN_HOME, CF_HOME and the home directory.
 func DefaultFilePath() (string, error) {
+	if filePath := os.Getenv("CF_FORWARD_CONFIG"); len(filePath) > 0 {
+		return filePath, nil
+	}
+	if pluginHome := os.Getenv("CF_PLUGIN_HOME"); len(pluginHome) > 0 {
+		return filepath.Join(pluginHome, ".cf", "forward.json"), nil
+	}
+
 	defaultFilePath, err := confighelpers.DefaultFilePath()
 	if err != nil {
 		return "", err
@@ -30,43 +75,140 @@ func DefaultFilePath() (string, error) {
 	return filepath.Join(filepath.Dir(defaultFilePath), "forward.json"), nil
 }
 
-func GetConfig() (ForwardConfig, error) {
-	var forwardConfig ForwardConfig
+// LogFilePath returns the path of forward.log next to forward.json
+func LogFilePath() (string, error) {
+	defaultFilePath, err := DefaultFilePath()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(filepath.Dir(defaultFilePath), "forward.log"), nil
+}
 
+// GetConfig reads forward.json and migrates older schemas. A missing file
+// results in a blank config.
+func GetConfig() (ForwardConfig, error) {
 	defaultFilePath, err := DefaultFilePath()
 	if err != nil {
-		return forwardConfig, err
+		return ForwardConfig{}, err
+	}
+	return readConfig(defaultFilePath)
+}
+
+func readConfig(filePath string) (ForwardConfig, error) {
+	jsonBytes, err := ioutil.ReadFile(filePath)
+	if os.IsNotExist(err) {
+		return newForwardConfig(), nil
+	}
+	if err != nil {
+		return ForwardConfig{}, fmt.Errorf("[ERR] Failed to read %s. %s", filePath, err)
+	}
+
+	forwardConfig, err := migrate(jsonBytes)
+	if err != nil {
+		return forwardConfig, fmt.Errorf("[ERR] Failed to parse %s. %s", filePath, err)
+	}
+	return forwardConfig, nil
+}
+
+// SetTarget sets the service jumper endpoint of the cf api. A blank target
+// removes it together with the target setting of all cf apis.
+func SetTarget(cfAPIEndpoint string, target string) error {
+	return update(func(config *ForwardConfig) error {
+		if len(target) < 1 {
+			delete(config.Targets, APIKey(cfAPIEndpoint))
+			delete(config.Settings, SettingTarget)
+			return nil
+		}
+		if config.Targets == nil {
+			config.Targets = make(map[string]string)
+		}
+		config.Targets[APIKey(cfAPIEndpoint)] = target
+		return nil
+	})
+}
+
+// SetDiscoveredTarget caches the discovered service jumper endpoint of the cf
+// api. A blank target removes it.
+func SetDiscoveredTarget(cfAPIEndpoint string, target string) error {
+	return update(func(config *ForwardConfig) error {
+		if len(target) < 1 {
+			delete(config.DiscoveredTargets, APIKey(cfAPIEndpoint))
+			return nil
+		}
+		if config.DiscoveredTargets == nil {
+			config.DiscoveredTargets = make(map[string]string)
+		}
+		config.DiscoveredTargets[APIKey(cfAPIEndpoint)] = target
+		return nil
+	})
+}
+
+// Set validates and stores the setting
+func Set(key string, value string) error {
+	setting, err := LookupSetting(key)
+	if err != nil {
+		return err
+	}
+	err = setting.Validate(value)
+	if err != nil {
+		return err
 	}
 
-	jsonBytes, err := ioutil.ReadFile(defaultFilePath)
+	return update(func(config *ForwardConfig) error {
+		if config.Settings == nil {
+			config.Settings = make(map[string]string)
+		}
+		config.Settings[key] = value
+		return nil
+	})
+}
+
+// Unset removes the setting so the default applies
+func Unset(key string) error {
+	_, err := LookupSetting(key)
 	if err != nil {
-		return forwardConfig, ErrForwardConfigMissing
+		return err
 	}
 
-	err = json.Unmarshal(jsonBytes, &forwardConfig)
-	return forwardConfig, err
+	return update(func(config *ForwardConfig) error {
+		delete(config.Settings, key)
+		return nil
+	})
 }
 
-func SetTarget(target string) error {
+// update changes forward.json while holding the lock so concurrent plugin
+// invocations don't lose changes
+func update(change func(config *ForwardConfig) error) error {
 	defaultFilePath, err := DefaultFilePath()
 	if err != nil {
 		return err
 	}
+	err = os.MkdirAll(filepath.Dir(defaultFilePath), 0700)
+	if err != nil {
+		return err
+	}
 
-	config, err := GetConfig()
-	if err != nil && err != ErrForwardConfigMissing {
+	unlock, err := lock(defaultFilePath)
+	if err != nil {
 		return err
 	}
-	if err == ErrForwardConfigMissing {
-		config = newForwardConfig()
+	defer unlock()
+
+	config, err := readConfig(defaultFilePath)
+	if err != nil {
+		return err
 	}
 
-	config.Target = target
+	err = change(&config)
+	if err != nil {
+		return err
+	}
 
-	data, err := json.Marshal(&config)
+	config.Version = SchemaVersion
+	data, err := json.MarshalIndent(&config, "", "  ")
 	if err != nil {
 		return err
 	}
 
-	return ioutil.WriteFile(defaultFilePath, data, 0700)
+	return writeFileAtomic(defaultFilePath, data)
 }
@@ -0,0 +1,184 @@
+package config_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	. "github.com/anynines/cf_service_jumper_cli_plugin/plugin/config"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("config", func() {
+	var dir, configFile string
+
+	BeforeEach(func() {
+		var err error
+		dir, err = ioutil.TempDir("", "forward_config")
+		Expect(err).To(BeNil())
+		configFile = filepath.Join(dir, "forward.json")
+		os.Setenv("CF_FORWARD_CONFIG", configFile)
+	})
+
+	AfterEach(func() {
+		os.Unsetenv("CF_FORWARD_CONFIG")
+		os.RemoveAll(dir)
+	})
+
+	Describe("DefaultFilePath", func() {
+		AfterEach(func() {
+			os.Unsetenv("CF_PLUGIN_HOME")
+		})
+
+		It("prefers CF_FORWARD_CONFIG", func() {
+			os.Setenv("CF_PLUGIN_HOME", dir)
+			Expect(DefaultFilePath()).To(Equal(configFile))
+		})
+
+		It("uses CF_PLUGIN_HOME", func() {
+			os.Unsetenv("CF_FORWARD_CONFIG")
+			os.Setenv("CF_PLUGIN_HOME", dir)
+			Expect(DefaultFilePath()).To(Equal(filepath.Join(dir, ".cf", "forward.json")))
+		})
+	})
+
+	Describe("GetConfig", func() {
+		It("returns a blank config if the file is missing", func() {
+			forwardConfig, err := GetConfig()
+			Expect(err).To(BeNil())
+			Expect(forwardConfig.Version).To(Equal(SchemaVersion))
+			Expect(forwardConfig.Value(SettingBindAddress)).To(Equal("localhost"))
+		})
+
+		It("migrates unversioned files", func() {
+			v1Config := `{"target": "https://jumper.example.com", "targets": {"https://api.example.com": "https://other.example.com"}}`
+			Expect(ioutil.WriteFile(configFile, []byte(v1Config), 0700)).To(Succeed())
+
+			forwardConfig, err := GetConfig()
+			Expect(err).To(BeNil())
+			Expect(forwardConfig.Version).To(Equal(SchemaVersion))
+			Expect(forwardConfig.Value(SettingTarget)).To(Equal("https://jumper.example.com"))
+			Expect(forwardConfig.TargetFor("https://api.example.com/")).To(Equal("https://other.example.com"))
+			Expect(forwardConfig.TargetFor("https://api.other.com")).To(Equal("https://jumper.example.com"))
+		})
+
+		It("rejects files of newer plugin versions", func() {
+			Expect(ioutil.WriteFile(configFile, []byte(fmt.Sprintf(`{"version": %d}`, SchemaVersion+1)), 0600)).To(Succeed())
+
+			_, err := GetConfig()
+			Expect(err.Error()).To(ContainSubstring(ErrNewerSchema.Error()))
+		})
+	})
+
+	Describe("Set", func() {
+		It("writes the current schema only readable by the user", func() {
+			Expect(ioutil.WriteFile(configFile, []byte(`{"target": "https://jumper.example.com"}`), 0700)).To(Succeed())
+
+			Expect(Set(SettingAutoDelete, "true")).To(Succeed())
+
+			info, err := os.Stat(configFile)
+			Expect(err).To(BeNil())
+			if os.PathSeparator == '/' {
+				Expect(info.Mode().Perm()).To(Equal(os.FileMode(0600)))
+			}
+
+			data, err := ioutil.ReadFile(configFile)
+			Expect(err).To(BeNil())
+			var fileContent map[string]interface{}
+			Expect(json.Unmarshal(data, &fileContent)).To(Succeed())
+			Expect(fileContent["version"]).To(BeEquivalentTo(SchemaVersion))
+			Expect(fileContent).ToNot(HaveKey("target"))
+
+			forwardConfig, err := GetConfig()
+			Expect(err).To(BeNil())
+			Expect(forwardConfig.Bool(SettingAutoDelete)).To(BeTrue())
+			Expect(forwardConfig.Value(SettingTarget)).To(Equal("https://jumper.example.com"))
+
+			files, err := ioutil.ReadDir(dir)
+			Expect(err).To(BeNil())
+			Expect(files).To(HaveLen(1))
+		})
+
+		It("validates values", func() {
+			Expect(Set(SettingTimeout, "soon")).ToNot(Succeed())
+			Expect(Set(SettingConnectionFormat, "xml")).ToNot(Succeed())
+			Expect(Set(SettingPrinterPrefix+"postgresql", "psql {{.Host")).ToNot(Succeed())
+			Expect(Set("unknown", "value")).To(Equal(ErrUnknownSetting))
+		})
+
+		It("doesn't lose concurrent changes", func() {
+			var wg sync.WaitGroup
+			for i := 0; i < 10; i++ {
+				wg.Add(1)
+				go func(i int) {
+					defer wg.Done()
+					defer GinkgoRecover()
+					Expect(SetDiscoveredTarget(fmt.Sprintf("https://api%d.example.com", i), "https://jumper.example.com")).To(Succeed())
+				}(i)
+			}
+			wg.Wait()
+
+			forwardConfig, err := GetConfig()
+			Expect(err).To(BeNil())
+			Expect(forwardConfig.DiscoveredTargets).To(HaveLen(10))
+		})
+
+		It("removes stale locks", func() {
+			lockFile := configFile + ".lock"
+			Expect(ioutil.WriteFile(lockFile, []byte("1\n"), 0600)).To(Succeed())
+			staleTime := time.Now().Add(-2 * StaleLockAge)
+			Expect(os.Chtimes(lockFile, staleTime, staleTime)).To(Succeed())
+
+			Expect(Set(SettingRetries, "5")).To(Succeed())
+			_, err := os.Stat(lockFile)
+			Expect(os.IsNotExist(err)).To(BeTrue())
+		})
+	})
+
+	Describe("Unset", func() {
+		It("restores the default", func() {
+			Expect(Set(SettingBindAddress, "0.0.0.0")).To(Succeed())
+			Expect(Unset(SettingBindAddress)).To(Succeed())
+
+			forwardConfig, err := GetConfig()
+			Expect(err).To(BeNil())
+			Expect(forwardConfig.Value(SettingBindAddress)).To(Equal("localhost"))
+		})
+	})
+
+	Describe("Lookup", func() {
+		AfterEach(func() {
+			os.Unsetenv("CF_FORWARD_BIND_ADDRESS")
+			os.Unsetenv("CF_FORWARD_TARGET")
+		})
+
+		It("prefers environment variables", func() {
+			forwardConfig := ForwardConfig{Settings: map[string]string{SettingBindAddress: "127.0.0.1"}}
+			value, source := forwardConfig.Lookup(SettingBindAddress)
+			Expect(value).To(Equal("127.0.0.1"))
+			Expect(source).To(Equal(SourceConfig))
+
+			os.Setenv("CF_FORWARD_BIND_ADDRESS", "0.0.0.0")
+			value, source = forwardConfig.Lookup(SettingBindAddress)
+			Expect(value).To(Equal("0.0.0.0"))
+			Expect(source).To(Equal(SourceEnv))
+		})
+
+		It("overrides targets of all cf apis with CF_FORWARD_TARGET", func() {
+			forwardConfig := ForwardConfig{Targets: map[string]string{"https://api.example.com": "https://jumper.example.com"}}
+			os.Setenv("CF_FORWARD_TARGET", "https://env.example.com")
+			Expect(forwardConfig.TargetFor("https://api.example.com")).To(Equal("https://env.example.com"))
+		})
+
+		It("names the environment variable of invalid values", func() {
+			os.Setenv("CF_FORWARD_TARGET", "not a url")
+			err := ForwardConfig{}.Validate()
+			Expect(err.Error()).To(ContainSubstring("CF_FORWARD_TARGET"))
+		})
+	})
+})
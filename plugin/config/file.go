@@ -0,0 +1,76 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+var ErrConfigLocked = errors.New("[ERR] forward.json is locked by another cf forward command. Remove forward.json.lock if no other command is running")
+
+const (
+	// LockTimeout is the maximum time to wait for the lock of forward.json
+	LockTimeout = 5 * time.Second
+	// StaleLockAge after which a lock of a crashed command is removed
+	StaleLockAge = 30 * time.Second
+
+	lockRetryInterval = 50 * time.Millisecond
+)
+
+// lock creates filePath.lock exclusively. The returned func removes it.
+func lock(filePath string) (func(), error) {
+	lockFilePath := filePath + ".lock"
+	deadline := time.Now().Add(LockTimeout)
+
+	for {
+		lockFile, err := os.OpenFile(lockFilePath, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+		if err == nil {
+			fmt.Fprintf(lockFile, "%d\n", os.Getpid())
+			lockFile.Close()
+			return func() { os.Remove(lockFilePath) }, nil
+		}
+		if !os.IsExist(err) {
+			return nil, err
+		}
+
+		info, statErr := os.Stat(lockFilePath)
+		if statErr == nil && time.Since(info.ModTime()) > StaleLockAge {
+			os.Remove(lockFilePath)
+			continue
+		}
+		if time.Now().After(deadline) {
+			return nil, ErrConfigLocked
+		}
+		time.Sleep(lockRetryInterval)
+	}
+}
+
+// writeFileAtomic writes to a temporary file in the same directory and renames
+// it so readers never see partial files. The file is only readable by the user.
+func writeFileAtomic(filePath string, data []byte) error {
+	tmpFile, err := ioutil.TempFile(filepath.Dir(filePath), filepath.Base(filePath)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmpFile.Name())
+
+	err = tmpFile.Chmod(0600)
+	if err == nil {
+		_, err = tmpFile.Write(data)
+	}
+	if err == nil {
+		err = tmpFile.Sync()
+	}
+	closeErr := tmpFile.Close()
+	if err != nil {
+		return err
+	}
+	if closeErr != nil {
+		return closeErr
+	}
+
+	return os.Rename(tmpFile.Name(), filePath)
+}
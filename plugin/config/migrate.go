@@ -0,0 +1,51 @@
+package config
+
+import (
+	"encoding/json"
+)
+
+// migrations[i] migrates the schema version i+1 to i+2
+var migrations = []func(data []byte, config *ForwardConfig) error{
+	migrateV1,
+}
+
+func migrate(data []byte) (ForwardConfig, error) {
+	var forwardConfig ForwardConfig
+	err := json.Unmarshal(data, &forwardConfig)
+	if err != nil {
+		return forwardConfig, err
+	}
+	if forwardConfig.Version < 1 {
+		forwardConfig.Version = 1
+	}
+	if forwardConfig.Version > SchemaVersion {
+		return forwardConfig, ErrNewerSchema
+	}
+
+	for ; forwardConfig.Version < SchemaVersion; forwardConfig.Version++ {
+		err = migrations[forwardConfig.Version-1](data, &forwardConfig)
+		if err != nil {
+			return forwardConfig, err
+		}
+	}
+	return forwardConfig, nil
+}
+
+// migrateV1 moves the top level target into the settings
+func migrateV1(data []byte, config *ForwardConfig) error {
+	var v1Config struct {
+		Target string `json:"target"`
+	}
+	err := json.Unmarshal(data, &v1Config)
+	if err != nil {
+		return err
+	}
+
+	if config.Settings == nil {
+		config.Settings = make(map[string]string)
+	}
+	if len(v1Config.Target) > 0 {
+		config.Settings[SettingTarget] = v1Config.Target
+	}
+	return nil
+}
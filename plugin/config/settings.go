@@ -0,0 +1,216 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// keys of the settings
+const (
+	SettingTarget           = "target"
+	SettingBindAddress      = "bind_address"
+	SettingConnectionFormat = "connection_format"
+	SettingTimeout          = "timeout"
+	SettingRetries          = "retries"
+	SettingAutoDelete       = "auto_delete"
+	SettingCAFile           = "ca_file"
+	SettingPinnedSHA256     = "pinned_sha256"
+	SettingLogFile          = "log_file"
+	SettingLogLevel         = "log_level"
+
+	// SettingPrinterPrefix is followed by the service type, e.g. printer.postgresql
+	SettingPrinterPrefix = "printer."
+)
+
+var ErrUnknownSetting = errors.New("[ERR] unknown setting. 'cf forward-config list' shows all settings")
+
+// SettingType determines how values are validated
+type SettingType int
+
+const (
+	SettingTypeString SettingType = iota
+	SettingTypeURL
+	SettingTypeBool
+	SettingTypeInt
+	SettingTypeDuration
+	// SettingTypeList is a comma separated list
+	SettingTypeList
+	// SettingTypeEnum accepts one of Values
+	SettingTypeEnum
+	// SettingTypeTemplate is a text/template
+	SettingTypeTemplate
+)
+
+// ValueSource tells where the effective value of a setting comes from
+type ValueSource string
+
+const (
+	SourceDefault ValueSource = "default"
+	SourceConfig  ValueSource = "config"
+	SourceEnv     ValueSource = "env"
+)
+
+// Setting describes a setting of forward.json
+type Setting struct {
+	Key         string
+	Type        SettingType
+	Default     string
+	Values      []string
+	Description string
+}
+
+// Settings known by 'cf forward-config'
+var Settings = []Setting{
+	{Key: SettingTarget, Type: SettingTypeURL, Description: "service jumper endpoint of all cf apis, 'cf forward-api' sets it per cf api"},
+	{Key: SettingBindAddress, Type: SettingTypeString, Default: "localhost", Description: "local address the tunnels listen on"},
+	{Key: SettingConnectionFormat, Type: SettingTypeEnum, Default: "uri", Values: []string{"uri", "jdbc", "spring", "dsn", "env"}, Description: "default --format of forward-env"},
+	{Key: SettingTimeout, Type: SettingTypeDuration, Default: "30s", Description: "timeout of a single api request"},
+	{Key: SettingRetries, Type: SettingTypeInt, Default: "3", Description: "retries of transient service jumper failures, 0 disables retries"},
+	{Key: SettingAutoDelete, Type: SettingTypeBool, Default: "false", Description: "delete forwards when create-forward and forward-env exit"},
+	{Key: SettingCAFile, Type: SettingTypeString, Description: "PEM bundle trusted in addition to the system roots"},
+	{Key: SettingPinnedSHA256, Type: SettingTypeList, Description: "comma separated base64 sha256 hashes of the service jumper's public keys"},
+	{Key: SettingLogFile, Type: SettingTypeBool, Default: "false", Description: "write a rotating forward.log next to forward.json"},
+	{Key: SettingLogLevel, Type: SettingTypeEnum, Default: "debug", Values: []string{"error", "warn", "info", "debug"}, Description: "level of forward.log"},
+	printerSetting("default"),
+	printerSetting("postgresql"),
+	printerSetting("mongodb"),
+	printerSetting("rabbitmq"),
+	printerSetting("redis"),
+	printerSetting("mariadb"),
+	printerSetting("elasticsearch"),
+}
+
+func printerSetting(serviceType string) Setting {
+	return Setting{
+		Key:         SettingPrinterPrefix + serviceType,
+		Type:        SettingTypeTemplate,
+		Description: fmt.Sprintf("sample command template of %s services, e.g. {{.Host}} {{.Port}} {{.Credentials.username}}", serviceType),
+	}
+}
+
+// LookupSetting returns the setting of key
+func LookupSetting(key string) (Setting, error) {
+	for _, setting := range Settings {
+		if setting.Key == key {
+			return setting, nil
+		}
+	}
+	return Setting{}, ErrUnknownSetting
+}
+
+// EnvVar returns the environment variable overriding the setting, e.g. CF_FORWARD_BIND_ADDRESS
+func (s Setting) EnvVar() string {
+	return "CF_FORWARD_" + strings.ToUpper(strings.Replace(s.Key, ".", "_", -1))
+}
+
+// Validate checks that value is valid for the setting. Blank values are always valid.
+func (s Setting) Validate(value string) error {
+	if len(value) < 1 {
+		return nil
+	}
+
+	var err error
+	switch s.Type {
+	case SettingTypeURL:
+		_, err = url.ParseRequestURI(value)
+	case SettingTypeBool:
+		_, err = strconv.ParseBool(value)
+	case SettingTypeInt:
+		var number int
+		number, err = strconv.Atoi(value)
+		if err == nil && number < 0 {
+			err = errors.New("must not be negative")
+		}
+	case SettingTypeDuration:
+		var duration time.Duration
+		duration, err = time.ParseDuration(value)
+		if err == nil && duration <= 0 {
+			err = errors.New("must be positive")
+		}
+	case SettingTypeEnum:
+		err = fmt.Errorf("must be one of %s", strings.Join(s.Values, ", "))
+		for _, allowed := range s.Values {
+			if value == allowed {
+				err = nil
+			}
+		}
+	case SettingTypeTemplate:
+		_, err = template.New(s.Key).Parse(value)
+	}
+
+	if err != nil {
+		return fmt.Errorf("[ERR] invalid value %q for %s. %s", value, s.Key, err)
+	}
+	return nil
+}
+
+// Lookup returns the effective value of the setting: the environment variable,
+// forward.json or the default.
+func (c ForwardConfig) Lookup(key string) (string, ValueSource) {
+	setting, _ := LookupSetting(key)
+
+	if value := os.Getenv(setting.EnvVar()); len(value) > 0 {
+		return value, SourceEnv
+	}
+	if value, ok := c.Settings[key]; ok && len(value) > 0 {
+		return value, SourceConfig
+	}
+	return setting.Default, SourceDefault
+}
+
+// Value returns the effective value of the setting
+func (c ForwardConfig) Value(key string) string {
+	value, _ := c.Lookup(key)
+	return value
+}
+
+// Bool returns the effective value of a bool setting. Invalid values are false.
+func (c ForwardConfig) Bool(key string) bool {
+	value, _ := strconv.ParseBool(c.Value(key))
+	return value
+}
+
+// Int returns the effective value of an int setting. Invalid values are 0.
+func (c ForwardConfig) Int(key string) int {
+	value, _ := strconv.Atoi(c.Value(key))
+	return value
+}
+
+// Duration returns the effective value of a duration setting. Invalid values are 0.
+func (c ForwardConfig) Duration(key string) time.Duration {
+	value, _ := time.ParseDuration(c.Value(key))
+	return value
+}
+
+// List returns the effective value of a list setting
+func (c ForwardConfig) List(key string) []string {
+	var values []string
+	for _, value := range strings.Split(c.Value(key), ",") {
+		if value = strings.TrimSpace(value); len(value) > 0 {
+			values = append(values, value)
+		}
+	}
+	return values
+}
+
+// Validate checks the effective values of all settings, e.g. after they were
+// edited by hand or overridden by environment variables
+func (c ForwardConfig) Validate() error {
+	for _, setting := range Settings {
+		value, source := c.Lookup(setting.Key)
+		err := setting.Validate(value)
+		if err != nil && source == SourceEnv {
+			return fmt.Errorf("%s Set by %s", err, setting.EnvVar())
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
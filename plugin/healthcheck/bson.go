@@ -0,0 +1,117 @@
+package healthcheck
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"math"
+)
+
+// bsonDocument joins elements to a BSON document
+func bsonDocument(elements ...[]byte) []byte {
+	content := bytes.Join(elements, nil)
+	document := make([]byte, 4, 4+len(content)+1)
+	binary.LittleEndian.PutUint32(document, uint32(4+len(content)+1))
+	document = append(document, content...)
+	return append(document, 0)
+}
+
+// bsonElement encodes an element of type kind with an encoded value
+func bsonElement(kind byte, name string, value []byte) []byte {
+	element := append([]byte{kind}, name...)
+	element = append(element, 0)
+	return append(element, value...)
+}
+
+// bsonString encodes the value of a string element
+func bsonString(s string) []byte {
+	value := make([]byte, 4, 4+len(s)+1)
+	binary.LittleEndian.PutUint32(value, uint32(len(s)+1))
+	value = append(value, s...)
+	return append(value, 0)
+}
+
+var errInvalidBSON = errors.New("invalid BSON document")
+
+// bsonFields decodes the top level fields of a document. Numbers are returned
+// as float64, strings and booleans as such and other types are left out.
+func bsonFields(document []byte) (map[string]interface{}, error) {
+	if len(document) < 5 {
+		return nil, errInvalidBSON
+	}
+	length := int(binary.LittleEndian.Uint32(document))
+	if length < 5 || length > len(document) {
+		return nil, errInvalidBSON
+	}
+	fields := make(map[string]interface{})
+	rest := document[4 : length-1]
+	for len(rest) > 0 {
+		kind := rest[0]
+		end := bytes.IndexByte(rest[1:], 0)
+		if end < 0 {
+			return nil, errInvalidBSON
+		}
+		name := string(rest[1 : end+1])
+		rest = rest[end+2:]
+
+		size, value := bsonValue(kind, rest)
+		if size < 0 || size > len(rest) {
+			return nil, errInvalidBSON
+		}
+		if value != nil {
+			fields[name] = value
+		}
+		rest = rest[size:]
+	}
+	return fields, nil
+}
+
+// bsonValue returns the size of the value of type kind at the start of b and
+// the decoded value if supported. A negative size is returned for unknown types.
+func bsonValue(kind byte, b []byte) (int, interface{}) {
+	lengthPrefixed := func(extra int) int {
+		if len(b) < 4 {
+			return -1
+		}
+		return int(int32(binary.LittleEndian.Uint32(b))) + extra
+	}
+	switch kind {
+	case 0x01: // double
+		if len(b) < 8 {
+			return -1, nil
+		}
+		return 8, math.Float64frombits(binary.LittleEndian.Uint64(b))
+	case 0x02: // string
+		size := lengthPrefixed(4)
+		if size < 5 || size > len(b) {
+			return -1, nil
+		}
+		return size, string(b[4 : size-1])
+	case 0x03, 0x04: // document, array
+		return lengthPrefixed(0), nil
+	case 0x05: // binary
+		return lengthPrefixed(5), nil
+	case 0x07: // object id
+		return 12, nil
+	case 0x08: // boolean
+		if len(b) < 1 {
+			return -1, nil
+		}
+		return 1, b[0] == 1
+	case 0x09, 0x11, 0x12: // datetime, timestamp, int64
+		if kind == 0x12 && len(b) >= 8 {
+			return 8, float64(int64(binary.LittleEndian.Uint64(b)))
+		}
+		return 8, nil
+	case 0x0A: // null
+		return 0, nil
+	case 0x10: // int32
+		if len(b) < 4 {
+			return -1, nil
+		}
+		return 4, float64(int32(binary.LittleEndian.Uint32(b)))
+	case 0x13: // decimal128
+		return 16, nil
+	}
+	return -1, nil
+}
@@ -0,0 +1,470 @@
+package healthcheck
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Protocol names the handshake performed with a service
+type Protocol string
+
+const (
+	ProtocolPostgres Protocol = "postgresql"
+	ProtocolMongodb  Protocol = "mongodb"
+	ProtocolRedis    Protocol = "redis"
+	ProtocolAMQP     Protocol = "amqp"
+	ProtocolHTTP     Protocol = "http"
+	ProtocolMySQL    Protocol = "mysql"
+)
+
+// maxMessageSize limits the server messages read by the checks
+const maxMessageSize = 1 << 20
+
+// Credentials used by the handshakes. Checks not needing them ignore them.
+type Credentials struct {
+	Username string
+	Password string
+	Database string
+	// TLS wraps the connection in TLS without verification, the certificate
+	// names the remote host instead of the local tunnel
+	TLS bool
+}
+
+var checks = map[Protocol]func(conn net.Conn, address string, credentials Credentials) (string, error){
+	ProtocolPostgres: postgres,
+	ProtocolMongodb:  mongodb,
+	ProtocolRedis:    redis,
+	ProtocolAMQP:     amqp,
+	ProtocolHTTP:     httpGet,
+	ProtocolMySQL:    mysql,
+}
+
+// Run dials address and performs the minimal handshake of protocol within
+// timeout. Returns a description of the server response.
+func Run(protocol Protocol, address string, credentials Credentials, timeout time.Duration) (string, error) {
+	check, ok := checks[protocol]
+	if !ok {
+		return "", fmt.Errorf("no health check for %s", protocol)
+	}
+	conn, err := dial(protocol, address, credentials, timeout)
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+	return check(conn, address, credentials)
+}
+
+// ErrNoRoleProbe is returned by Role for protocols without a role probe
+var ErrNoRoleProbe = errors.New("no role probe")
+
+var roleProbes = map[Protocol]func(conn net.Conn, credentials Credentials) (string, error){
+	ProtocolMongodb: mongodbRole,
+	ProtocolRedis:   redisRole,
+}
+
+// HasRoleProbe reports whether Role supports protocol
+func HasRoleProbe(protocol Protocol) bool {
+	_, ok := roleProbes[protocol]
+	return ok
+}
+
+// Role dials address and asks the node for its replication role within
+// timeout, e.g. primary or secondary
+func Role(protocol Protocol, address string, credentials Credentials, timeout time.Duration) (string, error) {
+	probe, ok := roleProbes[protocol]
+	if !ok {
+		return "", ErrNoRoleProbe
+	}
+	conn, err := dial(protocol, address, credentials, timeout)
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+	return probe(conn, credentials)
+}
+
+// dial connects to address with a deadline of timeout and wraps the
+// connection in TLS if required by the credentials
+func dial(protocol Protocol, address string, credentials Credentials, timeout time.Duration) (net.Conn, error) {
+	conn, err := net.DialTimeout("tcp", address, timeout)
+	if err != nil {
+		return nil, err
+	}
+	conn.SetDeadline(time.Now().Add(timeout))
+
+	if credentials.TLS && protocol != ProtocolPostgres && protocol != ProtocolMySQL {
+		tlsConn := tls.Client(conn, &tls.Config{InsecureSkipVerify: true})
+		err = tlsConn.Handshake()
+		if err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("TLS handshake failed. %s", err)
+		}
+		conn = tlsConn
+	}
+	return conn, nil
+}
+
+// postgres sends an SSLRequest and a startup message, which is answered with an
+// authentication request by healthy servers
+func postgres(conn net.Conn, address string, credentials Credentials) (string, error) {
+	sslRequest := make([]byte, 8)
+	binary.BigEndian.PutUint32(sslRequest[0:4], 8)
+	binary.BigEndian.PutUint32(sslRequest[4:8], 80877103)
+	_, err := conn.Write(sslRequest)
+	if err != nil {
+		return "", err
+	}
+	response := make([]byte, 1)
+	_, err = io.ReadFull(conn, response)
+	if err != nil {
+		return "", fmt.Errorf("no response to SSLRequest. %s", err)
+	}
+	detail := "SSLRequest declined"
+	switch response[0] {
+	case 'S':
+		tlsConn := tls.Client(conn, &tls.Config{InsecureSkipVerify: true})
+		err = tlsConn.Handshake()
+		if err != nil {
+			return "", fmt.Errorf("TLS handshake failed. %s", err)
+		}
+		conn, detail = tlsConn, "TLS"
+	case 'N':
+	default:
+		return "", fmt.Errorf("unexpected response to SSLRequest %q", response[0])
+	}
+
+	if len(credentials.Username) < 1 {
+		return detail + ", startup skipped without username", nil
+	}
+	startup := &bytes.Buffer{}
+	binary.Write(startup, binary.BigEndian, int32(0))
+	binary.Write(startup, binary.BigEndian, int32(196608))
+	parameters := []string{"user", credentials.Username}
+	if len(credentials.Database) > 0 {
+		parameters = append(parameters, "database", credentials.Database)
+	}
+	for _, parameter := range parameters {
+		startup.WriteString(parameter)
+		startup.WriteByte(0)
+	}
+	startup.WriteByte(0)
+	message := startup.Bytes()
+	binary.BigEndian.PutUint32(message[0:4], uint32(len(message)))
+	_, err = conn.Write(message)
+	if err != nil {
+		return "", err
+	}
+
+	header := make([]byte, 5)
+	_, err = io.ReadFull(conn, header)
+	if err != nil {
+		return "", fmt.Errorf("no response to startup. %s", err)
+	}
+	length := binary.BigEndian.Uint32(header[1:5])
+	if length < 4 || length > maxMessageSize {
+		return "", fmt.Errorf("invalid message length %d", length)
+	}
+	body := make([]byte, length-4)
+	_, err = io.ReadFull(conn, body)
+	if err != nil {
+		return "", err
+	}
+	switch header[0] {
+	case 'R':
+		return detail + ", authentication requested", nil
+	case 'E':
+		return "", fmt.Errorf("startup failed. %s", postgresErrorMessage(body))
+	}
+	return "", fmt.Errorf("unexpected response to startup %q", header[0])
+}
+
+// postgresErrorMessage returns the message field of an ErrorResponse
+func postgresErrorMessage(body []byte) string {
+	for len(body) > 1 {
+		field := body[0]
+		end := bytes.IndexByte(body[1:], 0)
+		if end < 0 {
+			break
+		}
+		if field == 'M' {
+			return string(body[1 : end+1])
+		}
+		body = body[end+2:]
+	}
+	return "unknown error"
+}
+
+// mongodbCommandNotFound is the error code of unknown commands, e.g. hello
+// before MongoDB 4.4.2
+const mongodbCommandNotFound = 59
+
+// mongodb sends a hello command in an OP_MSG, falling back to isMaster if the
+// server doesn't know hello
+func mongodb(conn net.Conn, address string, credentials Credentials) (string, error) {
+	command, reply, err := mongodbHello(conn)
+	if err != nil {
+		return "", err
+	}
+	if role := mongodbReplyRole(reply); role == "primary" || role == "standalone" {
+		return command + " answered by the primary", nil
+	}
+	return command + " answered", nil
+}
+
+// mongodbRole returns primary, secondary, arbiter or standalone from the hello reply
+func mongodbRole(conn net.Conn, credentials Credentials) (string, error) {
+	_, reply, err := mongodbHello(conn)
+	if err != nil {
+		return "", err
+	}
+	return mongodbReplyRole(reply), nil
+}
+
+// mongodbHello sends hello or isMaster and returns the command and its reply
+func mongodbHello(conn net.Conn) (string, map[string]interface{}, error) {
+	command := "hello"
+	reply, err := mongodbCommand(conn, 1, command)
+	if err != nil {
+		return command, nil, err
+	}
+	if code, _ := reply["code"].(float64); code == mongodbCommandNotFound {
+		command = "isMaster"
+		reply, err = mongodbCommand(conn, 2, command)
+		if err != nil {
+			return command, nil, err
+		}
+	}
+	if ok, _ := reply["ok"].(float64); ok != 1 {
+		return command, nil, fmt.Errorf("%s failed. %v", command, reply["errmsg"])
+	}
+	return command, reply, nil
+}
+
+func mongodbReplyRole(reply map[string]interface{}) string {
+	primary, _ := reply["isWritablePrimary"].(bool)
+	if isMaster, _ := reply["ismaster"].(bool); isMaster {
+		primary = true
+	}
+	_, replicaSet := reply["setName"]
+	secondary, _ := reply["secondary"].(bool)
+	arbiter, _ := reply["arbiterOnly"].(bool)
+	switch {
+	case primary && !replicaSet:
+		return "standalone"
+	case primary:
+		return "primary"
+	case secondary:
+		return "secondary"
+	case arbiter:
+		return "arbiter"
+	}
+	return "unknown"
+}
+
+// mongodbCommand sends command to the admin database and returns the reply
+func mongodbCommand(conn net.Conn, requestID int32, command string) (map[string]interface{}, error) {
+	const opMsg = 2013
+
+	document := bsonDocument(
+		bsonElement(0x10, command, []byte{1, 0, 0, 0}),
+		bsonElement(0x02, "$db", bsonString("admin")),
+	)
+	message := &bytes.Buffer{}
+	binary.Write(message, binary.LittleEndian, []int32{int32(16 + 4 + 1 + len(document)), requestID, 0, opMsg, 0})
+	message.WriteByte(0)
+	message.Write(document)
+	_, err := conn.Write(message.Bytes())
+	if err != nil {
+		return nil, err
+	}
+
+	header := make([]int32, 4)
+	err = binary.Read(conn, binary.LittleEndian, header)
+	if err != nil {
+		return nil, fmt.Errorf("no response to %s. %s", command, err)
+	}
+	if header[0] < 16+5 || header[0] > maxMessageSize || header[2] != requestID || header[3] != opMsg {
+		return nil, fmt.Errorf("invalid response to %s", command)
+	}
+	body := make([]byte, header[0]-16)
+	_, err = io.ReadFull(conn, body)
+	if err != nil {
+		return nil, err
+	}
+	// flag bits and the kind of the body section precede the reply
+	return bsonFields(body[5:])
+}
+
+// redis authenticates with the password if any and sends a PING
+func redis(conn net.Conn, address string, credentials Credentials) (string, error) {
+	reader := bufio.NewReader(conn)
+	detail := "PONG"
+	if len(credentials.Password) > 0 {
+		reply, err := redisCommand(conn, reader, "AUTH", credentials.Password)
+		if err != nil {
+			return "", err
+		}
+		if reply != "+OK" {
+			return "", fmt.Errorf("AUTH failed. %s", strings.TrimPrefix(reply, "-"))
+		}
+		detail = "AUTH accepted, PONG"
+	}
+	reply, err := redisCommand(conn, reader, "PING")
+	if err != nil {
+		return "", err
+	}
+	if reply != "+PONG" {
+		return "", fmt.Errorf("PING failed. %s", strings.TrimPrefix(reply, "-"))
+	}
+	return detail, nil
+}
+
+func redisCommand(conn net.Conn, reader *bufio.Reader, args ...string) (string, error) {
+	command := fmt.Sprintf("*%d\r\n", len(args))
+	for _, arg := range args {
+		command += fmt.Sprintf("$%d\r\n%s\r\n", len(arg), arg)
+	}
+	_, err := io.WriteString(conn, command)
+	if err != nil {
+		return "", err
+	}
+	reply, err := reader.ReadString('\n')
+	if err != nil {
+		return "", fmt.Errorf("no response to %s. %s", args[0], err)
+	}
+	return strings.TrimRight(reply, "\r\n"), nil
+}
+
+// redisRole authenticates with the password if any and returns the first
+// element of the ROLE reply: master, slave or sentinel
+func redisRole(conn net.Conn, credentials Credentials) (string, error) {
+	reader := bufio.NewReader(conn)
+	if len(credentials.Password) > 0 {
+		reply, err := redisCommand(conn, reader, "AUTH", credentials.Password)
+		if err != nil {
+			return "", err
+		}
+		if reply != "+OK" {
+			return "", fmt.Errorf("AUTH failed. %s", strings.TrimPrefix(reply, "-"))
+		}
+	}
+	reply, err := redisCommand(conn, reader, "ROLE")
+	if err != nil {
+		return "", err
+	}
+	if !strings.HasPrefix(reply, "*") {
+		return "", fmt.Errorf("ROLE failed. %s", strings.TrimPrefix(reply, "-"))
+	}
+	// the role is a bulk string: its length and then the value
+	for _, prefix := range []string{"$", ""} {
+		reply, err = reader.ReadString('\n')
+		if err != nil {
+			return "", fmt.Errorf("no response to ROLE. %s", err)
+		}
+		if !strings.HasPrefix(reply, prefix) {
+			return "", errors.New("invalid response to ROLE")
+		}
+	}
+	return strings.TrimRight(reply, "\r\n"), nil
+}
+
+// amqp sends the AMQP 0-9-1 protocol header, which is answered with
+// Connection.Start
+func amqp(conn net.Conn, address string, credentials Credentials) (string, error) {
+	_, err := conn.Write([]byte("AMQP\x00\x00\x09\x01"))
+	if err != nil {
+		return "", err
+	}
+	frame := make([]byte, 11)
+	_, err = io.ReadFull(conn, frame[:8])
+	if err != nil {
+		return "", fmt.Errorf("no response to the protocol header. %s", err)
+	}
+	if string(frame[:4]) == "AMQP" {
+		return "", fmt.Errorf("protocol 0-9-1 rejected, the server supports %d-%d-%d", frame[5], frame[6], frame[7])
+	}
+	_, err = io.ReadFull(conn, frame[8:])
+	if err != nil {
+		return "", err
+	}
+	// method frame of class 10 (connection) method 10 (start)
+	if frame[0] != 1 || binary.BigEndian.Uint16(frame[7:9]) != 10 || binary.BigEndian.Uint16(frame[9:11]) != 10 {
+		return "", errors.New("unexpected response to the protocol header")
+	}
+	return "Connection.Start received", nil
+}
+
+// httpGet requests / with basic auth if there is a username
+func httpGet(conn net.Conn, address string, credentials Credentials) (string, error) {
+	scheme := "http"
+	if credentials.TLS {
+		scheme = "https"
+	}
+	request, err := http.NewRequest("GET", scheme+"://"+address+"/", nil)
+	if err != nil {
+		return "", err
+	}
+	if len(credentials.Username) > 0 {
+		request.SetBasicAuth(credentials.Username, credentials.Password)
+	}
+	request.Close = true
+	err = request.Write(conn)
+	if err != nil {
+		return "", err
+	}
+	response, err := http.ReadResponse(bufio.NewReader(conn), request)
+	if err != nil {
+		return "", fmt.Errorf("no response to GET /. %s", err)
+	}
+	response.Body.Close()
+	if response.StatusCode >= 400 {
+		return "", fmt.Errorf("GET / failed with %s", response.Status)
+	}
+	return "GET / " + response.Status, nil
+}
+
+// mysql reads the greeting the server sends on connect
+func mysql(conn net.Conn, address string, credentials Credentials) (string, error) {
+	header := make([]byte, 4)
+	_, err := io.ReadFull(conn, header)
+	if err != nil {
+		return "", fmt.Errorf("no greeting. %s", err)
+	}
+	length := int(header[0]) | int(header[1])<<8 | int(header[2])<<16
+	if length < 1 || length > maxMessageSize {
+		return "", fmt.Errorf("invalid packet length %d", length)
+	}
+	payload := make([]byte, length)
+	_, err = io.ReadFull(conn, payload)
+	if err != nil {
+		return "", err
+	}
+	switch payload[0] {
+	case 10:
+		version := payload[1:]
+		if end := bytes.IndexByte(version, 0); end >= 0 {
+			version = version[:end]
+		}
+		return "greeting of " + string(version), nil
+	case 0xff:
+		// error code and sql state precede the message
+		message := payload[1:]
+		if len(message) > 2 {
+			message = message[2:]
+		}
+		if len(message) > 6 && message[0] == '#' {
+			message = message[6:]
+		}
+		return "", fmt.Errorf("connection refused by the server. %s", message)
+	}
+	return "", fmt.Errorf("unexpected greeting protocol %d", payload[0])
+}
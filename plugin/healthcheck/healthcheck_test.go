@@ -0,0 +1,282 @@
+package healthcheck_test
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"io"
+	"math"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"time"
+
+	. "github.com/anynines/cf_service_jumper_cli_plugin/plugin/healthcheck"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// serveOnce handles a single connection on a local listener and returns its address
+func serveOnce(handle func(conn net.Conn)) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	Expect(err).To(BeNil())
+	go func() {
+		defer listener.Close()
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		handle(conn)
+	}()
+	return listener.Addr().String()
+}
+
+// readMongodbRequest reads an OP_MSG and returns it without the length
+func readMongodbRequest(conn net.Conn) []byte {
+	var length int32
+	if binary.Read(conn, binary.LittleEndian, &length) != nil || length < 4 {
+		return nil
+	}
+	request := make([]byte, length-4)
+	io.ReadFull(conn, request)
+	return request
+}
+
+// writeMongodbReply answers the request responseTo with an OP_MSG containing
+// the elements of a document
+func writeMongodbReply(conn net.Conn, responseTo int32, elements []byte) {
+	documentLength := int32(4 + len(elements) + 1)
+	reply := &bytes.Buffer{}
+	binary.Write(reply, binary.LittleEndian, []int32{16 + 5 + documentLength, 2, responseTo, 2013, 0})
+	reply.WriteByte(0)
+	binary.Write(reply, binary.LittleEndian, documentLength)
+	reply.Write(elements)
+	reply.WriteByte(0)
+	conn.Write(reply.Bytes())
+}
+
+// postgresServer declines TLS and answers the startup message with reply
+func postgresServer(reply []byte, startup *string) string {
+	return serveOnce(func(conn net.Conn) {
+		io.ReadFull(conn, make([]byte, 8))
+		conn.Write([]byte("N"))
+		var length int32
+		binary.Read(conn, binary.BigEndian, &length)
+		message := make([]byte, length-4)
+		io.ReadFull(conn, message)
+		*startup = string(message)
+		conn.Write(reply)
+	})
+}
+
+var _ = Describe("Run", func() {
+	credentials := Credentials{Username: "the_user", Password: "the_password", Database: "db"}
+	timeout := time.Second
+
+	It("sends the postgres startup message", func() {
+		var startup string
+		address := postgresServer([]byte{'R', 0, 0, 0, 8, 0, 0, 0, 3}, &startup)
+
+		detail, err := Run(ProtocolPostgres, address, credentials, timeout)
+		Expect(err).To(BeNil())
+		Expect(detail).To(Equal("SSLRequest declined, authentication requested"))
+		Expect(startup).To(ContainSubstring("user\x00the_user\x00database\x00db\x00"))
+	})
+
+	It("reports postgres errors", func() {
+		body := []byte("SFATAL\x00Mdatabase \"db\" does not exist\x00\x00")
+		reply := append([]byte{'E', 0, 0, 0, byte(4 + len(body))}, body...)
+		address := postgresServer(reply, new(string))
+
+		_, err := Run(ProtocolPostgres, address, credentials, timeout)
+		Expect(err).To(MatchError(`startup failed. database "db" does not exist`))
+	})
+
+	It("sends a mongodb hello", func() {
+		var request []byte
+		address := serveOnce(func(conn net.Conn) {
+			request = readMongodbRequest(conn)
+			document := &bytes.Buffer{}
+			document.WriteString("\x08isWritablePrimary\x00\x01")
+			document.WriteString("\x01ok\x00")
+			binary.Write(document, binary.LittleEndian, math.Float64bits(1))
+			writeMongodbReply(conn, 1, document.Bytes())
+		})
+
+		detail, err := Run(ProtocolMongodb, address, credentials, timeout)
+		Expect(err).To(BeNil())
+		Expect(detail).To(Equal("hello answered by the primary"))
+		Expect(string(request)).To(ContainSubstring("\x10hello\x00\x01\x00\x00\x00"))
+	})
+
+	It("falls back to isMaster if mongodb doesn't know hello", func() {
+		var request []byte
+		address := serveOnce(func(conn net.Conn) {
+			readMongodbRequest(conn)
+			document := &bytes.Buffer{}
+			document.WriteString("\x01ok\x00")
+			binary.Write(document, binary.LittleEndian, math.Float64bits(0))
+			document.WriteString("\x02errmsg\x00\x19\x00\x00\x00no such command: 'hello'\x00")
+			document.WriteString("\x10code\x00\x3b\x00\x00\x00")
+			writeMongodbReply(conn, 1, document.Bytes())
+
+			request = readMongodbRequest(conn)
+			document.Reset()
+			document.WriteString("\x08ismaster\x00\x01")
+			document.WriteString("\x01ok\x00")
+			binary.Write(document, binary.LittleEndian, math.Float64bits(1))
+			writeMongodbReply(conn, 2, document.Bytes())
+		})
+
+		detail, err := Run(ProtocolMongodb, address, credentials, timeout)
+		Expect(err).To(BeNil())
+		Expect(detail).To(Equal("isMaster answered by the primary"))
+		Expect(string(request)).To(ContainSubstring("\x10isMaster\x00\x01\x00\x00\x00"))
+	})
+
+	It("authenticates and pings redis", func() {
+		var commands []string
+		address := serveOnce(func(conn net.Conn) {
+			reader := bufio.NewReader(conn)
+			for i := 0; i < 2; i++ {
+				var command []string
+				header, _ := reader.ReadString('\n')
+				for j := 0; j < 2*int(header[1]-'0'); j++ {
+					line, _ := reader.ReadString('\n')
+					if j%2 == 1 {
+						command = append(command, strings.TrimSpace(line))
+					}
+				}
+				commands = append(commands, strings.Join(command, " "))
+				if command[0] == "AUTH" {
+					io.WriteString(conn, "+OK\r\n")
+				} else {
+					io.WriteString(conn, "+PONG\r\n")
+				}
+			}
+		})
+
+		detail, err := Run(ProtocolRedis, address, credentials, timeout)
+		Expect(err).To(BeNil())
+		Expect(detail).To(Equal("AUTH accepted, PONG"))
+		Expect(commands).To(Equal([]string{"AUTH the_password", "PING"}))
+	})
+
+	It("reports a rejected redis password", func() {
+		address := serveOnce(func(conn net.Conn) {
+			bufio.NewReader(conn).ReadString('\n')
+			io.WriteString(conn, "-WRONGPASS invalid username-password pair\r\n")
+		})
+
+		_, err := Run(ProtocolRedis, address, credentials, timeout)
+		Expect(err).To(MatchError("AUTH failed. WRONGPASS invalid username-password pair"))
+	})
+
+	It("sends the AMQP protocol header", func() {
+		var header []byte
+		address := serveOnce(func(conn net.Conn) {
+			header = make([]byte, 8)
+			io.ReadFull(conn, header)
+			conn.Write([]byte{1, 0, 0, 0, 0, 0, 4, 0, 10, 0, 10})
+		})
+
+		detail, err := Run(ProtocolAMQP, address, credentials, timeout)
+		Expect(err).To(BeNil())
+		Expect(detail).To(Equal("Connection.Start received"))
+		Expect(string(header)).To(Equal("AMQP\x00\x00\x09\x01"))
+	})
+
+	It("reports an unsupported AMQP version", func() {
+		address := serveOnce(func(conn net.Conn) {
+			io.ReadFull(conn, make([]byte, 8))
+			conn.Write([]byte("AMQP\x01\x01\x00\x0a"))
+		})
+
+		_, err := Run(ProtocolAMQP, address, credentials, timeout)
+		Expect(err).To(MatchError("protocol 0-9-1 rejected, the server supports 1-0-10"))
+	})
+
+	It("requests / with basic auth", func() {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if username, password, _ := r.BasicAuth(); username != "the_user" || password != "the_password" {
+				w.WriteHeader(http.StatusUnauthorized)
+			}
+		}))
+		defer server.Close()
+		address := strings.TrimPrefix(server.URL, "http://")
+
+		detail, err := Run(ProtocolHTTP, address, credentials, timeout)
+		Expect(err).To(BeNil())
+		Expect(detail).To(Equal("GET / 200 OK"))
+
+		_, err = Run(ProtocolHTTP, address, Credentials{}, timeout)
+		Expect(err).To(MatchError("GET / failed with 401 Unauthorized"))
+	})
+
+	It("reads the mysql greeting", func() {
+		address := serveOnce(func(conn net.Conn) {
+			payload := []byte("\x0a10.4.12-MariaDB\x00")
+			conn.Write(append([]byte{byte(len(payload)), 0, 0, 0}, payload...))
+		})
+
+		detail, err := Run(ProtocolMySQL, address, credentials, timeout)
+		Expect(err).To(BeNil())
+		Expect(detail).To(Equal("greeting of 10.4.12-MariaDB"))
+	})
+
+	It("times out on servers not answering", func() {
+		address := serveOnce(func(conn net.Conn) {
+			time.Sleep(time.Second)
+		})
+
+		_, err := Run(ProtocolPostgres, address, credentials, 50*time.Millisecond)
+		Expect(err).To(MatchError(ContainSubstring("no response to SSLRequest")))
+	})
+})
+
+var _ = Describe("Role", func() {
+	credentials := Credentials{Password: "the_password"}
+	timeout := time.Second
+
+	It("returns the role of a mongodb replica set member", func() {
+		address := serveOnce(func(conn net.Conn) {
+			readMongodbRequest(conn)
+			document := &bytes.Buffer{}
+			document.WriteString("\x08isWritablePrimary\x00\x00")
+			document.WriteString("\x08secondary\x00\x01")
+			document.WriteString("\x02setName\x00\x04\x00\x00\x00rs0\x00")
+			document.WriteString("\x01ok\x00")
+			binary.Write(document, binary.LittleEndian, math.Float64bits(1))
+			writeMongodbReply(conn, 1, document.Bytes())
+		})
+
+		role, err := Role(ProtocolMongodb, address, credentials, timeout)
+		Expect(err).To(BeNil())
+		Expect(role).To(Equal("secondary"))
+	})
+
+	It("returns the redis role after authenticating", func() {
+		address := serveOnce(func(conn net.Conn) {
+			reader := bufio.NewReader(conn)
+			for _, reply := range []string{"+OK\r\n", "*3\r\n$6\r\nmaster\r\n:0\r\n*0\r\n"} {
+				header, _ := reader.ReadString('\n')
+				for j := 0; j < 2*int(header[1]-'0'); j++ {
+					reader.ReadString('\n')
+				}
+				io.WriteString(conn, reply)
+			}
+		})
+
+		role, err := Role(ProtocolRedis, address, credentials, timeout)
+		Expect(err).To(BeNil())
+		Expect(role).To(Equal("master"))
+	})
+
+	It("has no role probe for postgres", func() {
+		Expect(HasRoleProbe(ProtocolPostgres)).To(BeFalse())
+		_, err := Role(ProtocolPostgres, "127.0.0.1:1", credentials, timeout)
+		Expect(err).To(Equal(ErrNoRoleProbe))
+	})
+})
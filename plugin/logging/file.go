@@ -0,0 +1,105 @@
+package logging
+
+import (
+	"fmt"
+	"os"
+	"sync"
+)
+
+const (
+	// DefaultMaxSize of a log file before it is rotated
+	DefaultMaxSize = 1 << 20
+	// DefaultMaxBackups is the number of rotated log files kept
+	DefaultMaxBackups = 3
+)
+
+// RotatingFile is a log file which is renamed to PATH.1 once it exceeds
+// MaxSize. Older backups are shifted to PATH.2 and so on up to MaxBackups.
+type RotatingFile struct {
+	Path       string
+	MaxSize    int64
+	MaxBackups int
+
+	mutex sync.Mutex
+	file  *os.File
+	size  int64
+}
+
+// OpenRotatingFile opens path for appending with mode 0600
+func OpenRotatingFile(path string, maxSize int64, maxBackups int) (*RotatingFile, error) {
+	rotatingFile := &RotatingFile{Path: path, MaxSize: maxSize, MaxBackups: maxBackups}
+	err := rotatingFile.open()
+	if err != nil {
+		return nil, err
+	}
+	return rotatingFile, nil
+}
+
+func (f *RotatingFile) open() error {
+	file, err := os.OpenFile(f.Path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return fmt.Errorf("[ERR] Failed to open log file %s. %s", f.Path, err)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	f.file, f.size = file, info.Size()
+	return nil
+}
+
+func (f *RotatingFile) Write(p []byte) (int, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	if f.file == nil {
+		return 0, os.ErrClosed
+	}
+	if f.size > 0 && f.size+int64(len(p)) > f.MaxSize {
+		if err := f.rotate(); err != nil {
+			return 0, err
+		}
+	}
+
+	n, err := f.file.Write(p)
+	f.size += int64(n)
+	return n, err
+}
+
+// rotate shifts the backups, renames the file to PATH.1 and opens a new one
+func (f *RotatingFile) rotate() error {
+	err := f.file.Close()
+	if err != nil {
+		return err
+	}
+	f.file = nil
+
+	if f.MaxBackups < 1 {
+		os.Remove(f.Path)
+	} else {
+		os.Remove(f.backupPath(f.MaxBackups))
+		for i := f.MaxBackups - 1; i > 0; i-- {
+			os.Rename(f.backupPath(i), f.backupPath(i+1))
+		}
+		os.Rename(f.Path, f.backupPath(1))
+	}
+	return f.open()
+}
+
+func (f *RotatingFile) backupPath(index int) string {
+	return fmt.Sprintf("%s.%d", f.Path, index)
+}
+
+// Close closes the file
+func (f *RotatingFile) Close() error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	if f.file == nil {
+		return nil
+	}
+	err := f.file.Close()
+	f.file = nil
+	return err
+}
@@ -0,0 +1,134 @@
+package logging
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/anynines/cf_service_jumper_cli_plugin/plugin/redact"
+)
+
+// Level of log messages, higher levels are more verbose
+type Level int
+
+const (
+	LevelError Level = iota
+	LevelWarn
+	LevelInfo
+	LevelDebug
+)
+
+// Levels in the order of their verbosity
+var Levels = []string{"error", "warn", "info", "debug"}
+
+var ErrUnknownLevel = fmt.Errorf("[ERR] unknown log level. Supported levels: %s", strings.Join(Levels, ", "))
+
+// ParseLevel parses the level name, e.g. debug
+func ParseLevel(name string) (Level, error) {
+	for i, level := range Levels {
+		if strings.EqualFold(level, name) {
+			return Level(i), nil
+		}
+	}
+	return LevelError, ErrUnknownLevel
+}
+
+func (l Level) String() string {
+	if l < LevelError || l > LevelDebug {
+		return fmt.Sprintf("level(%d)", int(l))
+	}
+	return Levels[l]
+}
+
+type sink struct {
+	writer io.Writer
+	level  Level
+}
+
+// Logger writes redacted messages to sinks which accept the level of the
+// message. All methods are safe for concurrent use and do nothing on a nil Logger.
+type Logger struct {
+	mutex sync.Mutex
+	sinks []sink
+	// now returns the time of log lines, replaced by tests
+	now func() time.Time
+}
+
+// New returns a logger without sinks
+func New() *Logger {
+	return &Logger{now: time.Now}
+}
+
+// AddSink writes messages up to level to writer. The writer is closed by
+// Close if it is an io.Closer.
+func (l *Logger) AddSink(writer io.Writer, level Level) {
+	if l == nil {
+		return
+	}
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	l.sinks = append(l.sinks, sink{writer: writer, level: level})
+}
+
+// Enabled reports whether any sink accepts messages of level
+func (l *Logger) Enabled(level Level) bool {
+	if l == nil {
+		return false
+	}
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	for _, sink := range l.sinks {
+		if level <= sink.level {
+			return true
+		}
+	}
+	return false
+}
+
+func (l *Logger) Errorf(format string, args ...interface{}) { l.log(LevelError, format, args...) }
+func (l *Logger) Warnf(format string, args ...interface{})  { l.log(LevelWarn, format, args...) }
+func (l *Logger) Infof(format string, args ...interface{})  { l.log(LevelInfo, format, args...) }
+func (l *Logger) Debugf(format string, args ...interface{}) { l.log(LevelDebug, format, args...) }
+
+func (l *Logger) log(level Level, format string, args ...interface{}) {
+	if !l.Enabled(level) {
+		return
+	}
+
+	message := redact.String(fmt.Sprintf(format, args...))
+	line := fmt.Sprintf("%s %-5s %s\n", l.now().Format(time.RFC3339), strings.ToUpper(level.String()), strings.TrimSuffix(message, "\n"))
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	for _, sink := range l.sinks {
+		if level <= sink.level {
+			io.WriteString(sink.writer, line)
+		}
+	}
+}
+
+// Close closes the sinks which are io.Closers
+func (l *Logger) Close() error {
+	if l == nil {
+		return nil
+	}
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	var errs []string
+	for _, sink := range l.sinks {
+		if closer, ok := sink.writer.(io.Closer); ok {
+			if err := closer.Close(); err != nil {
+				errs = append(errs, err.Error())
+			}
+		}
+	}
+	l.sinks = nil
+	if len(errs) > 0 {
+		return errors.New(strings.Join(errs, "; "))
+	}
+	return nil
+}
@@ -0,0 +1,36 @@
+package logging
+
+import (
+	"net/http"
+	"time"
+)
+
+// Transport logs method, url, status and duration of requests at debug level
+type Transport struct {
+	Base   http.RoundTripper
+	Logger *Logger
+}
+
+// NewTransport wraps base. base is returned unchanged if logger is nil.
+func NewTransport(base http.RoundTripper, logger *Logger) http.RoundTripper {
+	if logger == nil {
+		return base
+	}
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	return &Transport{Base: base, Logger: logger}
+}
+
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	start := time.Now()
+	resp, err := t.Base.RoundTrip(req)
+	duration := time.Since(start).Round(time.Millisecond)
+
+	if err != nil {
+		t.Logger.Debugf("%s %s failed after %s. %s", req.Method, req.URL, duration, err)
+		return resp, err
+	}
+	t.Logger.Debugf("%s %s %d (%s)", req.Method, req.URL, resp.StatusCode, duration)
+	return resp, nil
+}
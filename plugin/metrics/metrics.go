@@ -0,0 +1,153 @@
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// ContentType of the prometheus text format
+const ContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// DefaultBuckets are the upper bounds in seconds of latency histograms
+var DefaultBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// Labels of a sample
+type Labels map[string]string
+
+// String formats the labels sorted by name, e.g. {host="10.0.0.1:5432"}
+func (l Labels) String() string {
+	if len(l) < 1 {
+		return ""
+	}
+	names := make([]string, 0, len(l))
+	for name := range l {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	pairs := make([]string, len(names))
+	for i, name := range names {
+		pairs[i] = fmt.Sprintf(`%s="%s"`, name, escaper.Replace(l[name]))
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+// escaper escapes backslashes, quotes and newlines of label values
+var escaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// With returns a copy of the labels with an additional label
+func (l Labels) With(name, value string) Labels {
+	labels := Labels{name: value}
+	for k, v := range l {
+		labels[k] = v
+	}
+	return labels
+}
+
+// Writer writes metrics in the prometheus text format. The samples of a metric
+// must be written one after another, HELP and TYPE are written before the first.
+type Writer struct {
+	out       io.Writer
+	described map[string]bool
+}
+
+// NewWriter returns a writer to out
+func NewWriter(out io.Writer) *Writer {
+	return &Writer{out: out, described: make(map[string]bool)}
+}
+
+// Counter writes a sample of a counter
+func (w *Writer) Counter(name, help string, labels Labels, value float64) {
+	w.describe(name, help, "counter")
+	w.sample(name, labels, value)
+}
+
+// Gauge writes a sample of a gauge
+func (w *Writer) Gauge(name, help string, labels Labels, value float64) {
+	w.describe(name, help, "gauge")
+	w.sample(name, labels, value)
+}
+
+// Histogram writes the buckets, sum and count of a histogram
+func (w *Writer) Histogram(name, help string, labels Labels, snapshot HistogramSnapshot) {
+	w.describe(name, help, "histogram")
+	for i, upperBound := range snapshot.Buckets {
+		w.sample(name+"_bucket", labels.With("le", formatValue(upperBound)), float64(snapshot.Counts[i]))
+	}
+	w.sample(name+"_bucket", labels.With("le", "+Inf"), float64(snapshot.Count))
+	w.sample(name+"_sum", labels, snapshot.Sum)
+	w.sample(name+"_count", labels, float64(snapshot.Count))
+}
+
+func (w *Writer) describe(name, help, metricType string) {
+	if w.described[name] {
+		return
+	}
+	w.described[name] = true
+	fmt.Fprintf(w.out, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, metricType)
+}
+
+func (w *Writer) sample(name string, labels Labels, value float64) {
+	fmt.Fprintf(w.out, "%s%s %s\n", name, labels, formatValue(value))
+}
+
+func formatValue(value float64) string {
+	switch {
+	case math.IsInf(value, 1):
+		return "+Inf"
+	case math.IsInf(value, -1):
+		return "-Inf"
+	}
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
+
+// Histogram counts observations in cumulative buckets. It is safe for
+// concurrent use.
+type Histogram struct {
+	mutex    sync.Mutex
+	snapshot HistogramSnapshot
+}
+
+// HistogramSnapshot are the counts of a histogram. Counts are cumulative, the
+// count of a bucket includes all observations up to its upper bound.
+type HistogramSnapshot struct {
+	Buckets []float64
+	Counts  []uint64
+	Sum     float64
+	Count   uint64
+}
+
+// NewHistogram returns a histogram with the upper bounds of buckets in
+// ascending order
+func NewHistogram(buckets []float64) *Histogram {
+	return &Histogram{snapshot: HistogramSnapshot{Buckets: buckets, Counts: make([]uint64, len(buckets))}}
+}
+
+// Observe adds a value, e.g. a latency in seconds
+func (h *Histogram) Observe(value float64) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	for i, upperBound := range h.snapshot.Buckets {
+		if value <= upperBound {
+			h.snapshot.Counts[i]++
+		}
+	}
+	h.snapshot.Sum += value
+	h.snapshot.Count++
+}
+
+// Snapshot returns a copy of the counts
+func (h *Histogram) Snapshot() HistogramSnapshot {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	snapshot := h.snapshot
+	snapshot.Counts = append([]uint64{}, h.snapshot.Counts...)
+	return snapshot
+}
@@ -0,0 +1,57 @@
+package metrics_test
+
+import (
+	"bytes"
+
+	. "github.com/anynines/cf_service_jumper_cli_plugin/plugin/metrics"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Writer", func() {
+	var (
+		buffer *bytes.Buffer
+		writer *Writer
+	)
+
+	BeforeEach(func() {
+		buffer = &bytes.Buffer{}
+		writer = NewWriter(buffer)
+	})
+
+	It("describes a metric once before its samples", func() {
+		writer.Counter("requests_total", "Requests.", Labels{"method": "GET"}, 2)
+		writer.Counter("requests_total", "Requests.", Labels{"method": "POST"}, 1)
+		writer.Gauge("up", "Up.", nil, 1)
+
+		Expect(buffer.String()).To(Equal(`# HELP requests_total Requests.
+# TYPE requests_total counter
+requests_total{method="GET"} 2
+requests_total{method="POST"} 1
+# HELP up Up.
+# TYPE up gauge
+up 1
+`))
+	})
+
+	It("sorts and escapes labels", func() {
+		Expect(Labels{"b": "x", "a": "say \"hi\"\\\n"}.String()).To(Equal(`{a="say \"hi\"\\\n",b="x"}`))
+	})
+
+	It("writes cumulative histogram buckets", func() {
+		histogram := NewHistogram([]float64{0.1, 1})
+		histogram.Observe(0.05)
+		histogram.Observe(0.5)
+		histogram.Observe(3)
+
+		writer.Histogram("latency_seconds", "Latency.", Labels{"host": "h"}, histogram.Snapshot())
+		Expect(buffer.String()).To(Equal(`# HELP latency_seconds Latency.
+# TYPE latency_seconds histogram
+latency_seconds_bucket{host="h",le="0.1"} 1
+latency_seconds_bucket{host="h",le="1"} 2
+latency_seconds_bucket{host="h",le="+Inf"} 3
+latency_seconds_sum{host="h"} 3.55
+latency_seconds_count{host="h"} 3
+`))
+	})
+})
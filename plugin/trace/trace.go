@@ -0,0 +1,81 @@
+package trace
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httputil"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/anynines/cf_service_jumper_cli_plugin/plugin/redact"
+)
+
+// WriterFromEnv returns the trace destination configured by CF_TRACE the same
+// way the cf cli does: nil if unset or false, stderr if true and the named
+// file otherwise.
+func WriterFromEnv() (io.Writer, error) {
+	cfTrace := os.Getenv("CF_TRACE")
+	switch strings.ToLower(cfTrace) {
+	case "", "false":
+		return nil, nil
+	case "true":
+		return os.Stderr, nil
+	}
+
+	file, err := os.OpenFile(cfTrace, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("[ERR] Failed to open CF_TRACE file %s. %s", cfTrace, err)
+	}
+	return file, nil
+}
+
+// Transport dumps redacted requests and responses to Writer
+type Transport struct {
+	Base   http.RoundTripper
+	Writer io.Writer
+
+	mutex sync.Mutex
+}
+
+// NewTransport wraps base. base is returned unchanged if writer is nil.
+func NewTransport(base http.RoundTripper, writer io.Writer) http.RoundTripper {
+	if writer == nil {
+		return base
+	}
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	return &Transport{Base: base, Writer: writer}
+}
+
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	requestDump, err := httputil.DumpRequestOut(req, true)
+	if err != nil {
+		requestDump = []byte(err.Error())
+	}
+	t.write("REQUEST", requestDump)
+
+	resp, err := t.Base.RoundTrip(req)
+	if err != nil {
+		t.write("RESPONSE", []byte(err.Error()))
+		return resp, err
+	}
+
+	responseDump, err := httputil.DumpResponse(resp, true)
+	if err != nil {
+		responseDump = []byte(err.Error())
+	}
+	t.write("RESPONSE", responseDump)
+
+	return resp, nil
+}
+
+func (t *Transport) write(title string, dump []byte) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	fmt.Fprintf(t.Writer, "\n%s: [%s]\n%s\n", title, time.Now().Format(time.RFC3339), redact.String(string(dump)))
+}
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"strconv"
+	"strings"
+)
+
+var (
+	ProfileFormats = []string{"dbeaver", "pgadmin", "compass"}
+
+	ErrUnknownProfileFormat = fmt.Errorf("[ERR] unknown profile format. Supported formats: %s", strings.Join(ProfileFormats, ", "))
+)
+
+// ProfileExporter writes connection profiles of GUI clients pointing to the
+// local tunnel addresses.
+type ProfileExporter struct {
+	Name           string
+	ServiceType    ServiceType
+	Credentials    map[string]string
+	LocalAddresses []string
+	SavePassword   bool
+}
+
+// Export writes the connection profile for tool to filePath.
+func (e ProfileExporter) Export(tool string, filePath string) error {
+	var profile interface{}
+	var err error
+
+	switch tool {
+	case "dbeaver":
+		profile, err = e.dbeaverProfile()
+	case "pgadmin":
+		profile, err = e.pgadminProfile(filePath)
+	case "compass":
+		profile, err = e.compassProfile()
+	default:
+		err = ErrUnknownProfileFormat
+	}
+	if err != nil {
+		return err
+	}
+
+	data, err := json.MarshalIndent(profile, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	// profiles might contain the password
+	return ioutil.WriteFile(filePath, data, 0600)
+}
+
+func (e ProfileExporter) connectionStringBuilder() ConnectionStringBuilder {
+	return ConnectionStringBuilder{
+		ServiceType:    e.ServiceType,
+		Credentials:    e.Credentials,
+		LocalAddresses: e.LocalAddresses,
+	}
+}
+
+func (e ProfileExporter) serviceURI() (ServiceURI, ServiceType, error) {
+	connectionStringBuilder := e.connectionStringBuilder()
+	serviceURI, err := connectionStringBuilder.serviceURI()
+	return serviceURI, connectionStringBuilder.serviceType(serviceURI), err
+}
+
+func (e ProfileExporter) profileID() string {
+	return "cf-forward-" + e.Name
+}
+
+func (e ProfileExporter) dbeaverProfile() (interface{}, error) {
+	serviceURI, serviceType, err := e.serviceURI()
+	if err != nil {
+		return nil, err
+	}
+
+	var provider, driver string
+	switch serviceType {
+	case ServiceTypePostgres:
+		provider, driver = "postgresql", "postgres-jdbc"
+	case ServiceTypeMariaDB:
+		provider, driver = "mysql", "mysql8"
+	default:
+		return nil, fmt.Errorf("[ERR] profile dbeaver not available for %s", serviceURI.Scheme)
+	}
+
+	hostAndPort, err := serviceURI.FirstHost()
+	if err != nil {
+		return nil, err
+	}
+	host, port, err := net.SplitHostPort(hostAndPort)
+	if err != nil {
+		return nil, err
+	}
+
+	jdbcURL, err := e.connectionStringBuilder().buildJdbc(serviceURI, false)
+	if err != nil {
+		return nil, err
+	}
+
+	configuration := map[string]interface{}{
+		"host":       host,
+		"port":       port,
+		"database":   serviceURI.Path,
+		"url":        jdbcURL,
+		"type":       "dev",
+		"auth-model": "native",
+		"user":       serviceURI.Username,
+	}
+	if e.SavePassword {
+		configuration["password"] = serviceURI.Password
+	}
+
+	return map[string]interface{}{
+		"folders": map[string]interface{}{},
+		"connections": map[string]interface{}{
+			e.profileID(): map[string]interface{}{
+				"provider":      provider,
+				"driver":        driver,
+				"name":          e.Name + " (cf forward)",
+				"save-password": e.SavePassword,
+				"configuration": configuration,
+			},
+		},
+	}, nil
+}
+
+// pgadminProfile returns a servers.json for `pgadmin4 --load-servers`. pgAdmin
+// doesn't import passwords so they are written to a pgpass file next to it.
+func (e ProfileExporter) pgadminProfile(filePath string) (interface{}, error) {
+	serviceURI, serviceType, err := e.serviceURI()
+	if err != nil {
+		return nil, err
+	}
+	if serviceType != ServiceTypePostgres {
+		return nil, fmt.Errorf("[ERR] profile pgadmin not available for %s", serviceURI.Scheme)
+	}
+
+	hostAndPort, err := serviceURI.FirstHost()
+	if err != nil {
+		return nil, err
+	}
+	host, portStr, err := net.SplitHostPort(hostAndPort)
+	if err != nil {
+		return nil, err
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil {
+		return nil, err
+	}
+
+	server := map[string]interface{}{
+		"Name":          e.Name + " (cf forward)",
+		"Group":         "Cloud Foundry",
+		"Host":          host,
+		"Port":          port,
+		"MaintenanceDB": serviceURI.Path,
+		"Username":      serviceURI.Username,
+		"SSLMode":       "prefer",
+	}
+	if e.SavePassword {
+		passFilePath := filePath + ".pgpass"
+		passFile := fmt.Sprintf("%s:%d:*:%s:%s\n", host, port, serviceURI.Username, serviceURI.Password)
+		err = ioutil.WriteFile(passFilePath, []byte(passFile), 0600)
+		if err != nil {
+			return nil, err
+		}
+		server["PassFile"] = passFilePath
+	}
+
+	return map[string]interface{}{
+		"Servers": map[string]interface{}{
+			"1": server,
+		},
+	}, nil
+}
+
+func (e ProfileExporter) compassProfile() (interface{}, error) {
+	serviceURI, serviceType, err := e.serviceURI()
+	if err != nil {
+		return nil, err
+	}
+	if serviceType != ServiceTypeMongodb {
+		return nil, fmt.Errorf("[ERR] profile compass not available for %s", serviceURI.Scheme)
+	}
+
+	if !e.SavePassword {
+		serviceURI.Password = ""
+	}
+	connectionString := e.connectionStringBuilder().buildURI(serviceURI)[0]
+
+	return map[string]interface{}{
+		"type":    "Compass Connections",
+		"version": map[string]interface{}{"$numberInt": "1"},
+		"connections": []interface{}{
+			map[string]interface{}{
+				"id": e.profileID(),
+				"connectionOptions": map[string]interface{}{
+					"connectionString": connectionString,
+				},
+				"favorite": map[string]interface{}{
+					"name": e.Name + " (cf forward)",
+				},
+			},
+		},
+	}, nil
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/anynines/cf_service_jumper_cli_plugin/xtunnel"
+)
+
+// ReadyHandshakeTimeout limits the handshakes verifying a forward before it is
+// ready, see --ready-handshake
+const ReadyHandshakeTimeout = 10 * time.Second
+
+// ReadinessError is returned if a forward didn't get ready, e.g. because no
+// host accepted a handshake
+type ReadinessError struct {
+	Err error
+}
+
+func (e *ReadinessError) Error() string {
+	return fmt.Sprintf("[ERR] Forward not ready. %s", e.Err)
+}
+
+// Hint suggests diagnosing the hosts
+func (e *ReadinessError) Hint() string {
+	return "check the connectivity of the hosts with 'cf forward-doctor SERVICE_INSTANCE'"
+}
+
+// Readiness describes a forward whose tunnels listen, written to --ready-file
+// and --ready-fd
+type Readiness struct {
+	ForwardID   int           `json:"forward_id"`
+	ServiceName string        `json:"service_instance"`
+	Tunnels     []ReadyTunnel `json:"tunnels"`
+	Ports       []int         `json:"ports"`
+	Hosts       []string      `json:"hosts"`
+	// Handshake is the host a handshake was verified with, blank if not checked
+	Handshake string `json:"handshake,omitempty"`
+	// HealthyHosts passed the --health-check, nil if not checked
+	HealthyHosts []string `json:"healthy_hosts,omitempty"`
+}
+
+// ReadyTunnel is a listening tunnel of a ready forward
+type ReadyTunnel struct {
+	LocalAddress string `json:"local_address"`
+	Port         int    `json:"port"`
+	Host         string `json:"host"`
+}
+
+// NewReadiness describes the tunnels of a forward in the order of its hosts
+func NewReadiness(forwardID int, serviceName string, tunnels []Tunnel) Readiness {
+	readiness := Readiness{ForwardID: forwardID, ServiceName: serviceName, Tunnels: []ReadyTunnel{}, Ports: []int{}, Hosts: []string{}}
+	for _, tunnel := range tunnels {
+		_, portStr, _ := net.SplitHostPort(tunnel.LocalAddress())
+		port, _ := strconv.Atoi(portStr)
+		readiness.Tunnels = append(readiness.Tunnels, ReadyTunnel{LocalAddress: tunnel.LocalAddress(), Port: port, Host: tunnel.RemoteAddress()})
+		readiness.Ports = append(readiness.Ports, port)
+		readiness.Hosts = append(readiness.Hosts, tunnel.RemoteAddress())
+	}
+	return readiness
+}
+
+// VerifyHandshake probes all hosts concurrently and returns the first host
+// accepting a handshake. Fails with the errors of all hosts if none does.
+func VerifyHandshake(hosts []string, probe func(host string) error) (string, error) {
+	if len(hosts) < 1 {
+		return "", errors.New("The forward has no hosts")
+	}
+	type result struct {
+		host string
+		err  error
+	}
+	results := make(chan result, len(hosts))
+	for _, host := range hosts {
+		go func(host string) {
+			results <- result{host: host, err: probe(host)}
+		}(host)
+	}
+
+	failures := make([]string, 0, len(hosts))
+	for range hosts {
+		r := <-results
+		if r.err == nil {
+			return r.host, nil
+		}
+		failures = append(failures, fmt.Sprintf("%s: %s", r.host, r.err))
+	}
+	return "", fmt.Errorf("No host accepted a handshake. %s", strings.Join(failures, "; "))
+}
+
+// WriteReadyFile writes readiness to filePath atomically: readers see either no
+// file or the complete JSON. Errors are wrapped in a ReadinessError by the caller.
+func WriteReadyFile(filePath string, readiness Readiness) error {
+	content, err := json.MarshalIndent(readiness, "", "  ")
+	if err != nil {
+		return err
+	}
+	file, err := ioutil.TempFile(filepath.Dir(filePath), "."+filepath.Base(filePath))
+	if err != nil {
+		return fmt.Errorf("Failed to write --ready-file %s. %s", filePath, err)
+	}
+	_, err = file.Write(append(content, '\n'))
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(file.Name(), filePath)
+	}
+	if err != nil {
+		os.Remove(file.Name())
+		return fmt.Errorf("Failed to write --ready-file %s. %s", filePath, err)
+	}
+	return nil
+}
+
+// WriteReadyFD writes readiness as a line of JSON to the file descriptor fd,
+// which is closed so that readers get EOF. The descriptor is inherited from the
+// shell through the cf cli, e.g. with 'cf create-forward ... 3>ready'.
+func WriteReadyFD(fd int, readiness Readiness) error {
+	content, err := json.Marshal(readiness)
+	if err != nil {
+		return err
+	}
+	file := os.NewFile(uintptr(fd), "ready-fd")
+	if file == nil {
+		return fmt.Errorf("Invalid --ready-fd %d", fd)
+	}
+	defer file.Close()
+	_, err = file.Write(append(content, '\n'))
+	if err != nil {
+		return fmt.Errorf("Failed to write --ready-fd %d. %s", fd, err)
+	}
+	return nil
+}
+
+func validateReady(commandLine *CommandLine) error {
+	if fd := commandLine.String("--ready-fd"); len(fd) > 0 {
+		n, err := strconv.Atoi(fd)
+		if err != nil || n < 3 {
+			return errors.New("[ERR] --ready-fd must be a file descriptor of 3 or higher")
+		}
+	}
+	if commandLine.Bool("--ready-handshake") && len(commandLine.String("--ready-file")) < 1 && len(commandLine.String("--ready-fd")) < 1 {
+		return errors.New("[ERR] --ready-handshake requires --ready-file or --ready-fd")
+	}
+	return nil
+}
+
+// signalReady verifies a handshake with --ready-handshake and writes the
+// readiness of the forward to --ready-file and --ready-fd. A forward without
+// hosts passing the health check isn't ready. Returns a function removing the
+// ready file on shutdown.
+func (c *CfServiceJumperPlugin) signalReady(commandLine *CommandLine, serviceInstance ServiceInstance, forwardInfo ForwardDataSet, tunnels []*xtunnel.XTunnel, healthCheck []HealthCheckResult) (func(), error) {
+	filePath, fd := commandLine.String("--ready-file"), commandLine.String("--ready-fd")
+	if len(filePath) < 1 && len(fd) < 1 {
+		return func() {}, nil
+	}
+
+	readiness := NewReadiness(forwardInfo.ID, serviceInstance.Name, tunnelsOf(tunnels))
+	if healthCheck != nil {
+		readiness.HealthyHosts = []string{}
+		for _, result := range healthCheck {
+			if result.Err == nil {
+				readiness.HealthyHosts = append(readiness.HealthyHosts, result.Host)
+			}
+		}
+		if len(readiness.HealthyHosts) < 1 {
+			return nil, &ReadinessError{Err: errors.New("No host passed the health check")}
+		}
+	}
+	if commandLine.Bool("--ready-handshake") {
+		probe, err := PSKProbe(forwardInfo.SharedSecret, ReadyHandshakeTimeout)
+		if err != nil {
+			return nil, &ReadinessError{Err: err}
+		}
+		readiness.Handshake, err = VerifyHandshake(readiness.Hosts, probe)
+		if err != nil {
+			return nil, &ReadinessError{Err: err}
+		}
+		c.logger.Infof("forward %d ready, handshake with %s verified", forwardInfo.ID, readiness.Handshake)
+	}
+
+	removeReadyFile := func() {}
+	if len(filePath) > 0 {
+		err := WriteReadyFile(filePath, readiness)
+		if err != nil {
+			return nil, &ReadinessError{Err: err}
+		}
+		removeReadyFile = func() { os.Remove(filePath) }
+	}
+	// the descriptor is written last since a reader can't take it back
+	if len(fd) > 0 {
+		n, _ := strconv.Atoi(fd)
+		err := WriteReadyFD(n, readiness)
+		if err != nil {
+			removeReadyFile()
+			return nil, &ReadinessError{Err: err}
+		}
+	}
+	return removeReadyFile, nil
+}
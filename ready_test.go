@@ -0,0 +1,94 @@
+package main_test
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+
+	. "github.com/anynines/cf_service_jumper_cli_plugin"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// init turns the test binary into the helper process of the --ready-fd test,
+// which writes the readiness to its inherited file descriptor 3
+func init() {
+	if os.Getenv("READY_FD_HELPER") == "1" {
+		err := WriteReadyFD(3, NewReadiness(42, "db", []Tunnel{&fakeTunnel{}}))
+		if err != nil {
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+}
+
+var _ = Describe("Readiness", func() {
+	readiness := func() Readiness {
+		return NewReadiness(42, "db", []Tunnel{&fakeTunnel{}})
+	}
+
+	It("describes ports and hosts of the tunnels", func() {
+		r := readiness()
+		Expect(r.Ports).To(Equal([]int{5000}))
+		Expect(r.Hosts).To(Equal([]string{"10.0.0.1:5432"}))
+		Expect(r.Tunnels).To(Equal([]ReadyTunnel{{LocalAddress: "127.0.0.1:5000", Port: 5000, Host: "10.0.0.1:5432"}}))
+	})
+
+	It("verifies a handshake with the first host accepting it", func() {
+		host, err := VerifyHandshake([]string{"10.0.0.1:5432", "10.0.0.2:5432"}, func(host string) error {
+			if host == "10.0.0.1:5432" {
+				return errors.New("connection refused")
+			}
+			return nil
+		})
+		Expect(err).To(BeNil())
+		Expect(host).To(Equal("10.0.0.2:5432"))
+
+		_, err = VerifyHandshake([]string{"10.0.0.1:5432"}, func(host string) error { return errors.New("connection refused") })
+		Expect(err).To(MatchError(ContainSubstring("10.0.0.1:5432: connection refused")))
+	})
+
+	It("writes the ready file atomically", func() {
+		dir, err := ioutil.TempDir("", "ready")
+		Expect(err).To(BeNil())
+		defer os.RemoveAll(dir)
+		filePath := filepath.Join(dir, "forward.ready")
+
+		Expect(WriteReadyFile(filePath, readiness())).To(Succeed())
+		content, err := ioutil.ReadFile(filePath)
+		Expect(err).To(BeNil())
+		var written Readiness
+		Expect(json.Unmarshal(content, &written)).To(Succeed())
+		Expect(written.ForwardID).To(Equal(42))
+
+		// no temporary file is left behind
+		files, err := ioutil.ReadDir(dir)
+		Expect(err).To(BeNil())
+		Expect(files).To(HaveLen(1))
+	})
+
+	It("writes a line to an inherited file descriptor and closes it", func() {
+		reader, writer, err := os.Pipe()
+		Expect(err).To(BeNil())
+		defer reader.Close()
+
+		helper := exec.Command(os.Args[0])
+		helper.Env = append(os.Environ(), "READY_FD_HELPER=1")
+		// ExtraFiles[0] becomes the file descriptor 3 of the helper
+		helper.ExtraFiles = []*os.File{writer}
+		Expect(helper.Start()).To(Succeed())
+		writer.Close()
+
+		line, err := bufio.NewReader(reader).ReadString('\n')
+		Expect(err).To(BeNil())
+		Expect(line).To(ContainSubstring(`"forward_id":42`))
+		Expect(helper.Wait()).To(Succeed())
+		_, err = reader.Read(make([]byte, 1))
+		Expect(err).ToNot(BeNil())
+	})
+})
@@ -0,0 +1,219 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/anynines/cf_service_jumper_cli_plugin/jumperapi"
+	"github.com/cloudfoundry/cli/plugin"
+)
+
+var (
+	ErrUserProvidedServiceForward = errors.New("[ERR] user provided service instances can't be forwarded")
+)
+
+// ServiceQuery identifies the service instance to forward to. The lookup is
+// skipped if GUID is set, Org and Space search outside the targeted space.
+type ServiceQuery struct {
+	Name  string
+	GUID  string
+	Org   string
+	Space string
+}
+
+// ServiceInstance is a resolved service instance
+type ServiceInstance struct {
+	GUID     string
+	Name     string
+	Offering string
+	Plan     string
+	Space    string
+}
+
+// AmbiguousServiceInstanceError is returned if several service instances match the query
+type AmbiguousServiceInstanceError struct {
+	Name    string
+	Matches []ServiceInstance
+}
+
+func (e *AmbiguousServiceInstanceError) Error() string {
+	matches := make([]string, len(e.Matches))
+	for i, match := range e.Matches {
+		matches[i] = fmt.Sprintf("%s in space %s", match.GUID, match.Space)
+	}
+	return fmt.Sprintf("[ERR] %d service instances named %s found: %s", len(e.Matches), e.Name, strings.Join(matches, ", "))
+}
+
+// Hint returns a human readable hint how to solve the error
+func (e *AmbiguousServiceInstanceError) Hint() string {
+	return "Select the service instance with --space SPACE or --guid GUID."
+}
+
+// ResolveServiceInstance looks up the service instance of the query. Instances
+// of the targeted space are looked up by the cf cli, others by the cf v3 api.
+func (c *CfServiceJumperPlugin) ResolveServiceInstance(cliConnection plugin.CliConnection, query ServiceQuery) (ServiceInstance, error) {
+	if len(query.GUID) > 0 {
+		return ServiceInstance{GUID: query.GUID, Name: query.GUID}, nil
+	}
+	if len(query.Name) < 1 {
+		return ServiceInstance{}, ErrMissingServiceInstanceArg
+	}
+
+	if len(query.Org) < 1 && len(query.Space) < 1 {
+		service, err := cliConnection.GetService(query.Name)
+		if err != nil {
+			return ServiceInstance{}, fmt.Errorf("[ERR] Failed to get service %s. %s", query.Name, err)
+		}
+		if service.IsUserProvided {
+			return ServiceInstance{}, ErrUserProvidedServiceForward
+		}
+		return ServiceInstance{
+			GUID:     service.Guid,
+			Name:     service.Name,
+			Offering: service.ServiceOffering.Name,
+			Plan:     service.ServicePlan.Name,
+		}, nil
+	}
+
+	cfAPIClient, err := c.newCfAPIClient(cliConnection)
+	if err != nil {
+		return ServiceInstance{}, err
+	}
+	return resolveServiceInstanceV3(cliConnection, cfAPIClient, query)
+}
+
+func (c *CfServiceJumperPlugin) newCfAPIClient(cliConnection plugin.CliConnection) (CfAPIClient, error) {
+	apiEndpoint, err := cliConnection.ApiEndpoint()
+	if err != nil {
+		return CfAPIClient{}, err
+	}
+	httpClient, err := c.cfHTTPClient()
+	if err != nil {
+		return CfAPIClient{}, err
+	}
+
+	return CfAPIClient{
+		Endpoint:    apiEndpoint,
+		HTTPClient:  httpClient,
+		TokenSource: jumperapi.NewRefreshingTokenSource(cliConnection.AccessToken),
+	}, nil
+}
+
+type cfV3Resource struct {
+	GUID          string `json:"guid"`
+	Name          string `json:"name"`
+	Type          string `json:"type"`
+	Relationships map[string]struct {
+		Data struct {
+			GUID string `json:"guid"`
+		} `json:"data"`
+	} `json:"relationships"`
+}
+
+type cfV3List struct {
+	Resources []cfV3Resource            `json:"resources"`
+	Included  map[string][]cfV3Resource `json:"included"`
+}
+
+// included returns the included resource of the relationship, e.g. the space of a service instance
+func (l cfV3List) included(resource cfV3Resource, relationship string, kind string) cfV3Resource {
+	guid := resource.Relationships[relationship].Data.GUID
+	for _, includedResource := range l.Included[kind] {
+		if includedResource.GUID == guid {
+			return includedResource
+		}
+	}
+	return cfV3Resource{}
+}
+
+// resolveServiceInstanceV3 searches the service instance in the space of the org.
+// The targeted org applies if only the space is given, all spaces of the org are
+// searched if only the org is given. The search filters by space only, so
+// instances shared into the spaces from other orgs are found as well.
+func resolveServiceInstanceV3(cliConnection plugin.CliConnection, cfAPIClient CfAPIClient, query ServiceQuery) (ServiceInstance, error) {
+	orgGUID, err := resolveOrgGUID(cliConnection, cfAPIClient, query.Org)
+	if err != nil {
+		return ServiceInstance{}, err
+	}
+
+	spacesQuery := url.Values{"organization_guids": {orgGUID}, "per_page": {"5000"}}
+	if len(query.Space) > 0 {
+		spacesQuery.Set("names", query.Space)
+	}
+	var spaces cfV3List
+	err = cfAPIClient.Get("/v3/spaces", spacesQuery, &spaces)
+	if err != nil {
+		return ServiceInstance{}, err
+	}
+	if len(spaces.Resources) < 1 {
+		if len(query.Space) > 0 {
+			return ServiceInstance{}, fmt.Errorf("[ERR] space %s not found", query.Space)
+		}
+		return ServiceInstance{}, fmt.Errorf("[ERR] service instance %s not found", query.Name)
+	}
+	spaceGUIDs := make([]string, len(spaces.Resources))
+	for i, space := range spaces.Resources {
+		spaceGUIDs[i] = space.GUID
+	}
+
+	params := url.Values{
+		"names":                                 {query.Name},
+		"space_guids":                           {strings.Join(spaceGUIDs, ",")},
+		"fields[space]":                         {"guid,name"},
+		"fields[service_plan]":                  {"guid,name,relationships.service_offering"},
+		"fields[service_plan.service_offering]": {"guid,name"},
+	}
+
+	var serviceInstances cfV3List
+	err = cfAPIClient.Get("/v3/service_instances", params, &serviceInstances)
+	if err != nil {
+		return ServiceInstance{}, err
+	}
+
+	matches := make([]ServiceInstance, len(serviceInstances.Resources))
+	for i, resource := range serviceInstances.Resources {
+		plan := serviceInstances.included(resource, "service_plan", "service_plans")
+		matches[i] = ServiceInstance{
+			GUID:     resource.GUID,
+			Name:     resource.Name,
+			Offering: serviceInstances.included(plan, "service_offering", "service_offerings").Name,
+			Plan:     plan.Name,
+			Space:    serviceInstances.included(resource, "space", "spaces").Name,
+		}
+	}
+
+	switch {
+	case len(matches) < 1:
+		return ServiceInstance{}, fmt.Errorf("[ERR] service instance %s not found", query.Name)
+	case len(matches) > 1:
+		return ServiceInstance{}, &AmbiguousServiceInstanceError{Name: query.Name, Matches: matches}
+	case serviceInstances.Resources[0].Type == "user-provided":
+		return ServiceInstance{}, ErrUserProvidedServiceForward
+	}
+	return matches[0], nil
+}
+
+func resolveOrgGUID(cliConnection plugin.CliConnection, cfAPIClient CfAPIClient, org string) (string, error) {
+	if len(org) < 1 {
+		currentOrg, err := cliConnection.GetCurrentOrg()
+		if err != nil {
+			return "", err
+		}
+		if len(currentOrg.Guid) < 1 {
+			return "", errors.New("[ERR] no org targeted. Use --org ORG or 'cf target -o ORG'")
+		}
+		return currentOrg.Guid, nil
+	}
+
+	var orgs cfV3List
+	err := cfAPIClient.Get("/v3/organizations", url.Values{"names": {org}}, &orgs)
+	if err != nil {
+		return "", err
+	}
+	if len(orgs.Resources) < 1 {
+		return "", fmt.Errorf("[ERR] org %s not found", org)
+	}
+	return orgs.Resources[0].GUID, nil
+}
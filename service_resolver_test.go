@@ -0,0 +1,163 @@
+package main_test
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+
+	plugin_models "code.cloudfoundry.org/cli/plugin/models"
+	. "github.com/anynines/cf_service_jumper_cli_plugin"
+	"github.com/cloudfoundry/cli/plugin"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// fakeCliConnection fakes the cli connection methods used by the plugin. The
+// counterfeiter fakes of the cli use a different plugin_models package.
+type fakeCliConnection struct {
+	plugin.CliConnection
+
+	apiEndpoint string
+	services    map[string]plugin_models.GetService_Model
+}
+
+func (f fakeCliConnection) ApiEndpoint() (string, error) { return f.apiEndpoint, nil }
+func (f fakeCliConnection) AccessToken() (string, error) { return "bearer the_token", nil }
+func (f fakeCliConnection) IsSSLDisabled() (bool, error) { return false, nil }
+func (f fakeCliConnection) IsLoggedIn() (bool, error)    { return true, nil }
+func (f fakeCliConnection) CliCommandWithoutTerminalOutput(args ...string) ([]string, error) {
+	return []string{"cf version 6.53.0"}, nil
+}
+func (f fakeCliConnection) GetCurrentOrg() (plugin_models.Organization, error) {
+	return plugin_models.Organization{OrganizationFields: plugin_models.OrganizationFields{Guid: "current-org-guid", Name: "current-org"}}, nil
+}
+func (f fakeCliConnection) GetService(name string) (plugin_models.GetService_Model, error) {
+	service, ok := f.services[name]
+	if !ok {
+		return service, errors.New("Service instance " + name + " not found")
+	}
+	return service, nil
+}
+
+var _ = Describe("ResolveServiceInstance", func() {
+	var cfServer *httptest.Server
+	var serviceInstances string
+	var serviceInstancesQuery url.Values
+	var cliConnection fakeCliConnection
+	var cfPlugin *CfServiceJumperPlugin
+
+	BeforeEach(func() {
+		serviceInstances = `{"resources": []}`
+		cfServer = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			Expect(r.Header.Get("Authorization")).To(Equal("bearer the_token"))
+			query := r.URL.Query()
+			switch r.URL.Path {
+			case "/v3/organizations":
+				Expect(query.Get("names")).To(Equal("other-org"))
+				fmt.Fprint(w, `{"resources": [{"guid": "other-org-guid", "name": "other-org"}]}`)
+			case "/v3/spaces":
+				switch query.Get("organization_guids") {
+				case "current-org-guid":
+					Expect(query.Get("names")).To(Equal("other-space"))
+					fmt.Fprint(w, `{"resources": [{"guid": "other-space-guid", "name": "other-space"}]}`)
+				case "other-org-guid":
+					fmt.Fprint(w, `{"resources": [{"guid": "dev-guid", "name": "dev"}, {"guid": "prod-guid", "name": "prod"}]}`)
+				}
+			case "/v3/service_instances":
+				Expect(query.Get("names")).To(Equal("db"))
+				// shared instances are only found by space
+				Expect(query).ToNot(HaveKey("organization_guids"))
+				serviceInstancesQuery = query
+				fmt.Fprint(w, serviceInstances)
+			default:
+				w.WriteHeader(http.StatusNotFound)
+				fmt.Fprint(w, `{"errors": [{"detail": "Unknown request"}]}`)
+			}
+		}))
+
+		cliConnection = fakeCliConnection{
+			apiEndpoint: cfServer.URL,
+			services: map[string]plugin_models.GetService_Model{
+				"db": {
+					Guid:            "db-guid",
+					Name:            "db",
+					ServiceOffering: plugin_models.GetService_ServiceFields{Name: "a9s-postgresql10"},
+					ServicePlan:     plugin_models.GetService_ServicePlan{Name: "postgresql-single-small"},
+				},
+				"ups": {Guid: "ups-guid", Name: "ups", IsUserProvided: true},
+			},
+		}
+		cfPlugin = &CfServiceJumperPlugin{}
+	})
+
+	AfterEach(func() {
+		cfServer.Close()
+	})
+
+	It("skips the lookup with a guid", func() {
+		serviceInstance, err := cfPlugin.ResolveServiceInstance(cliConnection, ServiceQuery{GUID: "the-guid"})
+		Expect(err).To(BeNil())
+		Expect(serviceInstance).To(Equal(ServiceInstance{GUID: "the-guid", Name: "the-guid"}))
+	})
+
+	It("looks up instances of the targeted space with the cli", func() {
+		serviceInstance, err := cfPlugin.ResolveServiceInstance(cliConnection, ServiceQuery{Name: "db"})
+		Expect(err).To(BeNil())
+		Expect(serviceInstance).To(Equal(ServiceInstance{GUID: "db-guid", Name: "db", Offering: "a9s-postgresql10", Plan: "postgresql-single-small"}))
+	})
+
+	It("rejects user provided services", func() {
+		_, err := cfPlugin.ResolveServiceInstance(cliConnection, ServiceQuery{Name: "ups"})
+		Expect(err).To(Equal(ErrUserProvidedServiceForward))
+	})
+
+	It("looks up instances of other spaces with the v3 api", func() {
+		serviceInstances = `{
+			"resources": [{"guid": "other-db-guid", "name": "db", "type": "managed", "relationships": {"space": {"data": {"guid": "other-space-guid"}}, "service_plan": {"data": {"guid": "plan-guid"}}}}],
+			"included": {
+				"spaces": [{"guid": "other-space-guid", "name": "other-space"}],
+				"service_plans": [{"guid": "plan-guid", "name": "redis-single-small", "relationships": {"service_offering": {"data": {"guid": "offering-guid"}}}}],
+				"service_offerings": [{"guid": "offering-guid", "name": "a9s-redis50"}]
+			}
+		}`
+
+		serviceInstance, err := cfPlugin.ResolveServiceInstance(cliConnection, ServiceQuery{Name: "db", Space: "other-space"})
+		Expect(err).To(BeNil())
+		Expect(serviceInstance).To(Equal(ServiceInstance{GUID: "other-db-guid", Name: "db", Offering: "a9s-redis50", Plan: "redis-single-small", Space: "other-space"}))
+	})
+
+	It("finds instances shared into the spaces of the org from another org", func() {
+		serviceInstances = `{
+			"resources": [{"guid": "shared-db-guid", "name": "db", "type": "managed", "relationships": {"space": {"data": {"guid": "owner-space-guid"}}}}],
+			"included": {"spaces": [{"guid": "owner-space-guid", "name": "owner-space"}]}
+		}`
+
+		serviceInstance, err := cfPlugin.ResolveServiceInstance(cliConnection, ServiceQuery{Name: "db", Org: "other-org"})
+		Expect(err).To(BeNil())
+		Expect(serviceInstance.GUID).To(Equal("shared-db-guid"))
+		Expect(serviceInstance.Space).To(Equal("owner-space"))
+		Expect(serviceInstancesQuery.Get("space_guids")).To(Equal("dev-guid,prod-guid"))
+	})
+
+	It("errors if several instances match", func() {
+		serviceInstances = `{
+			"resources": [
+				{"guid": "guid-1", "name": "db", "relationships": {"space": {"data": {"guid": "space-1"}}}},
+				{"guid": "guid-2", "name": "db", "relationships": {"space": {"data": {"guid": "space-2"}}}}
+			],
+			"included": {"spaces": [{"guid": "space-1", "name": "dev"}, {"guid": "space-2", "name": "prod"}]}
+		}`
+
+		_, err := cfPlugin.ResolveServiceInstance(cliConnection, ServiceQuery{Name: "db", Org: "other-org"})
+		Expect(err).To(BeAssignableToTypeOf(&AmbiguousServiceInstanceError{}))
+		Expect(err.Error()).To(Equal("[ERR] 2 service instances named db found: guid-1 in space dev, guid-2 in space prod"))
+	})
+
+	It("errors if no instance matches", func() {
+		_, err := cfPlugin.ResolveServiceInstance(cliConnection, ServiceQuery{Name: "db", Org: "other-org"})
+		Expect(err).To(MatchError("[ERR] service instance db not found"))
+	})
+})
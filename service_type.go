@@ -0,0 +1,108 @@
+package main
+
+import (
+	"strings"
+)
+
+// ServiceType identifies the data service behind a forward
+type ServiceType string
+
+const (
+	ServiceTypeUnknown       ServiceType = ""
+	ServiceTypePostgres      ServiceType = "postgresql"
+	ServiceTypeMongodb       ServiceType = "mongodb"
+	ServiceTypeRabbitMQ      ServiceType = "rabbitmq"
+	ServiceTypeRedis         ServiceType = "redis"
+	ServiceTypeMariaDB       ServiceType = "mariadb"
+	ServiceTypeElasticsearch ServiceType = "elasticsearch"
+)
+
+// ServiceFeatures describes the protocol features of a service type
+type ServiceFeatures struct {
+	// URISchemes known for credentials uris of the service
+	URISchemes []string
+	// OfferingPrefixes of a9s service offerings without the a9s- prefix
+	OfferingPrefixes []string
+	// MultiHost is true if a connection string may contain several hosts
+	MultiHost bool
+	// JdbcSubprotocol is blank if there is no JDBC driver
+	JdbcSubprotocol string
+}
+
+var serviceTypeFeatures = map[ServiceType]ServiceFeatures{
+	ServiceTypePostgres: ServiceFeatures{
+		URISchemes:       []string{"postgres", "postgresql"},
+		OfferingPrefixes: []string{"postgresql", "postgres"},
+		MultiHost:        true,
+		JdbcSubprotocol:  "postgresql",
+	},
+	ServiceTypeMongodb: ServiceFeatures{
+		URISchemes:       []string{"mongodb"},
+		OfferingPrefixes: []string{"mongodb"},
+		MultiHost:        true,
+	},
+	ServiceTypeRabbitMQ: ServiceFeatures{
+		URISchemes:       []string{"amqp", "amqps"},
+		OfferingPrefixes: []string{"rabbitmq"},
+	},
+	ServiceTypeRedis: ServiceFeatures{
+		URISchemes:       []string{"redis", "rediss"},
+		OfferingPrefixes: []string{"redis"},
+	},
+	ServiceTypeMariaDB: ServiceFeatures{
+		URISchemes:       []string{"mysql", "mariadb"},
+		OfferingPrefixes: []string{"mariadb", "mysql"},
+		JdbcSubprotocol:  "mysql",
+	},
+	// http uris don't identify a service, elasticsearch is only detected by the offering
+	ServiceTypeElasticsearch: ServiceFeatures{
+		OfferingPrefixes: []string{"elasticsearch", "search", "logme"},
+	},
+}
+
+// Features returns the protocol features of the service type
+func (t ServiceType) Features() ServiceFeatures {
+	return serviceTypeFeatures[t]
+}
+
+// ServiceTypeFromOffering maps a9s service offerings, e.g. a9s-postgresql10, to service types
+func ServiceTypeFromOffering(offering string) ServiceType {
+	offering = strings.TrimPrefix(strings.ToLower(offering), "a9s-")
+	if len(offering) < 1 {
+		return ServiceTypeUnknown
+	}
+
+	for serviceType, features := range serviceTypeFeatures {
+		for _, prefix := range features.OfferingPrefixes {
+			if strings.HasPrefix(offering, prefix) {
+				return serviceType
+			}
+		}
+	}
+	return ServiceTypeUnknown
+}
+
+// ServiceTypeFromURIScheme maps uri schemes, e.g. postgres, to service types
+func ServiceTypeFromURIScheme(scheme string) ServiceType {
+	for serviceType, features := range serviceTypeFeatures {
+		if stringInStrSlice(scheme, features.URISchemes) {
+			return serviceType
+		}
+	}
+	return ServiceTypeUnknown
+}
+
+// DetectServiceType detects the service type by the service offering and falls
+// back to the scheme of the credentials uri.
+func DetectServiceType(offering string, credentials map[string]string) ServiceType {
+	serviceType := ServiceTypeFromOffering(offering)
+	if serviceType != ServiceTypeUnknown {
+		return serviceType
+	}
+
+	serviceURI, err := ParseServiceURI(credentials["uri"])
+	if err != nil {
+		return ServiceTypeUnknown
+	}
+	return ServiceTypeFromURIScheme(serviceURI.Scheme)
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"encoding/base64"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// isTerminal reports whether writer is a terminal
+func isTerminal(writer interface{}) bool {
+	file, ok := writer.(*os.File)
+	if !ok {
+		return false
+	}
+	info, err := file.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// setCbreakMode passes key presses of the terminal of stdin without waiting
+// for enter and without echo. Returns a function restoring the previous mode.
+// Fails where stty isn't available, e.g. on windows.
+func setCbreakMode() (func(), error) {
+	state, err := stty("-g")
+	if err != nil {
+		return nil, err
+	}
+	_, err = stty("-icanon", "-echo", "min", "1")
+	if err != nil {
+		return nil, err
+	}
+	return func() { stty(strings.TrimSpace(state)) }, nil
+}
+
+func stty(args ...string) (string, error) {
+	cmd := exec.Command("stty", args...)
+	cmd.Stdin = os.Stdin
+	output, err := cmd.Output()
+	return string(output), err
+}
+
+// ANSI escape sequences of the dashboard
+const (
+	terminalAlternateScreen = "\x1b[?1049h\x1b[?25l"
+	terminalMainScreen      = "\x1b[?25h\x1b[?1049l"
+	terminalClear           = "\x1b[H\x1b[2J"
+)
+
+// writeClipboard copies text to the clipboard of the terminal with OSC 52,
+// which also works in ssh sessions of terminals supporting it
+func writeClipboard(out io.Writer, text string) error {
+	_, err := io.WriteString(out, "\x1b]52;c;"+base64.StdEncoding.EncodeToString([]byte(text))+"\a")
+	return err
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	"github.com/anynines/cf_service_jumper_cli_plugin/plugin/config"
+)
+
+var ErrCertificateNotPinned = errors.New("[ERR] certificate of the service jumper doesn't match any pinned public key")
+
+// TLSOptions configures the trust of https connections
+type TLSOptions struct {
+	// SkipSSLValidation mirrors cf api --skip-ssl-validation
+	SkipSSLValidation bool
+	// CAFile is a PEM bundle trusted in addition to the system roots, e.g. of an internal PKI
+	CAFile string
+	// PinnedSHA256 are base64 encoded sha256 hashes of public keys (SPKI), one
+	// of them must be part of the verified certificate chain, or be the leaf if
+	// the verification is skipped
+	PinnedSHA256 []string
+}
+
+// TLSOptionsFromConfig reads the tls options from the ca_file and pinned_sha256
+// settings, overridden by CF_FORWARD_CA_FILE and CF_FORWARD_PINNED_SHA256.
+func TLSOptionsFromConfig(forwardConfig config.ForwardConfig, skipSSLValidation bool) TLSOptions {
+	return TLSOptions{
+		SkipSSLValidation: skipSSLValidation,
+		CAFile:            forwardConfig.Value(config.SettingCAFile),
+		PinnedSHA256:      forwardConfig.List(config.SettingPinnedSHA256),
+	}
+}
+
+// NewTLSConfig creates the tls config. Pins are only checked if checkPins is
+// set since they apply to the service jumper only.
+func NewTLSConfig(tlsOptions TLSOptions, checkPins bool) (*tls.Config, error) {
+	tlsConfig := &tls.Config{InsecureSkipVerify: tlsOptions.SkipSSLValidation}
+
+	if len(tlsOptions.CAFile) > 0 {
+		pem, err := ioutil.ReadFile(tlsOptions.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("[ERR] Failed to read CA file. %s", err)
+		}
+
+		rootCAs, err := x509.SystemCertPool()
+		if err != nil || rootCAs == nil {
+			rootCAs = x509.NewCertPool()
+		}
+		if !rootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("[ERR] CA file %s doesn't contain PEM encoded certificates", tlsOptions.CAFile)
+		}
+		tlsConfig.RootCAs = rootCAs
+	}
+
+	if checkPins && len(tlsOptions.PinnedSHA256) > 0 {
+		pins := make(map[string]bool)
+		for _, pin := range tlsOptions.PinnedSHA256 {
+			pins[strings.TrimPrefix(strings.TrimSpace(pin), "sha256//")] = true
+		}
+
+		// also called if the verification is skipped, so pins are always enforced.
+		// rawCerts are chosen by the peer, pins are matched against verified
+		// chains or, without verification, the leaf only.
+		tlsConfig.VerifyPeerCertificate = func(rawCerts [][]byte, verifiedChains [][]*x509.Certificate) error {
+			var certs []*x509.Certificate
+			for _, chain := range verifiedChains {
+				certs = append(certs, chain...)
+			}
+			if len(verifiedChains) < 1 && len(rawCerts) > 0 {
+				leaf, err := x509.ParseCertificate(rawCerts[0])
+				if err != nil {
+					return err
+				}
+				certs = append(certs, leaf)
+			}
+			for _, cert := range certs {
+				if pins[PublicKeySHA256(cert)] {
+					return nil
+				}
+			}
+			return ErrCertificateNotPinned
+		}
+	}
+
+	return tlsConfig, nil
+}
+
+// PublicKeySHA256 returns the base64 encoded sha256 hash of the certificate's public key
+func PublicKeySHA256(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
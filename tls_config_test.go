@@ -0,0 +1,117 @@
+package main_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"time"
+
+	. "github.com/anynines/cf_service_jumper_cli_plugin"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// selfSignedCertificate returns a certificate of a foreign key
+func selfSignedCertificate() *x509.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	Expect(err).To(BeNil())
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "foreign"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	raw, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	Expect(err).To(BeNil())
+	cert, err := x509.ParseCertificate(raw)
+	Expect(err).To(BeNil())
+	return cert
+}
+
+var _ = Describe("NewTLSConfig", func() {
+	var server *httptest.Server
+	var caFile string
+
+	get := func(tlsOptions TLSOptions, checkPins bool) error {
+		tlsConfig, err := NewTLSConfig(tlsOptions, checkPins)
+		Expect(err).To(BeNil())
+
+		client := &http.Client{Transport: &http.Transport{TLSClientConfig: tlsConfig}}
+		resp, err := client.Get(server.URL)
+		if err == nil {
+			resp.Body.Close()
+		}
+		return err
+	}
+
+	BeforeEach(func() {
+		server = httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+		f, err := ioutil.TempFile("", "ca")
+		Expect(err).To(BeNil())
+		pem.Encode(f, &pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+		f.Close()
+		caFile = f.Name()
+	})
+
+	AfterEach(func() {
+		server.Close()
+		os.Remove(caFile)
+	})
+
+	It("rejects unknown certificates", func() {
+		Expect(get(TLSOptions{}, false)).ToNot(Succeed())
+	})
+
+	It("trusts certificates of the CA file", func() {
+		Expect(get(TLSOptions{CAFile: caFile}, false)).To(Succeed())
+	})
+
+	It("errors if the CA file doesn't exist", func() {
+		_, err := NewTLSConfig(TLSOptions{CAFile: "/does/not/exist.pem"}, false)
+		Expect(err).ToNot(BeNil())
+	})
+
+	It("accepts pinned public keys", func() {
+		pin := "sha256//" + PublicKeySHA256(server.Certificate())
+		Expect(get(TLSOptions{CAFile: caFile, PinnedSHA256: []string{pin}}, true)).To(Succeed())
+	})
+
+	It("enforces pins even if ssl validation is skipped", func() {
+		err := get(TLSOptions{SkipSSLValidation: true, PinnedSHA256: []string{"AAAA"}}, true)
+		Expect(err).ToNot(BeNil())
+		Expect(err.Error()).To(ContainSubstring(ErrCertificateNotPinned.Error()))
+	})
+
+	It("rejects a foreign leaf with the pinned certificate as extra chain entry", func() {
+		pin := "sha256//" + PublicKeySHA256(server.Certificate())
+		foreign := selfSignedCertificate()
+		rawCerts := [][]byte{foreign.Raw, server.Certificate().Raw}
+
+		for _, skipSSLValidation := range []bool{true, false} {
+			tlsConfig, err := NewTLSConfig(TLSOptions{SkipSSLValidation: skipSSLValidation, PinnedSHA256: []string{pin}}, true)
+			Expect(err).To(BeNil())
+			var verifiedChains [][]*x509.Certificate
+			if !skipSSLValidation {
+				verifiedChains = [][]*x509.Certificate{{foreign}}
+			}
+			Expect(tlsConfig.VerifyPeerCertificate(rawCerts, verifiedChains)).To(MatchError(ErrCertificateNotPinned))
+		}
+
+		tlsConfig, err := NewTLSConfig(TLSOptions{PinnedSHA256: []string{pin}}, true)
+		Expect(err).To(BeNil())
+		Expect(tlsConfig.VerifyPeerCertificate(rawCerts, [][]*x509.Certificate{{foreign, server.Certificate()}})).To(Succeed())
+	})
+
+	It("ignores pins if not requested", func() {
+		Expect(get(TLSOptions{CAFile: caFile, PinnedSHA256: []string{"AAAA"}}, false)).To(Succeed())
+	})
+})
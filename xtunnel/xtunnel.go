@@ -3,16 +3,69 @@ package xtunnel
 import (
 	"fmt"
 	"net"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/raff/tls-ext"
 	"github.com/raff/tls-psk"
 )
 
+// EventType names the events of a tunnel
+type EventType string
+
+const (
+	EventListen   EventType = "listen"
+	EventAccept   EventType = "accept"
+	EventDial     EventType = "dial"
+	EventClose    EventType = "close"
+	EventShutdown EventType = "shutdown"
+)
+
+// Event is emitted on listen, accept, dial (with Err if it failed), close of a
+// client connection and shutdown of the tunnel
+type Event struct {
+	Type          EventType
+	LocalAddress  string
+	ClientAddress string
+	RemoteAddress string
+	// Duration of the dial or the lifetime of the closed connection
+	Duration time.Duration
+	// Handshake is the TLS handshake part of the dial duration
+	Handshake time.Duration
+	Err       error
+}
+
+// Stats are the counters of a tunnel
+type Stats struct {
+	// Accepted client connections
+	Accepted int
+	// Active client connections with an open remote connection
+	Active int
+	// Failed dials of the remote service
+	Failed int
+	// BytesSent from clients to the remote service
+	BytesSent uint64
+	// BytesReceived from the remote service
+	BytesReceived uint64
+	// LastError of a dial and its time
+	LastError   error
+	LastErrorAt time.Time
+}
+
 type XTunnel struct {
 	localService  string
 	remoteService string
 	localListener net.Listener
 	config        *tls.Config
+
+	// OnEvent is called for every event if set. It must not block.
+	OnEvent func(event Event)
+
+	statsMutex sync.Mutex
+	stats      Stats
+	// connections maps open client connections to their remote connections
+	connections map[net.Conn]net.Conn
 }
 
 func NewUnencryptedXTunnel(remoteService string) *XTunnel {
@@ -30,7 +83,35 @@ func NewXTunnel(localService, remoteService string) *XTunnel {
 
 // NewXTunnelPSK creates a new XTunnel instance using TLS-PSK
 func NewXTunnelPSK(localService, remoteService, pskIdentity, pskey string) *XTunnel {
-	config := &tls.Config{
+	return createXTunnel(localService, remoteService, pskConfig(pskIdentity, pskey))
+}
+
+// HandshakePSK connects to remoteService and performs the TLS-PSK handshake of
+// the tunnels without sending data
+func HandshakePSK(remoteService, pskIdentity, pskey string, timeout time.Duration) error {
+	conn, err := net.DialTimeout("tcp", remoteService, timeout)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	config := pskConfig(pskIdentity, pskey)
+	config.ServerName = serverName(remoteService)
+
+	conn.SetDeadline(time.Now().Add(timeout))
+	return tls.Client(conn, config).Handshake()
+}
+
+// serverName returns the server name tls.Dial infers from the address
+func serverName(address string) string {
+	if colon := strings.LastIndex(address, ":"); colon >= 0 {
+		return address[:colon]
+	}
+	return address
+}
+
+func pskConfig(pskIdentity, pskey string) *tls.Config {
+	return &tls.Config{
 		CipherSuites: []uint16{psk.TLS_PSK_WITH_AES_128_CBC_SHA, psk.TLS_PSK_WITH_AES_256_CBC_SHA},
 		Extra: psk.PSKConfig{
 			GetKey: func(id string) ([]byte, error) {
@@ -41,8 +122,6 @@ func NewXTunnelPSK(localService, remoteService, pskIdentity, pskey string) *XTun
 			},
 		},
 	}
-
-	return createXTunnel(localService, remoteService, config)
 }
 
 // Listen creates the listening socket.
@@ -50,37 +129,57 @@ func (xt *XTunnel) Listen() (string, error) {
 	var err error
 	xt.localListener, err = net.Listen("tcp", xt.localService)
 	if err != nil {
-		return "", fmt.Errorf("[ERR] Failed to listen on a random tcp socket. %s", err)
+		err = fmt.Errorf("[ERR] Failed to listen on a random tcp socket. %s", err)
+		xt.emit(Event{Type: EventListen, LocalAddress: xt.localService, Err: err})
+		return "", err
 	}
+	xt.emit(Event{Type: EventListen})
 	return xt.localListener.Addr().String(), nil
 }
 
-// Serve waits for client connections to be processed. Blocks!
+// Serve waits for client connections to be processed. Blocks! Clients are
+// disconnected if the remote service can't be dialed.
 func (xt *XTunnel) Serve() error {
 	for {
 		// wait until a client connects
 		conn, err := xt.localListener.Accept()
 		if err != nil {
 			return err
-		} else {
-			// process the clients request
-			err = xt.createConnPipe(conn)
-			if err != nil {
-				return err
-			}
 		}
-	}
+		xt.updateStats(func(stats *Stats) { stats.Accepted++ })
+		xt.emit(Event{Type: EventAccept, ClientAddress: conn.RemoteAddr().String()})
 
-	return nil
+		// process the clients request
+		err = xt.createConnPipe(conn)
+		if err != nil {
+			conn.Close()
+		}
+	}
 }
 
 // Shutdown performs cleanup.
 func (xt *XTunnel) Shutdown() error {
 	err := xt.localListener.Close()
+	xt.emit(Event{Type: EventShutdown, Err: err})
 	return err
 }
 
+// emit completes the addresses of the event and passes it to OnEvent
+func (xt *XTunnel) emit(event Event) {
+	if xt.OnEvent == nil {
+		return
+	}
+	if len(event.LocalAddress) < 1 && xt.localListener != nil {
+		event.LocalAddress = xt.localListener.Addr().String()
+	}
+	event.RemoteAddress = xt.remoteService
+	xt.OnEvent(event)
+}
+
 func createXTunnel(localService, remoteService string, config *tls.Config) *XTunnel {
+	if config != nil && len(config.ServerName) < 1 {
+		config.ServerName = serverName(remoteService)
+	}
 	return &XTunnel{
 		localService:  localService,
 		remoteService: remoteService,
@@ -91,30 +190,123 @@ func createXTunnel(localService, remoteService string, config *tls.Config) *XTun
 func (xt *XTunnel) createConnPipe(localConn net.Conn) error {
 	var err error
 	var remoteConn net.Conn
+	var handshake time.Duration
+	clientAddress := localConn.RemoteAddr().String()
+	start := time.Now()
 	if xt.config == nil {
 		remoteConn, err = net.Dial("tcp", xt.remoteService)
 	} else {
-		remoteConn, err = tls.Dial("tcp", xt.remoteService, xt.config)
+		remoteConn, handshake, err = xt.dialTLS()
 	}
+	xt.updateStats(func(stats *Stats) {
+		if err != nil {
+			stats.Failed++
+			stats.LastError, stats.LastErrorAt = err, time.Now()
+		} else {
+			stats.Active++
+		}
+	})
+	xt.emit(Event{Type: EventDial, ClientAddress: clientAddress, Duration: time.Since(start), Handshake: handshake, Err: err})
 
 	if err != nil {
 		return err
 	}
 
+	xt.updateStats(func(stats *Stats) {
+		if xt.connections == nil {
+			xt.connections = make(map[net.Conn]net.Conn)
+		}
+		xt.connections[localConn] = remoteConn
+	})
+
 	go func() {
-		Pipe(localConn, remoteConn)
+		opened := time.Now()
+		Pipe(
+			&countingConn{Conn: localConn, count: func(n int) {
+				xt.updateStats(func(stats *Stats) { stats.BytesSent += uint64(n) })
+			}},
+			&countingConn{Conn: remoteConn, count: func(n int) {
+				xt.updateStats(func(stats *Stats) { stats.BytesReceived += uint64(n) })
+			}},
+		)
 		// close connections when we're done
-		defer localConn.Close()
-		defer remoteConn.Close()
+		localConn.Close()
+		remoteConn.Close()
+		xt.updateStats(func(stats *Stats) {
+			stats.Active--
+			delete(xt.connections, localConn)
+		})
+		xt.emit(Event{Type: EventClose, ClientAddress: clientAddress, Duration: time.Since(opened)})
 	}()
 
 	return nil
 }
 
-func (xt XTunnel) LocalAddress() string {
+// dialTLS connects to the remote service like tls.Dial and measures the handshake
+func (xt *XTunnel) dialTLS() (net.Conn, time.Duration, error) {
+	conn, err := net.Dial("tcp", xt.remoteService)
+	if err != nil {
+		return nil, 0, err
+	}
+	start := time.Now()
+	tlsConn := tls.Client(conn, xt.config)
+	err = tlsConn.Handshake()
+	if err != nil {
+		conn.Close()
+		return nil, time.Since(start), err
+	}
+	return tlsConn, time.Since(start), nil
+}
+
+func (xt *XTunnel) LocalAddress() string {
 	return xt.localListener.Addr().String()
 }
 
+// RemoteAddress returns the address of the remote service
+func (xt *XTunnel) RemoteAddress() string {
+	return xt.remoteService
+}
+
+// CloseConnections closes all open client connections and their remote
+// connections and returns their number. The tunnel keeps accepting clients.
+func (xt *XTunnel) CloseConnections() int {
+	xt.statsMutex.Lock()
+	defer xt.statsMutex.Unlock()
+
+	for localConn, remoteConn := range xt.connections {
+		localConn.Close()
+		remoteConn.Close()
+	}
+	return len(xt.connections)
+}
+
+// Stats returns a snapshot of the counters
+func (xt *XTunnel) Stats() Stats {
+	xt.statsMutex.Lock()
+	defer xt.statsMutex.Unlock()
+	return xt.stats
+}
+
+func (xt *XTunnel) updateStats(update func(stats *Stats)) {
+	xt.statsMutex.Lock()
+	defer xt.statsMutex.Unlock()
+	update(&xt.stats)
+}
+
+// countingConn reports the number of bytes read
+type countingConn struct {
+	net.Conn
+	count func(n int)
+}
+
+func (c *countingConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	if n > 0 {
+		c.count(n)
+	}
+	return n, err
+}
+
 // Pipe creates a full-duplex pipe between the two sockets and transfers data from one to the other.
 func Pipe(conn1 net.Conn, conn2 net.Conn) {
 	chan1 := chanFromConn(conn1)